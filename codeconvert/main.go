@@ -1,15 +1,33 @@
 package main
 
 import (
+	"embed"
 	"fmt"
 	"io"
+	"io/fs"
 	"os"
+	"time"
 
 	"github.com/spf13/cobra"
 	"github.com/xe0r/llm-stuff/llm"
+	"github.com/xe0r/llm-stuff/llm/prompt"
+	"github.com/xe0r/llm-stuff/llm/tools"
 )
 
-func doit(inputName, outputName, language, model string) error {
+// Long files may not fit into the output token limit of the model
+const maxContinuations = 5
+
+const codeFence = "```"
+
+// Converting the same file again is free within this time with --cache
+const cacheTTL = 24 * time.Hour
+
+// Prompts can be overridden in the user prompts directory, see prompt.UserDir
+//
+//go:embed prompts/*.tmpl
+var prompts embed.FS
+
+func doit(inputName, outputName, language, model, profileName, root string, cache, refreshCache bool) error {
 	var input io.ReadCloser
 	var output io.WriteCloser
 
@@ -28,11 +46,33 @@ func doit(inputName, outputName, language, model string) error {
 		return err
 	}
 
-	token, err := llm.GetToken()
+	profile, err := llm.LoadProfile(profileName)
+	if err != nil {
+		return err
+	}
+
+	llmClient, err := llm.NewClientFromConfig(profile)
 	if err != nil {
 		return err
 	}
 
+	if cache || refreshCache {
+		dir, err := llm.DefaultCacheDir()
+		if err != nil {
+			return err
+		}
+		diskCache, err := llm.NewDiskCache(dir, cacheTTL)
+		if err != nil {
+			return err
+		}
+		_ = diskCache.Prune()
+		llmClient.SetCache(diskCache)
+		defer func() {
+			stats := llmClient.CacheStats()
+			fmt.Fprintf(os.Stderr, "Cache: %d hits, %d misses\n", stats.Hits, stats.Misses)
+		}()
+	}
+
 	if outputName != "" && outputName != "-" {
 		var err error
 		output, err = os.Create(outputName)
@@ -44,12 +84,39 @@ func doit(inputName, outputName, language, model string) error {
 	}
 	defer func() { _ = output.Close() }()
 
-	client := llm.NewChatClient(token, nil)
+	var funcs []llm.CallableFunction
+	if root != "" {
+		toolbox, err := tools.New(tools.Config{Root: root})
+		if err != nil {
+			return err
+		}
+		funcs = toolbox.ReadOnly()
+	}
+
+	client := llm.NewChatClientWithClient[string](llmClient, funcs)
+
+	embedded, err := fs.Sub(prompts, "prompts")
+	if err != nil {
+		return err
+	}
+
+	convertPrompt, err := prompt.NewDefaultLibrary(embedded).Get("convert")
+	if err != nil {
+		return err
+	}
 
-	client.SetModel(model)
+	err = prompt.Apply(client, convertPrompt, map[string]any{
+		"language": language,
+		"code":     string(content),
+		"tools":    root != "",
+	})
+	if err != nil {
+		return err
+	}
 
-	client.AddMessage("system", fmt.Sprintf("You are code conversion tool. You convert code from any language to %s. You respond with the converted code without any comments or markdown.", language))
-	client.AddMessage("user", string(content))
+	if model != "" {
+		client.SetModel(model)
+	}
 
 	chunkChan := make(chan string)
 	doneChan := make(chan struct{})
@@ -62,16 +129,23 @@ func doit(inputName, outputName, language, model string) error {
 		fmt.Fprintln(output)
 	}()
 
-	resp, err := client.GetResponse(chunkChan)
-	<-doneChan
-	if err != nil {
-		return err
+	// The response is a code block, which is prefilled and ends at its
+	// closing fence, so no markdown gets into the output
+	opts := []llm.Option{
+		llm.WithContinuation(maxContinuations),
+		llm.WithPrefill(codeFence + "\n"),
+		llm.WithStripPrefill(),
+		llm.WithStop([]string{"\n" + codeFence}),
 	}
-
-	if chunkChan == nil {
-		fmt.Fprintln(output, resp)
+	if refreshCache {
+		opts = append(opts, llm.WithCacheBypass())
 	}
-	return nil
+
+	// Continuations are streamed after they're stitched, so the chunks are
+	// the whole response
+	_, err = client.GetResponse(chunkChan, opts...)
+	<-doneChan
+	return err
 }
 
 func main() {
@@ -80,19 +154,28 @@ func main() {
 		outputName string
 		language   string
 		model      string
+		profile    string
+		root       string
+
+		cache        bool
+		refreshCache bool
 	)
 
 	cmd := &cobra.Command{
 		Use:   "codeconvert",
 		Short: "Convert code from one language to another",
 		RunE: func(cmd *cobra.Command, args []string) error {
-			return doit(inputName, outputName, language, model)
+			return doit(inputName, outputName, language, model, profile, root, cache, refreshCache)
 		},
 	}
 	cmd.Flags().StringVarP(&inputName, "input", "i", "", "Input file name")
 	cmd.Flags().StringVarP(&outputName, "output", "o", "", "Output file name")
 	cmd.Flags().StringVarP(&language, "language", "l", "Go", "Language to convert to")
-	cmd.Flags().StringVarP(&model, "model", "m", "openai/gpt-4o-mini", "Model to use")
+	cmd.Flags().StringVarP(&model, "model", "m", "", "Model to use (defaults to the profile's model)")
+	cmd.Flags().StringVarP(&root, "root", "r", "", "Project directory the model may read to understand the code")
+	cmd.Flags().BoolVar(&cache, "cache", false, "Reuse responses to identical requests, cached for a day")
+	cmd.Flags().BoolVar(&refreshCache, "refresh-cache", false, "Ignore cached responses and cache the new ones")
+	cmd.PersistentFlags().StringVarP(&profile, "profile", "p", "", "Config profile to use (defaults to $LLM_PROFILE)")
 
 	if err := cmd.Execute(); err != nil {
 		fmt.Fprintln(os.Stderr, err)
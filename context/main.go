@@ -2,21 +2,36 @@ package main
 
 import (
 	"bufio"
+	"embed"
 	"encoding/json"
 	"fmt"
+	"io/fs"
 	"os"
 	"strings"
 
+	"github.com/spf13/cobra"
 	"github.com/xe0r/llm-stuff/llm"
+	"github.com/xe0r/llm-stuff/llm/prompt"
+	"github.com/xe0r/llm-stuff/llm/tools"
 )
 
+// Prompts can be overridden in the user prompts directory, see prompt.UserDir
+//
+//go:embed prompts/*.tmpl
+var prompts embed.FS
+
 type Response struct {
 	Message string                 `json:"message" desc:"The message to be sent to the user."`
 	Context map[string]interface{} `json:"new_context,omitempty" desc:"The updated context."`
 }
 
-func run() error {
-	token, err := llm.GetToken()
+func run(model, profileName, root string, write bool, allowCommands []string) error {
+	profile, err := llm.LoadProfile(profileName)
+	if err != nil {
+		return err
+	}
+
+	llmClient, err := llm.NewClientFromConfig(profile)
 	if err != nil {
 		return err
 	}
@@ -26,38 +41,86 @@ func run() error {
 		contextContent = []byte(`{}`)
 	}
 
-	client := llm.NewChatClientWithType[Response](token, nil)
+	stdinReader := bufio.NewScanner(os.Stdin)
+
+	var funcs []llm.CallableFunction
+	var writeFuncs []llm.CallableFunction
+	if root != "" {
+		toolbox, err := tools.New(tools.Config{
+			Root:          root,
+			AllowCommands: allowCommands,
+		})
+		if err != nil {
+			return err
+		}
+		funcs = toolbox.ReadOnly()
+		if write {
+			writeFuncs = []llm.CallableFunction{toolbox.WriteFile(), toolbox.ApplyPatch()}
+			if len(allowCommands) > 0 {
+				writeFuncs = append(writeFuncs, toolbox.RunCommand())
+			}
+			funcs = append(funcs, writeFuncs...)
+		}
+	}
+
+	client := llm.NewChatClientWithClient[Response](llmClient, funcs)
+
+	// Everything that changes files or runs commands has to be confirmed
+	for _, fn := range writeFuncs {
+		client.SetToolPolicy(fn.GetName(), llm.ToolPolicyAsk)
+	}
+	client.SetApprover(llm.NewTerminalApprover(stdinReader, os.Stdout))
 
 	client.SetLogger(llm.DefaultLogger)
 
 	//client.SetModel("mistralai/mistral-nemo")
-
-	client.SetModel("openai/gpt-4o-mini")
 	//client.SetModel("meta-llama/llama-3-70b-instruct")
 	//client.SetModel("mistralai/mistral-7b-instruct")
 	//client.SetModel("google/gemini-flash-1.5")
 
-	client.SetObjectResponse()
+	// The context is a free-form map, which strict JSON schemas can't describe,
+	// so the mode is picked by what the model supports
+	client.SetResponseMode(llm.ResponseModeAuto)
 
-	client.AddMessage("system", `You are context-aware assitant. You hold a context, which is a JSON map that contains all the stuff you remember about the user.
-	Every time you receive a message from the user, you should update the context with the information from the message.
-	You respond with JSON without any extra text.
-	Your response should contain the message to be sent in field "message" and the updated context in field "new_context".
-	Your saved context from previous interactions: `+string(contextContent))
-	client.AddMessage("user", "Hello")
+	// The system prompt and the tools are the same on every turn
+	client.SetOptions(llm.WithPromptCaching())
+
+	embedded, err := fs.Sub(prompts, "prompts")
+	if err != nil {
+		return err
+	}
+
+	contextPrompt, err := prompt.NewDefaultLibrary(embedded).Get("context")
+	if err != nil {
+		return err
+	}
+
+	err = prompt.Apply(client, contextPrompt, map[string]any{
+		"context": string(contextContent),
+	})
+	if err != nil {
+		return err
+	}
+
+	if model != "" {
+		client.SetModel(model)
+	}
 
-	stdinReader := bufio.NewScanner(os.Stdin)
 	for {
 		chunkReader := llm.NewChunkReader()
 
 		chunkReader.Enable()
 
-		resp, err := client.GetResponse(chunkReader.Chan())
+		resp, result, err := client.GetResponseWithResult(chunkReader.Chan())
 		chunkReader.Wait()
 		if err != nil {
 			return err
 		}
 
+		usage := result.Usage
+		fmt.Fprintf(os.Stderr, "Tokens: %d prompt (%d cached), %d completion\n",
+			usage.PromptTokens, usage.CachedTokens(), usage.CompletionTokens)
+
 		fmt.Printf("%s\n", resp.Message)
 
 		if resp.Context != nil {
@@ -87,7 +150,28 @@ func run() error {
 }
 
 func main() {
-	if err := run(); err != nil {
+	var (
+		model   string
+		profile string
+		root          string
+		write         bool
+		allowCommands []string
+	)
+
+	cmd := &cobra.Command{
+		Use:   "context",
+		Short: "Context-aware chat assistant",
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return run(model, profile, root, write, allowCommands)
+		},
+	}
+	cmd.Flags().StringVarP(&model, "model", "m", "", "Model to use (defaults to the profile's model)")
+	cmd.Flags().StringVarP(&root, "root", "r", "", "Directory the assistant may read with tools")
+	cmd.Flags().BoolVarP(&write, "write", "w", false, "Also allow to change files and run commands in the root directory, every call has to be confirmed")
+	cmd.Flags().StringSliceVar(&allowCommands, "allow-command", nil, "Command the assistant may run with --write, can be repeated")
+	cmd.PersistentFlags().StringVarP(&profile, "profile", "p", "", "Config profile to use (defaults to $LLM_PROFILE)")
+
+	if err := cmd.Execute(); err != nil {
 		fmt.Fprintf(os.Stderr, "%v\n", err)
 		os.Exit(1)
 	}
@@ -0,0 +1,451 @@
+// Package agent works towards a goal on top of llm.ChatClient: it optionally
+// makes a plan, runs the tool loop until the model answers, and optionally
+// checks the answer against the goal and continues until it's achieved. The
+// model keeps notes in a scratchpad through tools. When a budget runs out,
+// the agent stops with a checkpoint that can be resumed later.
+//
+// Agents are composed with SubAgent, which wraps a chat client as a tool,
+// and Team, in which agents hand off a conversation to each other.
+package agent
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/xe0r/llm-stuff/llm"
+)
+
+// DefaultMaxIterations is used if Config.MaxIterations is not set.
+const DefaultMaxIterations = 5
+
+// DefaultBudget is used if Config.Budget is not set.
+var DefaultBudget = llm.Limits{
+	MaxRounds:        50,
+	MaxRepeatedCalls: 3,
+}
+
+// StopReasonMaxIterations means that the reflection didn't accept any of
+// the answers.
+const StopReasonMaxIterations llm.StopReason = "max_iterations"
+
+type Config struct {
+	Goal string
+	// Additional instructions for the system prompt, e.g. how to work
+	Instructions string
+	Tools        []llm.CallableFunction
+	// Model of all requests, the default model of the client if empty
+	Model string
+
+	// Make a plan before acting
+	Plan bool
+	// Check the answer against the goal and continue if it's not achieved
+	Reflect bool
+	// Answers checked by the reflection, DefaultMaxIterations if 0
+	MaxIterations int
+
+	// Budget of the whole run, including planning and reflection. MaxRounds
+	// counts rounds of tool calls. DefaultBudget if zero.
+	Budget llm.Limits
+
+	// OnStep is called for every step of the agent, e.g. to show progress
+	OnStep func(Step)
+}
+
+type StepKind string
+
+const (
+	StepPlan       StepKind = "plan"
+	StepToolCall   StepKind = "tool_call"
+	StepAnswer     StepKind = "answer"
+	StepReflection StepKind = "reflection"
+)
+
+type Step struct {
+	Kind      StepKind
+	Iteration int
+	// The plan, the answer as text, or the feedback of the reflection
+	Content string
+
+	// Tool call
+	Tool      string
+	Arguments string
+	Result    string
+	// Usage of the requests made by the tool, e.g. by a sub-agent
+	Usage llm.Usage
+
+	// Reflection accepted the answer
+	Done bool
+}
+
+// BudgetError is returned when a budget runs out or the iterations end
+// before the goal is achieved. The answer made so far is returned with it.
+type BudgetError struct {
+	Reason     llm.StopReason
+	Checkpoint *Checkpoint
+}
+
+func (e *BudgetError) Error() string {
+	return fmt.Sprintf("agent stopped: %s", e.Reason)
+}
+
+// Agent works towards the goal with the final answer of type T. It's not
+// safe for concurrent use.
+type Agent[T any] struct {
+	client     *llm.Client
+	cfg        Config
+	worker     *llm.ChatClient[T]
+	scratchpad *Scratchpad
+
+	plan      []string
+	iteration int
+
+	// Spent budget
+	rounds    int
+	toolCalls int
+	usage     llm.Usage
+	duration  time.Duration
+}
+
+type plan struct {
+	Steps []string `json:"steps" desc:"Steps to achieve the goal, in order."`
+}
+
+type reflection struct {
+	Done     bool   `json:"done" desc:"Whether the answer achieves the goal."`
+	Feedback string `json:"feedback" desc:"What is missing or wrong in the answer, empty if the goal is achieved."`
+}
+
+const planPrompt = `You plan how to achieve a goal with the available tools. Respond with short, concrete steps.
+
+Tools:
+%s`
+
+const systemPrompt = `You work towards a goal using the tools. Keep important findings and the progress in the scratchpad with write_note, and read it with read_notes. When the goal is achieved, respond with the final answer.
+
+Goal: %s`
+
+const reflectPrompt = `You check whether an answer achieves a goal. If it doesn't, explain briefly what is missing or wrong.`
+
+const continuePrompt = "Continue working on the goal."
+
+const feedbackPrompt = "The answer doesn't achieve the goal yet: %s\nContinue working on the goal."
+
+func New[T any](client *llm.Client, cfg Config) *Agent[T] {
+	if cfg.Budget == (llm.Limits{}) {
+		cfg.Budget = DefaultBudget
+	}
+	if cfg.MaxIterations <= 0 {
+		cfg.MaxIterations = DefaultMaxIterations
+	}
+
+	a := &Agent[T]{
+		client:     client,
+		cfg:        cfg,
+		scratchpad: NewScratchpad(),
+	}
+
+	var funcs []llm.CallableFunction
+	for _, fn := range cfg.Tools {
+		funcs = append(funcs, &stepTool{CallableFunction: fn, onStep: a.step, onUsage: a.addUsage})
+	}
+	for _, fn := range a.scratchpad.Tools() {
+		funcs = append(funcs, &stepTool{CallableFunction: fn, onStep: a.step})
+	}
+	a.worker = llm.NewChatClientWithClient[T](client, funcs)
+	if cfg.Model != "" {
+		a.worker.SetModel(cfg.Model)
+	}
+	return a
+}
+
+// Worker returns the chat client that does the work, e.g. to set tool
+// policies or options.
+func (a *Agent[T]) Worker() *llm.ChatClient[T] {
+	return a.worker
+}
+
+func (a *Agent[T]) Scratchpad() *Scratchpad {
+	return a.scratchpad
+}
+
+// Plan returns the steps of the plan, if it's made.
+func (a *Agent[T]) Plan() []string {
+	return a.plan
+}
+
+// Usage returns the usage of all requests of the agent and its sub-agents.
+func (a *Agent[T]) Usage() llm.Usage {
+	return a.usage
+}
+
+// Run works towards the goal and returns the final answer. If a budget runs
+// out, it returns the last answer and BudgetError with a checkpoint, which
+// can be resumed by an agent with a larger budget.
+func (a *Agent[T]) Run() (T, error) {
+	var answer T
+
+	if len(a.worker.Messages()) == 0 {
+		if a.cfg.Plan && a.plan == nil {
+			if err := a.makePlan(); err != nil {
+				return answer, err
+			}
+		}
+
+		a.worker.AddMessage("system", a.systemPrompt())
+		a.worker.AddMessage("user", a.cfg.Goal)
+	}
+
+	for {
+		if reason := a.exhausted(); reason != "" {
+			return answer, a.budgetError(reason)
+		}
+		if a.iteration >= a.cfg.MaxIterations {
+			return answer, a.budgetError(StopReasonMaxIterations)
+		}
+		a.iteration++
+		a.prepareTurn()
+
+		start := time.Now()
+		result, res, err := a.worker.GetResponseWithResult(nil, llm.WithLimits(a.remaining()))
+		a.spend(res, time.Since(start))
+
+		var limitErr *llm.LimitError
+		if errors.As(err, &limitErr) {
+			return answer, a.budgetError(limitErr.Reason)
+		}
+		if err != nil {
+			return answer, err
+		}
+
+		answer = result
+		text := answerText(answer)
+		a.step(Step{Kind: StepAnswer, Content: text})
+
+		// The model was made to answer by the remaining budget
+		if reason := res.StopReason; reason != llm.StopReasonDone && reason != llm.StopReasonRepeatedCalls {
+			return answer, a.budgetError(reason)
+		}
+
+		if !a.cfg.Reflect {
+			return answer, nil
+		}
+
+		review, err := a.reflect(text)
+		if err != nil {
+			return answer, err
+		}
+		if review.Done {
+			return answer, nil
+		}
+		a.worker.AddMessage("user", fmt.Sprintf(feedbackPrompt, review.Feedback))
+	}
+}
+
+// prepareTurn makes the conversation ready for the next request: tool calls
+// left without results by a limit are dropped, and an answer made when the
+// budget ran out is followed by a request to continue the work.
+func (a *Agent[T]) prepareTurn() {
+	messages := a.worker.Messages()
+	last := len(messages) - 1
+	if last < 0 || messages[last].Role != "assistant" {
+		return
+	}
+
+	if len(messages[last].ToolCalls) > 0 {
+		a.worker.SetMessages(messages[:last])
+	}
+	a.worker.AddMessage("user", continuePrompt)
+}
+
+func (a *Agent[T]) makePlan() error {
+	planner := llm.NewChatClientWithClient[plan](a.client, nil)
+	if a.cfg.Model != "" {
+		planner.SetModel(a.cfg.Model)
+	}
+
+	var tools strings.Builder
+	for _, fn := range a.cfg.Tools {
+		fmt.Fprintf(&tools, "- %s: %s\n", fn.GetName(), fn.GetDescription())
+	}
+	planner.AddMessage("system", fmt.Sprintf(planPrompt, tools.String()))
+	planner.AddMessage("user", a.cfg.Goal)
+
+	start := time.Now()
+	result, res, err := planner.GetResponseWithResult(nil)
+	a.spend(res, time.Since(start))
+	if err != nil {
+		return fmt.Errorf("planning failed: %w", err)
+	}
+
+	a.plan = result.Steps
+	if a.plan == nil {
+		a.plan = []string{}
+	}
+	a.step(Step{Kind: StepPlan, Content: formatPlan(a.plan)})
+	return nil
+}
+
+func (a *Agent[T]) reflect(answer string) (*reflection, error) {
+	reviewer := llm.NewChatClientWithClient[reflection](a.client, nil)
+	if a.cfg.Model != "" {
+		reviewer.SetModel(a.cfg.Model)
+	}
+
+	var prompt strings.Builder
+	fmt.Fprintf(&prompt, "Goal: %s\n\n", a.cfg.Goal)
+	if len(a.plan) > 0 {
+		fmt.Fprintf(&prompt, "Plan:\n%s\n", formatPlan(a.plan))
+	}
+	if notes := a.scratchpad.String(); notes != "" {
+		fmt.Fprintf(&prompt, "Notes:\n%s\n", notes)
+	}
+	fmt.Fprintf(&prompt, "Answer:\n%s", answer)
+
+	reviewer.AddMessage("system", reflectPrompt)
+	reviewer.AddMessage("user", prompt.String())
+
+	start := time.Now()
+	result, res, err := reviewer.GetResponseWithResult(nil)
+	a.spend(res, time.Since(start))
+	if err != nil {
+		return nil, fmt.Errorf("reflection failed: %w", err)
+	}
+
+	a.step(Step{Kind: StepReflection, Content: result.Feedback, Done: result.Done})
+	return &result, nil
+}
+
+func (a *Agent[T]) systemPrompt() string {
+	var b strings.Builder
+	fmt.Fprintf(&b, systemPrompt, a.cfg.Goal)
+	if len(a.plan) > 0 {
+		fmt.Fprintf(&b, "\n\nPlan:\n%s", formatPlan(a.plan))
+	}
+	if a.cfg.Instructions != "" {
+		b.WriteString("\n\n")
+		b.WriteString(a.cfg.Instructions)
+	}
+	return b.String()
+}
+
+func (a *Agent[T]) step(step Step) {
+	if a.cfg.OnStep == nil {
+		return
+	}
+	step.Iteration = a.iteration
+	a.cfg.OnStep(step)
+}
+
+// spend adds the result of a call to the spent budget.
+func (a *Agent[T]) spend(res *llm.RunResult, duration time.Duration) {
+	a.duration += duration
+	if res == nil {
+		return
+	}
+	a.rounds += res.Rounds
+	a.toolCalls += res.ToolCalls
+	a.usage.Add(&res.Usage)
+}
+
+// addUsage adds the usage of a sub-agent. Its requests count towards the
+// budget too, but the current tool loop sees them only when it ends.
+func (a *Agent[T]) addUsage(usage llm.Usage) {
+	a.usage.Add(&usage)
+}
+
+// exhausted returns the budget that ran out, if any.
+func (a *Agent[T]) exhausted() llm.StopReason {
+	budget := a.cfg.Budget
+	switch {
+	case budget.MaxRounds > 0 && a.rounds >= budget.MaxRounds:
+		return llm.StopReasonMaxRounds
+	case budget.MaxToolCalls > 0 && a.toolCalls >= budget.MaxToolCalls:
+		return llm.StopReasonMaxToolCalls
+	case budget.MaxDuration > 0 && a.duration >= budget.MaxDuration:
+		return llm.StopReasonMaxDuration
+	case budget.MaxTokens > 0 && a.usage.TotalTokens >= budget.MaxTokens:
+		return llm.StopReasonMaxTokens
+	case budget.MaxCost > 0 && a.usage.Cost >= budget.MaxCost:
+		return llm.StopReasonMaxCost
+	}
+	return ""
+}
+
+// remaining returns limits of the next tool loop, what's left of the budget.
+func (a *Agent[T]) remaining() llm.Limits {
+	budget := a.cfg.Budget
+	limits := llm.Limits{
+		MaxRepeatedCalls: budget.MaxRepeatedCalls,
+	}
+	if budget.MaxRounds > 0 {
+		limits.MaxRounds = budget.MaxRounds - a.rounds
+	}
+	if budget.MaxToolCalls > 0 {
+		limits.MaxToolCalls = budget.MaxToolCalls - a.toolCalls
+	}
+	if budget.MaxDuration > 0 {
+		limits.MaxDuration = budget.MaxDuration - a.duration
+	}
+	if budget.MaxTokens > 0 {
+		limits.MaxTokens = budget.MaxTokens - a.usage.TotalTokens
+	}
+	if budget.MaxCost > 0 {
+		limits.MaxCost = budget.MaxCost - a.usage.Cost
+	}
+	return limits
+}
+
+func (a *Agent[T]) budgetError(reason llm.StopReason) error {
+	return &BudgetError{
+		Reason:     reason,
+		Checkpoint: a.Checkpoint(),
+	}
+}
+
+// stepTool reports calls of a tool as steps and rolls up the usage of
+// sub-agents. Both callbacks are optional.
+type stepTool struct {
+	llm.CallableFunction
+	onStep  func(Step)
+	onUsage func(llm.Usage)
+}
+
+func (t *stepTool) Call(args string) string {
+	result, usage := callWithUsage(t.CallableFunction, args)
+	if t.onUsage != nil {
+		t.onUsage(usage)
+	}
+	if t.onStep != nil {
+		t.onStep(Step{
+			Kind:      StepToolCall,
+			Tool:      t.GetName(),
+			Arguments: args,
+			Result:    result,
+			Usage:     usage,
+		})
+	}
+	return result
+}
+
+// answerText returns the answer as text for the reflection and steps.
+func answerText(answer any) string {
+	if s, ok := answer.(string); ok {
+		return s
+	}
+	data, err := json.Marshal(answer)
+	if err != nil {
+		return fmt.Sprint(answer)
+	}
+	return string(data)
+}
+
+func formatPlan(steps []string) string {
+	var b strings.Builder
+	for i, step := range steps {
+		fmt.Fprintf(&b, "%d. %s\n", i+1, step)
+	}
+	return b.String()
+}
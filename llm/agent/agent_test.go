@@ -0,0 +1,257 @@
+package agent
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+
+	"github.com/xe0r/llm-stuff/llm"
+)
+
+// newStubClient starts a stub of the API. Every response uses 10 tokens and
+// requests with unanswered tool calls are rejected, like real APIs do.
+func newStubClient(t *testing.T, respond func(req *llm.Request) llm.Message) *llm.Client {
+	t.Helper()
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var req llm.Request
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			t.Error(err)
+		}
+		w.Header().Set("Content-Type", "application/json")
+		if err := checkToolCallsAnswered(req.Messages); err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			fmt.Fprintf(w, `{"error":{"message":%q,"code":400}}`, err.Error())
+			return
+		}
+
+		msg := respond(&req)
+		finish := llm.FinishReasonStop
+		if len(msg.ToolCalls) > 0 {
+			finish = llm.FinishReasonToolCalls
+		}
+		json.NewEncoder(w).Encode(llm.Response{
+			Choices: []llm.Choice{{Message: &msg, FinishReason: finish}},
+			Usage:   &llm.Usage{TotalTokens: 10},
+		})
+	}))
+	t.Cleanup(srv.Close)
+
+	c := llm.NewClient("token")
+	c.SetBaseURL(srv.URL)
+	return c
+}
+
+func checkToolCallsAnswered(messages []llm.Message) error {
+	for i, msg := range messages {
+		for _, toolCall := range msg.ToolCalls {
+			answered := false
+			for _, next := range messages[i+1:] {
+				if next.Role != "tool" {
+					break
+				}
+				answered = answered || next.ToolCallID == toolCall.ID
+			}
+			if !answered {
+				return fmt.Errorf("call %s of message %d is not answered", toolCall.ID, i)
+			}
+		}
+	}
+	return nil
+}
+
+func toolCallMessage(id, name, args string) llm.Message {
+	return llm.Message{Role: "assistant", ToolCalls: []llm.ToolCall{{
+		ID:       id,
+		Type:     "function",
+		Function: llm.FunctionCall{Name: name, Arguments: args},
+	}}}
+}
+
+func lastMessage(req *llm.Request) llm.Message {
+	return req.Messages[len(req.Messages)-1]
+}
+
+func TestRunBudgetAndResume(t *testing.T) {
+	// The model counts in the scratchpad until it's made to answer
+	count := 0
+	respond := func(req *llm.Request) llm.Message {
+		if req.ToolChoice == llm.ToolChoiceNone {
+			return llm.Message{Role: "assistant", Content: fmt.Sprint("Counted to ", count)}
+		}
+		if lastMessage(req).Content == continuePrompt {
+			return llm.Message{Role: "assistant", Content: "Done"}
+		}
+		count++
+		return toolCallMessage(fmt.Sprint("call", count), "write_note", fmt.Sprintf(`{"key": "count", "note": "%d"}`, count))
+	}
+	c := newStubClient(t, func(req *llm.Request) llm.Message { return respond(req) })
+
+	var steps []Step
+	a := New[string](c, Config{
+		Goal:   "Count",
+		Model:  "m",
+		Budget: llm.Limits{MaxRounds: 2},
+		OnStep: func(step Step) { steps = append(steps, step) },
+	})
+
+	answer, err := a.Run()
+	var budgetErr *BudgetError
+	if !errors.As(err, &budgetErr) || budgetErr.Reason != llm.StopReasonMaxRounds {
+		t.Fatalf("got error %v, want BudgetError", err)
+	}
+	if answer != "Counted to 2" {
+		t.Errorf("got answer %q", answer)
+	}
+	if len(steps) != 3 || steps[0].Kind != StepToolCall || steps[2].Kind != StepAnswer {
+		t.Errorf("got steps %+v", steps)
+	}
+
+	cp := budgetErr.Checkpoint
+	if cp.Goal != "Count" || cp.Rounds != 2 || cp.ToolCalls != 2 || cp.Usage.TotalTokens != 30 || cp.Iteration != 1 {
+		t.Errorf("got checkpoint %+v", cp)
+	}
+	if cp.Scratchpad["count"] != "2" {
+		t.Errorf("got notes %v", cp.Scratchpad)
+	}
+
+	// The same budget is still exhausted, no requests are made
+	if _, err := a.Run(); !errors.As(err, &budgetErr) || count != 2 {
+		t.Fatalf("got error %v after %d calls", err, count)
+	}
+
+	var buf bytes.Buffer
+	if err := cp.Save(&buf); err != nil {
+		t.Fatal(err)
+	}
+	loaded, err := LoadCheckpoint(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	other := New[string](c, Config{Goal: "Other"})
+	if err := other.Resume(loaded); err == nil {
+		t.Error("checkpoint of another goal was resumed")
+	}
+
+	resumed := New[string](c, Config{Model: "m", Budget: llm.Limits{MaxRounds: 4}})
+	if err := resumed.Resume(loaded); err != nil {
+		t.Fatal(err)
+	}
+	answer, err = resumed.Run()
+	if err != nil || answer != "Done" {
+		t.Fatalf("got %q, %v", answer, err)
+	}
+	if note, _ := resumed.Scratchpad().Get("count"); note != "2" || resumed.Usage().TotalTokens != 40 {
+		t.Errorf("got note %q, usage %+v", note, resumed.Usage())
+	}
+	if cp := resumed.Checkpoint(); cp.Iteration != 2 || cp.Rounds != 2 {
+		t.Errorf("got checkpoint %+v", cp)
+	}
+}
+
+func TestResumeUnansweredCalls(t *testing.T) {
+	var requests []*llm.Request
+	c := newStubClient(t, func(req *llm.Request) llm.Message {
+		requests = append(requests, req)
+		return llm.Message{Role: "assistant", Content: "Done"}
+	})
+
+	// Checkpoints may end with calls that were never run
+	a := New[string](c, Config{Model: "m"})
+	err := a.Resume(&Checkpoint{
+		Goal: "Ping",
+		Messages: []llm.Message{
+			{Role: "system", Content: "Work."},
+			{Role: "user", Content: "Ping"},
+			toolCallMessage("call1", "ping", "{}"),
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	answer, err := a.Run()
+	if err != nil || answer != "Done" {
+		t.Fatalf("got %q, %v", answer, err)
+	}
+	if len(requests) != 1 {
+		t.Fatalf("got %d requests", len(requests))
+	}
+	if messages := requests[0].Messages; len(messages) != 3 || messages[2].Content != continuePrompt {
+		t.Errorf("got messages %+v", messages)
+	}
+}
+
+func TestRunMaxIterations(t *testing.T) {
+	reviews := 0
+	c := newStubClient(t, func(req *llm.Request) llm.Message {
+		if req.Messages[0].Content == reflectPrompt {
+			reviews++
+			return llm.Message{Role: "assistant", Content: `{"done": false, "feedback": "Not yet"}`}
+		}
+		return llm.Message{Role: "assistant", Content: "Answer"}
+	})
+
+	a := New[string](c, Config{Goal: "Answer", Model: "m", Reflect: true, MaxIterations: 2})
+	answer, err := a.Run()
+	var budgetErr *BudgetError
+	if !errors.As(err, &budgetErr) || budgetErr.Reason != StopReasonMaxIterations {
+		t.Fatalf("got error %v, want BudgetError", err)
+	}
+	if answer != "Answer" || reviews != 2 {
+		t.Errorf("got %q after %d reviews", answer, reviews)
+	}
+
+	// Feedback of the reflection is sent to the worker
+	messages := a.Worker().Messages()
+	if msg := messages[len(messages)-1]; msg.Role != "user" || msg.Content != fmt.Sprintf(feedbackPrompt, "Not yet") {
+		t.Errorf("got last message %+v", msg)
+	}
+}
+
+func TestScratchpadTools(t *testing.T) {
+	s := NewScratchpad()
+	tools := make(map[string]llm.CallableFunction)
+	for _, fn := range s.Tools() {
+		tools[fn.GetName()] = fn
+	}
+
+	tests := []struct {
+		tool string
+		args string
+		want string
+	}{
+		{"write_note", `{"key": "plan", "note": "Read"}`, `{}`},
+		{"write_note", `{"key": "todo", "note": "Write"}`, `{}`},
+		{"write_note", `{"note": "No key"}`, `{"error":"key is empty"}`},
+		{"read_notes", `{}`, `{"notes":{"plan":"Read","todo":"Write"}}`},
+		{"read_notes", `{"key": "plan"}`, `{"notes":{"plan":"Read"}}`},
+		{"read_notes", `{"key": "missing"}`, `{"notes":null,"error":"note missing not found"}`},
+		// An empty note deletes it
+		{"write_note", `{"key": "todo", "note": ""}`, `{}`},
+		{"read_notes", `{}`, `{"notes":{"plan":"Read"}}`},
+	}
+
+	for _, tt := range tests {
+		if got := tools[tt.tool].Call(tt.args); got != tt.want {
+			t.Errorf("%s(%s): got %s, want %s", tt.tool, tt.args, got, tt.want)
+		}
+	}
+
+	if got := s.String(); got != "plan: Read\n" {
+		t.Errorf("got notes %q", got)
+	}
+
+	// Notes of a checkpoint are copied
+	notes := map[string]string{"a": "1"}
+	s.setNotes(notes)
+	notes["a"] = "2"
+	if got := s.Notes(); !reflect.DeepEqual(got, map[string]string{"a": "1"}) {
+		t.Errorf("got notes %v", got)
+	}
+}
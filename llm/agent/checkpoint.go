@@ -0,0 +1,77 @@
+package agent
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"slices"
+	"time"
+
+	"github.com/xe0r/llm-stuff/llm"
+)
+
+// Checkpoint is the state of an agent, saved when a budget runs out. It can
+// be resumed by an agent with a larger budget, budgets count the spending
+// before the checkpoint too.
+type Checkpoint struct {
+	Goal       string            `json:"goal"`
+	Plan       []string          `json:"plan,omitempty"`
+	Scratchpad map[string]string `json:"scratchpad,omitempty"`
+	Messages   []llm.Message     `json:"messages"`
+
+	Iteration int          `json:"iteration"`
+	Rounds    int          `json:"rounds"`
+	ToolCalls int          `json:"tool_calls"`
+	Usage     llm.Usage    `json:"usage"`
+	Duration  llm.Duration `json:"duration"`
+}
+
+// Save writes the checkpoint as JSON.
+func (c *Checkpoint) Save(w io.Writer) error {
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(c)
+}
+
+// LoadCheckpoint reads a checkpoint written by Save.
+func LoadCheckpoint(r io.Reader) (*Checkpoint, error) {
+	var cp Checkpoint
+	if err := json.NewDecoder(r).Decode(&cp); err != nil {
+		return nil, fmt.Errorf("invalid checkpoint: %w", err)
+	}
+	return &cp, nil
+}
+
+// Checkpoint returns the current state of the agent.
+func (a *Agent[T]) Checkpoint() *Checkpoint {
+	return &Checkpoint{
+		Goal:       a.cfg.Goal,
+		Plan:       slices.Clone(a.plan),
+		Scratchpad: a.scratchpad.Notes(),
+		Messages:   a.worker.Messages(),
+		Iteration:  a.iteration,
+		Rounds:     a.rounds,
+		ToolCalls:  a.toolCalls,
+		Usage:      a.usage,
+		Duration:   llm.Duration(a.duration),
+	}
+}
+
+// Resume restores the state of the checkpoint, Run continues from it. The
+// goal of the agent must be empty or the same as the one of the checkpoint.
+func (a *Agent[T]) Resume(cp *Checkpoint) error {
+	if a.cfg.Goal != "" && a.cfg.Goal != cp.Goal {
+		return fmt.Errorf("checkpoint is for another goal: %s", cp.Goal)
+	}
+
+	a.cfg.Goal = cp.Goal
+	a.plan = slices.Clone(cp.Plan)
+	a.scratchpad.setNotes(cp.Scratchpad)
+	a.worker.SetMessages(cp.Messages)
+	a.iteration = cp.Iteration
+	a.rounds = cp.Rounds
+	a.toolCalls = cp.ToolCalls
+	a.usage = cp.Usage
+	a.duration = time.Duration(cp.Duration)
+	return nil
+}
@@ -0,0 +1,130 @@
+package agent
+
+import (
+	"maps"
+	"slices"
+	"strings"
+	"sync"
+
+	"github.com/xe0r/llm-stuff/llm"
+)
+
+// Scratchpad holds notes the model keeps while it works, e.g. findings and
+// the progress of the plan. The notes are kept in checkpoints, so they
+// survive a resume even if the conversation is long.
+type Scratchpad struct {
+	mu    sync.Mutex
+	notes map[string]string
+}
+
+func NewScratchpad() *Scratchpad {
+	return &Scratchpad{
+		notes: make(map[string]string),
+	}
+}
+
+func (s *Scratchpad) Get(key string) (string, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	note, ok := s.notes[key]
+	return note, ok
+}
+
+// Set sets the note, an empty note is deleted.
+func (s *Scratchpad) Set(key, note string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if note == "" {
+		delete(s.notes, key)
+		return
+	}
+	s.notes[key] = note
+}
+
+// Notes returns a copy of all notes.
+func (s *Scratchpad) Notes() map[string]string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return maps.Clone(s.notes)
+}
+
+// setNotes replaces all notes, e.g. with ones from a checkpoint.
+func (s *Scratchpad) setNotes(notes map[string]string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.notes = maps.Clone(notes)
+	if s.notes == nil {
+		s.notes = make(map[string]string)
+	}
+}
+
+// String returns the notes sorted by key, one "key: note" per line.
+func (s *Scratchpad) String() string {
+	notes := s.Notes()
+
+	keys := make([]string, 0, len(notes))
+	for key := range notes {
+		keys = append(keys, key)
+	}
+	slices.Sort(keys)
+
+	var b strings.Builder
+	for _, key := range keys {
+		b.WriteString(key)
+		b.WriteString(": ")
+		b.WriteString(notes[key])
+		b.WriteString("\n")
+	}
+	return b.String()
+}
+
+type ReadNotesRequest struct {
+	Key string `json:"key,omitempty" desc:"Key of the note to read. All notes are returned if it's empty."`
+}
+
+type ReadNotesResponse struct {
+	Notes map[string]string `json:"notes"`
+	Error string            `json:"error,omitempty"`
+}
+
+type WriteNoteRequest struct {
+	Key  string `json:"key" desc:"Short key of the note, e.g. \"plan\" or \"findings\"."`
+	Note string `json:"note" desc:"Text of the note, it replaces the previous one. An empty note deletes it."`
+}
+
+type WriteNoteResponse struct {
+	Error string `json:"error,omitempty"`
+}
+
+// Tools returns the read_notes and write_note tools.
+func (s *Scratchpad) Tools() []llm.CallableFunction {
+	return []llm.CallableFunction{
+		llm.NewCallableFunction("read_notes", "Reads notes from the scratchpad.", s.readNotes),
+		llm.NewCallableFunction("write_note", "Writes a note to the scratchpad, to keep findings and progress for later.", s.writeNote),
+	}
+}
+
+func (s *Scratchpad) readNotes(req *ReadNotesRequest) *ReadNotesResponse {
+	if req.Key == "" {
+		return &ReadNotesResponse{Notes: s.Notes()}
+	}
+
+	note, ok := s.Get(req.Key)
+	if !ok {
+		return &ReadNotesResponse{Error: "note " + req.Key + " not found"}
+	}
+	return &ReadNotesResponse{Notes: map[string]string{req.Key: note}}
+}
+
+func (s *Scratchpad) writeNote(req *WriteNoteRequest) *WriteNoteResponse {
+	if req.Key == "" {
+		return &WriteNoteResponse{Error: "key is empty"}
+	}
+
+	s.Set(req.Key, req.Note)
+	return &WriteNoteResponse{}
+}
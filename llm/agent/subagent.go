@@ -0,0 +1,113 @@
+package agent
+
+import (
+	"encoding/json"
+	"sync"
+
+	"github.com/xe0r/llm-stuff/llm"
+)
+
+// SubAgent is a tool that delegates a task to a specialised chat client,
+// e.g. a reviewer with its own system prompt, tools and model. Every call
+// runs in a clone of the client, so the calls are isolated from each other
+// and from the conversation of the parent. The request is sent to the
+// sub-agent as JSON and its typed result is returned to the parent.
+//
+// Agents and teams add the usage of sub-agents among their tools to their
+// own, other parents can read it with Usage.
+type SubAgent[Req any, Resp any] struct {
+	client *llm.ChatClient[Resp]
+	opts   []llm.Option
+	// Provides the name, description and parameters
+	fn *llm.CallableFunctionImpl[Req, SubAgentResponse[Resp]]
+
+	mu    sync.Mutex
+	usage llm.Usage
+}
+
+type SubAgentResponse[Resp any] struct {
+	Result *Resp  `json:"result,omitempty"`
+	Error  string `json:"error,omitempty"`
+}
+
+// NewSubAgent wraps the client as a tool. The options are applied to every
+// call, e.g. llm.WithLimits. Req must be a struct, as the parameters of
+// tools are objects.
+func NewSubAgent[Req any, Resp any](name, description string, client *llm.ChatClient[Resp], opts ...llm.Option) *SubAgent[Req, Resp] {
+	return &SubAgent[Req, Resp]{
+		client: client,
+		opts:   opts,
+		fn:     llm.NewCallableFunction[Req, SubAgentResponse[Resp]](name, description, nil),
+	}
+}
+
+func (s *SubAgent[Req, Resp]) GetName() string {
+	return s.fn.GetName()
+}
+
+func (s *SubAgent[Req, Resp]) GetDescription() string {
+	return s.fn.GetDescription()
+}
+
+func (s *SubAgent[Req, Resp]) GetParameters() llm.ParamDef {
+	return s.fn.GetParameters()
+}
+
+func (s *SubAgent[Req, Resp]) Call(args string) string {
+	result, _ := s.CallWithUsage(args)
+	return result
+}
+
+// CallWithUsage works like Call, but also returns the usage of the call.
+func (s *SubAgent[Req, Resp]) CallWithUsage(args string) (string, llm.Usage) {
+	resp, usage := s.run(args)
+	s.mu.Lock()
+	s.usage.Add(&usage)
+	s.mu.Unlock()
+
+	respJSON, _ := json.Marshal(resp)
+	return string(respJSON), usage
+}
+
+// Usage returns the usage of all calls of the sub-agent.
+func (s *SubAgent[Req, Resp]) Usage() llm.Usage {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.usage
+}
+
+func (s *SubAgent[Req, Resp]) run(args string) (*SubAgentResponse[Resp], llm.Usage) {
+	req := new(Req)
+	if err := json.Unmarshal([]byte(args), req); err != nil {
+		return &SubAgentResponse[Resp]{Error: "Invalid arguments"}, llm.Usage{}
+	}
+	// Unknown fields are dropped
+	task, err := json.Marshal(req)
+	if err != nil {
+		return &SubAgentResponse[Resp]{Error: err.Error()}, llm.Usage{}
+	}
+
+	conv := s.client.Clone()
+	conv.AddMessage("user", string(task))
+	result, res, err := conv.GetResponseWithResult(nil, s.opts...)
+	if err != nil {
+		return &SubAgentResponse[Resp]{Error: err.Error()}, res.Usage
+	}
+	return &SubAgentResponse[Resp]{Result: &result}, res.Usage
+}
+
+// usageCaller is implemented by tools that make requests of their own, so
+// that their usage is rolled up to the caller.
+type usageCaller interface {
+	CallWithUsage(args string) (string, llm.Usage)
+}
+
+// callWithUsage calls the tool and returns the usage of its requests, if
+// it reports them.
+func callWithUsage(fn llm.CallableFunction, args string) (string, llm.Usage) {
+	if caller, ok := fn.(usageCaller); ok {
+		return caller.CallWithUsage(args)
+	}
+	return fn.Call(args), llm.Usage{}
+}
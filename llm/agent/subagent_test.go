@@ -0,0 +1,126 @@
+package agent
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/xe0r/llm-stuff/llm"
+)
+
+type reviewRequest struct {
+	Code string `json:"code"`
+}
+
+type reviewResult struct {
+	Verdict string `json:"verdict"`
+}
+
+const reviewerPrompt = "Review the code."
+
+// newReviewer returns a sub-agent that answers with the code it reviews, and
+// records the requests sent for it.
+func newReviewer(t *testing.T, parent func(req *llm.Request) llm.Message) (*SubAgent[reviewRequest, reviewResult], *llm.Client, func() [][]llm.Message) {
+	var mu sync.Mutex
+	var sent [][]llm.Message
+	c := newStubClient(t, func(req *llm.Request) llm.Message {
+		if req.Messages[0].Content != reviewerPrompt {
+			return parent(req)
+		}
+
+		mu.Lock()
+		sent = append(sent, req.Messages)
+		mu.Unlock()
+
+		var task reviewRequest
+		if err := json.Unmarshal([]byte(lastMessage(req).Content), &task); err != nil {
+			t.Error(err)
+		}
+		return llm.Message{Role: "assistant", Content: fmt.Sprintf(`{"verdict": "checked %s"}`, task.Code)}
+	})
+
+	reviewer := llm.NewChatClientWithClient[reviewResult](c, nil)
+	reviewer.SetModel("m")
+	reviewer.AddMessage("system", reviewerPrompt)
+
+	requests := func() [][]llm.Message {
+		mu.Lock()
+		defer mu.Unlock()
+		return sent
+	}
+	return NewSubAgent[reviewRequest]("review", "Reviews code.", reviewer), c, requests
+}
+
+func TestSubAgentIsolation(t *testing.T) {
+	sub, _, requests := newReviewer(t, nil)
+
+	var wg sync.WaitGroup
+	results := make([]string, 4)
+	for i := range results {
+		i := i
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			results[i] = sub.Call(fmt.Sprintf(`{"code": "f%d", "unknown": 1}`, i))
+		}()
+	}
+	wg.Wait()
+
+	for i, result := range results {
+		if want := fmt.Sprintf(`{"result":{"verdict":"checked f%d"}}`, i); result != want {
+			t.Errorf("got %s, want %s", result, want)
+		}
+	}
+
+	// Every call sees only its own task, without unknown fields
+	sent := requests()
+	if len(sent) != len(results) {
+		t.Fatalf("got %d requests", len(sent))
+	}
+	for _, messages := range sent {
+		if len(messages) != 2 || strings.Contains(messages[1].Content, "unknown") {
+			t.Errorf("got messages %+v", messages)
+		}
+	}
+	if messages := sub.client.Messages(); len(messages) != 1 {
+		t.Errorf("messages of the client changed to %+v", messages)
+	}
+	if usage := sub.Usage(); usage.TotalTokens != 40 {
+		t.Errorf("got usage %+v", usage)
+	}
+
+	if result := sub.Call(`{"code": 1}`); result != `{"error":"Invalid arguments"}` {
+		t.Errorf("got %s", result)
+	}
+}
+
+func TestSubAgentUsage(t *testing.T) {
+	sub, c, _ := newReviewer(t, func(req *llm.Request) llm.Message {
+		if lastMessage(req).Role == "tool" {
+			return llm.Message{Role: "assistant", Content: "Reviewed"}
+		}
+		return toolCallMessage("call1", "review", `{"code": "f"}`)
+	})
+
+	var steps []Step
+	a := New[string](c, Config{
+		Goal:   "Review f",
+		Model:  "m",
+		Tools:  []llm.CallableFunction{sub},
+		OnStep: func(step Step) { steps = append(steps, step) },
+	})
+	answer, err := a.Run()
+	if err != nil || answer != "Reviewed" {
+		t.Fatalf("got %q, %v", answer, err)
+	}
+
+	// Two requests of the agent and one of the sub-agent
+	if usage := a.Usage(); usage.TotalTokens != 30 {
+		t.Errorf("got usage %+v", usage)
+	}
+	if len(steps) == 0 || steps[0].Tool != "review" || steps[0].Usage.TotalTokens != 10 {
+		t.Errorf("got steps %+v", steps)
+	}
+}
@@ -0,0 +1,226 @@
+package agent
+
+import (
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+
+	"github.com/xe0r/llm-stuff/llm"
+)
+
+// DefaultMaxHandoffs is used if TeamConfig.MaxHandoffs is not set.
+const DefaultMaxHandoffs = 5
+
+const transferToolName = "transfer_to_agent"
+
+var ErrTooManyHandoffs = errors.New("too many handoffs")
+
+type Member struct {
+	Name string
+	// What the member does, shown to the other members
+	Description string
+	// System prompt of the member
+	Instructions string
+	Tools        []llm.CallableFunction
+	// The default model of the client if empty
+	Model string
+}
+
+type Handoff struct {
+	From   string
+	To     string
+	Reason string
+}
+
+type TeamConfig struct {
+	Members []Member
+	// Member that answers first, the first one if empty. A supervisor that
+	// only routes the conversation is a member too.
+	Start string
+	// Handoffs in a single Run, DefaultMaxHandoffs if 0
+	MaxHandoffs int
+	// Limits of every turn of a member, llm.DefaultLimits if zero
+	Limits llm.Limits
+
+	// OnHandoff is called when a member transfers the conversation
+	OnHandoff func(Handoff)
+}
+
+// Team is a group of agents that hand off a conversation to each other. The
+// active member answers, and when another one is better suited, it transfers
+// the conversation with the transfer_to_agent tool. The next member
+// continues with the whole transcript, under its own system prompt and with
+// its own tools. The member that answered stays active for the next message.
+//
+// Team is not safe for concurrent use.
+type Team struct {
+	cfg     TeamConfig
+	members map[string]*teamMember
+	active  string
+
+	// Conversation without system prompts of the members
+	transcript []llm.Message
+	usage      llm.Usage
+	// Handoff requested in the current turn
+	pending *Handoff
+}
+
+type teamMember struct {
+	client *llm.ChatClient[string]
+	system string
+}
+
+type TransferRequest struct {
+	Agent  string `json:"agent" desc:"Name of the agent to transfer the conversation to."`
+	Reason string `json:"reason" desc:"Why the agent is better suited and what it should do."`
+}
+
+type TransferResponse struct {
+	Error string `json:"error,omitempty"`
+}
+
+func NewTeam(client *llm.Client, cfg TeamConfig) (*Team, error) {
+	if len(cfg.Members) == 0 {
+		return nil, fmt.Errorf("team has no members")
+	}
+	if cfg.Start == "" {
+		cfg.Start = cfg.Members[0].Name
+	}
+	if cfg.MaxHandoffs <= 0 {
+		cfg.MaxHandoffs = DefaultMaxHandoffs
+	}
+	if cfg.Limits == (llm.Limits{}) {
+		cfg.Limits = llm.DefaultLimits
+	}
+
+	t := &Team{
+		cfg:     cfg,
+		members: make(map[string]*teamMember),
+		active:  cfg.Start,
+	}
+
+	for _, m := range cfg.Members {
+		if m.Name == "" {
+			return nil, fmt.Errorf("team member has no name")
+		}
+		if _, ok := t.members[m.Name]; ok {
+			return nil, fmt.Errorf("duplicate team member %s", m.Name)
+		}
+
+		var funcs []llm.CallableFunction
+		for _, fn := range m.Tools {
+			if fn.GetName() == transferToolName {
+				return nil, fmt.Errorf("tool name %s is reserved", transferToolName)
+			}
+			funcs = append(funcs, &stepTool{CallableFunction: fn, onUsage: t.addUsage})
+		}
+		funcs = append(funcs, t.transferTool(m.Name))
+
+		member := &teamMember{
+			client: llm.NewChatClientWithClient[string](client, funcs),
+			system: t.systemPrompt(m),
+		}
+		if m.Model != "" {
+			member.client.SetModel(m.Model)
+		}
+		t.members[m.Name] = member
+	}
+
+	if _, ok := t.members[cfg.Start]; !ok {
+		return nil, fmt.Errorf("unknown team member %s", cfg.Start)
+	}
+	return t, nil
+}
+
+// Active returns the name of the member that has the conversation.
+func (t *Team) Active() string {
+	return t.active
+}
+
+// Messages returns the transcript without the system prompts.
+func (t *Team) Messages() []llm.Message {
+	return slices.Clone(t.transcript)
+}
+
+// Usage returns the usage of all members and their sub-agents.
+func (t *Team) Usage() llm.Usage {
+	return t.usage
+}
+
+// Run adds the user message to the conversation and returns the answer of
+// the member that ends up with it.
+func (t *Team) Run(message string) (string, error) {
+	t.transcript = append(t.transcript, llm.Message{
+		Role:    "user",
+		Content: message,
+	})
+
+	handoffs := 0
+	for {
+		member := t.members[t.active]
+		member.client.SetMessages(append([]llm.Message{{Role: "system", Content: member.system}}, t.transcript...))
+
+		t.pending = nil
+		answer, res, err := member.client.GetResponseWithResult(nil, llm.WithLimits(t.cfg.Limits), llm.WithStopTools(transferToolName))
+		t.usage.Add(&res.Usage)
+		t.transcript = member.client.Messages()[1:]
+		if err != nil {
+			return "", err
+		}
+		if res.StopReason != llm.StopReasonStopTool {
+			return answer, nil
+		}
+
+		// A rejected transfer returns the turn to the same member
+		handoffs++
+		if handoffs > t.cfg.MaxHandoffs {
+			return "", ErrTooManyHandoffs
+		}
+		if t.pending != nil {
+			t.active = t.pending.To
+			if t.cfg.OnHandoff != nil {
+				t.cfg.OnHandoff(*t.pending)
+			}
+		}
+	}
+}
+
+func (t *Team) addUsage(usage llm.Usage) {
+	t.usage.Add(&usage)
+}
+
+func (t *Team) systemPrompt(m Member) string {
+	var b strings.Builder
+	if m.Instructions != "" {
+		b.WriteString(m.Instructions)
+		b.WriteString("\n\n")
+	}
+
+	fmt.Fprintf(&b, "You are %s, one of a team of agents. When another agent is better suited for the conversation, transfer it with %s. Agents:\n", m.Name, transferToolName)
+	for _, other := range t.cfg.Members {
+		if other.Name != m.Name {
+			fmt.Fprintf(&b, "- %s: %s\n", other.Name, other.Description)
+		}
+	}
+	return b.String()
+}
+
+// transferTool returns the handoff tool of a member.
+func (t *Team) transferTool(from string) llm.CallableFunction {
+	return llm.NewCallableFunction(transferToolName, "Transfers the conversation to another agent of the team.", func(req *TransferRequest) *TransferResponse {
+		if req.Agent == from {
+			return &TransferResponse{Error: "the conversation is already yours"}
+		}
+		if _, ok := t.members[req.Agent]; !ok {
+			return &TransferResponse{Error: "unknown agent " + req.Agent}
+		}
+
+		t.pending = &Handoff{
+			From:   from,
+			To:     req.Agent,
+			Reason: req.Reason,
+		}
+		return &TransferResponse{}
+	})
+}
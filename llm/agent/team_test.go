@@ -0,0 +1,169 @@
+package agent
+
+import (
+	"errors"
+	"fmt"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/xe0r/llm-stuff/llm"
+)
+
+// memberName returns the name of the team member the request is sent for.
+func memberName(req *llm.Request) string {
+	name, _, _ := strings.Cut(strings.TrimPrefix(req.Messages[0].Content, "You are "), ",")
+	return name
+}
+
+func transfer(to string) llm.Message {
+	return toolCallMessage("call_"+to, transferToolName, fmt.Sprintf(`{"agent": %q, "reason": "Ask %s"}`, to, to))
+}
+
+var teamMembers = []Member{
+	{Name: "triage", Description: "Routes the conversation.", Model: "m"},
+	{Name: "billing", Description: "Answers billing questions.", Model: "m"},
+}
+
+func TestTeamHandoff(t *testing.T) {
+	var members []string
+	c := newStubClient(t, func(req *llm.Request) llm.Message {
+		name := memberName(req)
+		members = append(members, name)
+		if name == "billing" {
+			return llm.Message{Role: "assistant", Content: "Refunded"}
+		}
+		return transfer("billing")
+	})
+
+	var handoffs []Handoff
+	team, err := NewTeam(c, TeamConfig{
+		Members:   teamMembers,
+		OnHandoff: func(h Handoff) { handoffs = append(handoffs, h) },
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	answer, err := team.Run("Refund me")
+	if err != nil || answer != "Refunded" {
+		t.Fatalf("got %q, %v", answer, err)
+	}
+	if want := []Handoff{{From: "triage", To: "billing", Reason: "Ask billing"}}; !reflect.DeepEqual(handoffs, want) {
+		t.Errorf("got handoffs %+v", handoffs)
+	}
+	if team.Active() != "billing" || team.Usage().TotalTokens != 20 {
+		t.Errorf("active %s, usage %+v", team.Active(), team.Usage())
+	}
+
+	// The member that answered keeps the conversation, with the transcript
+	// of the others and without their system prompts
+	if _, err := team.Run("Thanks"); err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{"triage", "billing", "billing"}; !reflect.DeepEqual(members, want) {
+		t.Errorf("got members %v", members)
+	}
+	messages := team.Messages()
+	if len(messages) != 6 || messages[0].Content != "Refund me" || messages[2].Role != "tool" {
+		t.Errorf("got transcript %+v", messages)
+	}
+	for _, msg := range messages {
+		if msg.Role == "system" {
+			t.Errorf("system prompt %q in the transcript", msg.Content)
+		}
+	}
+}
+
+func TestTeamRejectedTransfer(t *testing.T) {
+	c := newStubClient(t, func(req *llm.Request) llm.Message {
+		if last := lastMessage(req); last.Role == "tool" {
+			return llm.Message{Role: "assistant", Content: "Answered by " + memberName(req) + ": " + last.Content}
+		}
+		return transfer("nobody")
+	})
+
+	handoffs := 0
+	team, err := NewTeam(c, TeamConfig{
+		Members:   teamMembers,
+		OnHandoff: func(Handoff) { handoffs++ },
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	answer, err := team.Run("Hi")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if answer != `Answered by triage: {"error":"unknown agent nobody"}` || team.Active() != "triage" || handoffs != 0 {
+		t.Errorf("got %q from %s after %d handoffs", answer, team.Active(), handoffs)
+	}
+}
+
+func TestTeamMaxHandoffs(t *testing.T) {
+	requests := 0
+	c := newStubClient(t, func(req *llm.Request) llm.Message {
+		requests++
+		if memberName(req) == "triage" {
+			return transfer("billing")
+		}
+		return transfer("triage")
+	})
+
+	team, err := NewTeam(c, TeamConfig{Members: teamMembers, MaxHandoffs: 3})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := team.Run("Hi"); !errors.Is(err, ErrTooManyHandoffs) {
+		t.Fatalf("got error %v", err)
+	}
+	if requests != 4 {
+		t.Errorf("got %d requests", requests)
+	}
+	if err := checkToolCallsAnswered(team.Messages()); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestTeamUsage(t *testing.T) {
+	sub, c, _ := newReviewer(t, func(req *llm.Request) llm.Message {
+		if lastMessage(req).Role == "tool" {
+			return llm.Message{Role: "assistant", Content: "Reviewed"}
+		}
+		return toolCallMessage("call1", "review", `{"code": "f"}`)
+	})
+
+	team, err := NewTeam(c, TeamConfig{Members: []Member{
+		{Name: "dev", Model: "m", Tools: []llm.CallableFunction{sub}},
+	}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := team.Run("Review f"); err != nil {
+		t.Fatal(err)
+	}
+	if usage := team.Usage(); usage.TotalTokens != 30 {
+		t.Errorf("got usage %+v", usage)
+	}
+}
+
+func TestNewTeamInvalid(t *testing.T) {
+	tools := []llm.CallableFunction{llm.NewCallableFunction(transferToolName, "", func(*TransferRequest) *TransferResponse { return nil })}
+	tests := []struct {
+		name string
+		cfg  TeamConfig
+	}{
+		{"no members", TeamConfig{}},
+		{"no name", TeamConfig{Members: []Member{{}}}},
+		{"duplicate", TeamConfig{Members: []Member{{Name: "a"}, {Name: "a"}}}},
+		{"reserved tool", TeamConfig{Members: []Member{{Name: "a", Tools: tools}}}},
+		{"unknown start", TeamConfig{Members: []Member{{Name: "a"}}, Start: "b"}},
+	}
+
+	for _, tt := range tests {
+		if _, err := NewTeam(llm.NewClient("token"), tt.cfg); err == nil {
+			t.Errorf("%s: team was created", tt.name)
+		}
+	}
+}
@@ -1,28 +1,54 @@
 package llm
 
+// Optional sampling parameters are pointers, so that intentional zero values
+// (e.g. temperature 0) are sent, and unset ones are omitted.
 type Request struct {
 	Messages          []Message      `json:"messages,omitempty"`
 	Prompt            string         `json:"prompt,omitempty"`
 	Model             string         `json:"model,omitempty"`
 	ResponseFormat    ResponseFormat `json:"response_format,omitempty"`
-	Stop              string         `json:"stop,omitempty"`
+	Stop              []string       `json:"stop,omitempty"`
 	Stream            bool           `json:"stream,omitempty"`
+	N                 int            `json:"n,omitempty"`
 	MaxTokens         int            `json:"max_tokens,omitempty"`
-	Temperature       float64        `json:"temperature,omitempty"`
-	TopP              float64        `json:"top_p,omitempty"`
-	TopK              int            `json:"top_k,omitempty"`
-	FrequencyPenalty  float64        `json:"frequency_penalty,omitempty"`
-	PresencePenalty   float64        `json:"presence_penalty,omitempty"`
-	RepetitionPenalty float64        `json:"repetition_penalty,omitempty"`
-	Seed              int            `json:"seed,omitempty"`
+	Temperature       *float64       `json:"temperature,omitempty"`
+	TopP              *float64       `json:"top_p,omitempty"`
+	TopK              *int           `json:"top_k,omitempty"`
+	FrequencyPenalty  *float64       `json:"frequency_penalty,omitempty"`
+	PresencePenalty   *float64       `json:"presence_penalty,omitempty"`
+	RepetitionPenalty *float64       `json:"repetition_penalty,omitempty"`
+	Seed              *int           `json:"seed,omitempty"`
 	Tools             []Tool         `json:"tools,omitempty"`
-	// String or ToolChoice
-	ToolChoice any                 `json:"tool_choice,omitempty"`
-	LogitBias  map[int]float64     `json:"logit_bias,omitempty"`
-	Transforms []string            `json:"transforms,omitempty"`
-	Models     []string            `json:"models,omitempty"`
-	Route      string              `json:"route,omitempty"`
-	Provider   ProviderPreferences `json:"provider,omitempty"`
+	// One of ToolChoiceAuto, ToolChoiceNone, ToolChoiceRequired or a ToolChoice
+	ToolChoice        any                 `json:"tool_choice,omitempty"`
+	ParallelToolCalls *bool               `json:"parallel_tool_calls,omitempty"`
+	LogitBias         map[int]float64     `json:"logit_bias,omitempty"`
+	Transforms        []string            `json:"transforms,omitempty"`
+	Models            []string            `json:"models,omitempty"`
+	Route             string              `json:"route,omitempty"`
+	Provider          ProviderPreferences `json:"provider,omitempty"`
+	Reasoning         *Reasoning          `json:"reasoning,omitempty"`
+	Logprobs          bool                `json:"logprobs,omitempty"`
+	TopLogprobs       int                 `json:"top_logprobs,omitempty"`
+	Usage             *UsageOptions       `json:"usage,omitempty"`
+
+	// Set by WithCacheBypass
+	cacheBypass bool
+	// Set by WithPromptCaching
+	promptCaching bool
+}
+
+// UsageOptions asks OpenRouter to include cost in Usage.
+type UsageOptions struct {
+	Include bool `json:"include"`
+}
+
+type Reasoning struct {
+	// "low", "medium" or "high"
+	Effort    string `json:"effort,omitempty"`
+	MaxTokens int    `json:"max_tokens,omitempty"`
+	Exclude   bool   `json:"exclude,omitempty"`
+	Enabled   bool   `json:"enabled,omitempty"`
 }
 
 type TextContent struct {
@@ -41,9 +67,10 @@ type ImageURL struct {
 }
 
 type ContentPart struct {
-	Type     string   `json:"type"`
-	Text     string   `json:"text,omitempty"`
-	ImageURL ImageURL `json:"image_url,omitempty"`
+	Type         string        `json:"type"`
+	Text         string        `json:"text,omitempty"`
+	ImageURL     *ImageURL     `json:"image_url,omitempty"`
+	CacheControl *CacheControl `json:"cache_control,omitempty"`
 }
 
 type Message struct {
@@ -55,6 +82,15 @@ type Message struct {
 	ToolCalls []ToolCall `json:"tool_calls,omitempty"`
 
 	ToolCallID string `json:"tool_call_id,omitempty"`
+
+	// Reasoning of the model, see ReasoningDetail
+	Reasoning        string            `json:"reasoning,omitempty"`
+	ReasoningDetails []ReasoningDetail `json:"reasoning_details,omitempty"`
+
+	// Sent instead of Content if set, e.g. for images
+	Parts []ContentPart `json:"-"`
+	// Cache breakpoint after the message, see WithPromptCaching
+	CacheControl *CacheControl `json:"-"`
 }
 
 type FunctionDescription struct {
@@ -64,13 +100,32 @@ type FunctionDescription struct {
 }
 
 type Tool struct {
-	Type     string              `json:"type"`
-	Function FunctionDescription `json:"function"`
+	Type         string              `json:"type"`
+	Function     FunctionDescription `json:"function"`
+	CacheControl *CacheControl       `json:"cache_control,omitempty"`
 }
 
+const (
+	ToolChoiceAuto     = "auto"
+	ToolChoiceNone     = "none"
+	ToolChoiceRequired = "required"
+)
+
+// ToolChoice forces the model to call a specific function.
 type ToolChoice struct {
-	Type     string              `json:"type"`
-	Function FunctionDescription `json:"function,omitempty"`
+	Type     string             `json:"type"`
+	Function ToolChoiceFunction `json:"function"`
+}
+
+type ToolChoiceFunction struct {
+	Name string `json:"name"`
+}
+
+func NewToolChoice(name string) ToolChoice {
+	return ToolChoice{
+		Type:     "function",
+		Function: ToolChoiceFunction{Name: name},
+	}
 }
 
 type ResponseFormat struct {
@@ -112,21 +167,56 @@ type ErrorDef struct {
 }
 
 type Choice struct {
-	Logprobs     interface{} `json:"logprobs"`
-	FinishReason string      `json:"finish_reason"`
-	Index        int         `json:"index"`
-	Delta        *Message    `json:"delta,omitempty"`
-	Message      *Message    `json:"message,omitempty"`
+	Logprobs           *Logprobs    `json:"logprobs,omitempty"`
+	FinishReason       FinishReason `json:"finish_reason"`
+	NativeFinishReason string       `json:"native_finish_reason,omitempty"`
+	// Matched stop sequence or token, reported by some providers
+	StopReason any      `json:"stop_reason,omitempty"`
+	Index      int      `json:"index"`
+	Delta      *Message `json:"delta,omitempty"`
+	Message    *Message `json:"message,omitempty"`
+}
+
+type Logprobs struct {
+	Content []TokenLogprob `json:"content"`
+	Refusal []TokenLogprob `json:"refusal,omitempty"`
+}
+
+type TokenLogprob struct {
+	Token       string       `json:"token"`
+	Logprob     float64      `json:"logprob"`
+	Bytes       []int        `json:"bytes,omitempty"`
+	TopLogprobs []TopLogprob `json:"top_logprobs,omitempty"`
+}
+
+type TopLogprob struct {
+	Token   string  `json:"token"`
+	Logprob float64 `json:"logprob"`
+	Bytes   []int   `json:"bytes,omitempty"`
 }
 
 type Usage struct {
 	PromptTokens     int `json:"prompt_tokens"`
 	CompletionTokens int `json:"completion_tokens"`
 	TotalTokens      int `json:"total_tokens"`
+	// Cost in credits, reported by OpenRouter if requested with UsageOptions
+	Cost float64 `json:"cost,omitempty"`
+
+	PromptTokensDetails     *PromptTokensDetails     `json:"prompt_tokens_details,omitempty"`
+	CompletionTokensDetails *CompletionTokensDetails `json:"completion_tokens_details,omitempty"`
+}
+
+type PromptTokensDetails struct {
+	// Prompt tokens read from the cache, see WithPromptCaching
+	CachedTokens int `json:"cached_tokens"`
+}
+
+type CompletionTokensDetails struct {
+	ReasoningTokens int `json:"reasoning_tokens"`
 }
 
 type JSONSchema struct {
 	Name   string `json:"name"`
 	Schema any    `json:"schema"`
 	Strict bool   `json:"strict"`
-}
\ No newline at end of file
+}
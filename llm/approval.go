@@ -0,0 +1,267 @@
+package llm
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"path"
+	"regexp"
+	"strings"
+)
+
+// ToolPolicy decides whether a tool call needs approval.
+type ToolPolicy string
+
+const (
+	// ToolPolicyAuto runs the tool without asking
+	ToolPolicyAuto ToolPolicy = "auto"
+	// ToolPolicyAsk asks the approver before running the tool
+	ToolPolicyAsk ToolPolicy = "ask"
+	// ToolPolicyDeny never runs the tool, the model gets an error instead
+	ToolPolicyDeny ToolPolicy = "deny"
+)
+
+type ApprovalRequest struct {
+	ToolCallID string
+	ToolName   string
+	// Arguments are pretty-printed if they're valid JSON
+	Arguments string
+}
+
+// ApprovalDecision is RejectCall if it's not set, so that a forgotten
+// decision doesn't run the tool.
+type ApprovalDecision int
+
+const (
+	// RejectCall doesn't run the tool and sends the reason to the model
+	RejectCall ApprovalDecision = iota
+	ApproveCall
+	// EditCall runs the tool with the arguments of the approval
+	EditCall
+)
+
+type Approval struct {
+	Decision ApprovalDecision
+	// Reason of the rejection
+	Reason string
+	// New arguments, JSON
+	Arguments string
+}
+
+// Approver approves tool calls with ToolPolicyAsk. An error stops
+// GetResponse, a nil approval rejects the call.
+type Approver interface {
+	Approve(req *ApprovalRequest) (*Approval, error)
+}
+
+type ApproverFunc func(req *ApprovalRequest) (*Approval, error)
+
+func (f ApproverFunc) Approve(req *ApprovalRequest) (*Approval, error) {
+	return f(req)
+}
+
+// SetToolPolicy sets the policy of a tool. Tools without policy use the
+// default one, see SetDefaultToolPolicy.
+func (c *ChatClient[T]) SetToolPolicy(name string, policy ToolPolicy) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	cfg := c.config()
+	if cfg.toolPolicies == nil {
+		cfg.toolPolicies = make(map[string]ToolPolicy)
+	}
+	cfg.toolPolicies[name] = policy
+}
+
+// SetDefaultToolPolicy sets the policy of tools without their own one,
+// ToolPolicyAuto by default.
+func (c *ChatClient[T]) SetDefaultToolPolicy(policy ToolPolicy) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.config().defaultToolPolicy = policy
+}
+
+// SetApprover sets the approver of tool calls with ToolPolicyAsk. Without an
+// approver such calls are rejected.
+func (c *ChatClient[T]) SetApprover(approver Approver) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.config().approver = approver
+}
+
+func (c *ChatClient[T]) toolPolicy(name string) ToolPolicy {
+	if policy, ok := c.cfg.toolPolicies[name]; ok {
+		return policy
+	}
+	if c.cfg.defaultToolPolicy != "" {
+		return c.cfg.defaultToolPolicy
+	}
+	return ToolPolicyAuto
+}
+
+// approveToolCall returns the arguments to call the tool with, or the
+// reason why it must not be called.
+func (c *ChatClient[T]) approveToolCall(toolCall ToolCall) (args string, rejected string, err error) {
+	args = toolCall.Function.Arguments
+
+	switch c.toolPolicy(toolCall.Function.Name) {
+	case ToolPolicyAuto:
+		return args, "", nil
+	case ToolPolicyDeny:
+		return "", "the tool is disabled", nil
+	}
+
+	if c.cfg.approver == nil {
+		return "", "the tool requires approval, but nobody can approve it", nil
+	}
+
+	approval, err := c.cfg.approver.Approve(&ApprovalRequest{
+		ToolCallID: toolCall.ID,
+		ToolName:   toolCall.Function.Name,
+		Arguments:  prettyJSON(args),
+	})
+	if err != nil {
+		return "", "", err
+	}
+	if approval == nil {
+		approval = &Approval{Decision: RejectCall}
+	}
+
+	switch approval.Decision {
+	case ApproveCall:
+		return args, "", nil
+	case EditCall:
+		if !json.Valid([]byte(approval.Arguments)) {
+			return "", "", fmt.Errorf("edited arguments of %s are not valid JSON", toolCall.Function.Name)
+		}
+		return approval.Arguments, "", nil
+	default:
+		reason := approval.Reason
+		if reason == "" {
+			reason = "the user rejected the call"
+		}
+		return "", reason, nil
+	}
+}
+
+func rejectedToolResult(name, reason string) string {
+	resp, _ := json.Marshal(ErrorResp{
+		Error: fmt.Sprintf("call of %s was not allowed: %s", name, reason),
+	})
+	return string(resp)
+}
+
+func prettyJSON(s string) string {
+	var buf bytes.Buffer
+	if err := json.Indent(&buf, []byte(s), "", "  "); err != nil {
+		return s
+	}
+	return buf.String()
+}
+
+// TerminalApprover asks the user in a terminal. It reads from a scanner so
+// it can share the input with the rest of the program.
+type TerminalApprover struct {
+	in  *bufio.Scanner
+	out io.Writer
+}
+
+func NewTerminalApprover(in *bufio.Scanner, out io.Writer) *TerminalApprover {
+	return &TerminalApprover{
+		in:  in,
+		out: out,
+	}
+}
+
+func (a *TerminalApprover) readLine(prompt string) (string, error) {
+	fmt.Fprint(a.out, prompt)
+	if !a.in.Scan() {
+		if err := a.in.Err(); err != nil {
+			return "", err
+		}
+		return "", io.EOF
+	}
+	return strings.TrimSpace(a.in.Text()), nil
+}
+
+func (a *TerminalApprover) Approve(req *ApprovalRequest) (*Approval, error) {
+	fmt.Fprintf(a.out, "\nThe model wants to call %s with arguments:\n%s\n", req.ToolName, req.Arguments)
+
+	for {
+		answer, err := a.readLine("Allow? [y]es, [n]o, [e]dit: ")
+		if err != nil {
+			return nil, err
+		}
+
+		switch strings.ToLower(answer) {
+		case "y", "yes":
+			return &Approval{Decision: ApproveCall}, nil
+		case "n", "no":
+			reason, err := a.readLine("Reason (optional): ")
+			if err != nil {
+				return nil, err
+			}
+			return &Approval{Decision: RejectCall, Reason: reason}, nil
+		case "e", "edit":
+			args, err := a.readLine("New arguments (JSON on one line): ")
+			if err != nil {
+				return nil, err
+			}
+			if !json.Valid([]byte(args)) {
+				fmt.Fprintln(a.out, "Invalid JSON")
+				continue
+			}
+			return &Approval{Decision: EditCall, Arguments: args}, nil
+		}
+	}
+}
+
+// ApprovalRule matches tool calls by tool name, a path.Match pattern, and
+// optionally by a regular expression matched against the compact JSON
+// arguments.
+type ApprovalRule struct {
+	Tool      string
+	Arguments *regexp.Regexp
+	Allow     bool
+	Reason    string
+}
+
+// RuleApprover decides by the first matching rule, calls without a matching
+// rule are rejected. It's meant for non-interactive runs, e.g. in CI.
+type RuleApprover struct {
+	rules []ApprovalRule
+}
+
+func NewRuleApprover(rules ...ApprovalRule) *RuleApprover {
+	return &RuleApprover{
+		rules: rules,
+	}
+}
+
+func (a *RuleApprover) Approve(req *ApprovalRequest) (*Approval, error) {
+	args := req.Arguments
+	var buf bytes.Buffer
+	if err := json.Compact(&buf, []byte(args)); err == nil {
+		args = buf.String()
+	}
+
+	for _, rule := range a.rules {
+		if ok, err := path.Match(rule.Tool, req.ToolName); err != nil || !ok {
+			continue
+		}
+		if rule.Arguments != nil && !rule.Arguments.MatchString(args) {
+			continue
+		}
+
+		if rule.Allow {
+			return &Approval{Decision: ApproveCall}, nil
+		}
+		return &Approval{Decision: RejectCall, Reason: rule.Reason}, nil
+	}
+
+	return &Approval{Decision: RejectCall, Reason: "no rule allows this call"}, nil
+}
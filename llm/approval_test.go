@@ -0,0 +1,60 @@
+package llm
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+	"testing"
+)
+
+func TestApprover(t *testing.T) {
+	c := newTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+		var req Request
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			t.Error(err)
+		}
+		if last := req.Messages[len(req.Messages)-1]; last.Role == "tool" {
+			writeMessage(w, Message{Role: "assistant", Content: last.Content}, FinishReasonStop)
+			return
+		}
+		writeMessage(w, toolCallMessage("call1", "ping"), FinishReasonToolCalls)
+	})
+
+	tests := []struct {
+		name     string
+		approval *Approval
+		wantRun  bool
+	}{
+		{"approved", &Approval{Decision: ApproveCall}, true},
+		{"rejected", &Approval{Decision: RejectCall, Reason: "no"}, false},
+		{"nil approval", nil, false},
+		{"decision not set", &Approval{}, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			called := false
+			ping := NewCallableFunction("ping", "Pings", func(*pingRequest) *pingResponse {
+				called = true
+				return &pingResponse{Pong: true}
+			})
+
+			chat := NewChatClientWithClient[string](c, []CallableFunction{ping})
+			chat.SetModel("m")
+			chat.SetToolPolicy("ping", ToolPolicyAsk)
+			chat.SetApprover(ApproverFunc(func(req *ApprovalRequest) (*Approval, error) {
+				return tt.approval, nil
+			}))
+			chat.AddMessage("user", "Ping")
+
+			// The model repeats the tool result
+			result, err := chat.GetResponse(nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if called != tt.wantRun || strings.Contains(result, "not allowed") == tt.wantRun {
+				t.Errorf("tool called %v, result %s", called, result)
+			}
+		})
+	}
+}
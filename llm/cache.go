@@ -0,0 +1,312 @@
+package llm
+
+import (
+	"container/list"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Cache stores responses by a key made from the request, see Client.SetCache.
+// Values are JSON encoded responses.
+type Cache interface {
+	Get(key string) ([]byte, bool)
+	Set(key string, value []byte)
+}
+
+type CacheStats struct {
+	Hits   int64
+	Misses int64
+}
+
+type cacheStats struct {
+	hits   atomic.Int64
+	misses atomic.Int64
+}
+
+// CacheKey returns the key of a request to the API at baseURL: a hash of
+// everything that affects the response, that is the provider, model,
+// messages, tools and sampling parameters. Streamed and non-streamed
+// requests have the same key.
+func CacheKey(baseURL string, req *Request) string {
+	normalized := *req
+	normalized.Stream = false
+
+	// Encoding of structs is stable and map keys are sorted
+	data, _ := json.Marshal(&normalized)
+	hash := sha256.New()
+	hash.Write([]byte(baseURL + "\n"))
+	hash.Write(data)
+	return hex.EncodeToString(hash.Sum(nil))
+}
+
+// SetCache sets the response cache, nil disables it. Responses with errors
+// are not cached.
+func (c *Client) SetCache(cache Cache) {
+	c.cache = cache
+}
+
+// CacheStats returns the number of cache hits and misses of the client.
+func (c *Client) CacheStats() CacheStats {
+	return CacheStats{
+		Hits:   c.cacheStats.hits.Load(),
+		Misses: c.cacheStats.misses.Load(),
+	}
+}
+
+// WithCacheBypass makes the call skip the cached responses. The new
+// response replaces the cached one.
+func WithCacheBypass() Option {
+	return func(c *callConfig) {
+		c.req.cacheBypass = true
+	}
+}
+
+// cachedResponse returns the cached response to the request, if any.
+func (c *Client) cachedResponse(req *Request) (string, *Response) {
+	if c.cache == nil {
+		return "", nil
+	}
+
+	key := CacheKey(c.baseURL, req)
+	if req.cacheBypass {
+		return key, nil
+	}
+
+	if data, ok := c.cache.Get(key); ok {
+		var resp Response
+		if err := json.Unmarshal(data, &resp); err == nil {
+			c.cacheStats.hits.Add(1)
+			if c.logger != nil {
+				c.logger.Log("Cached response: ", string(data))
+			}
+			return key, &resp
+		}
+	}
+
+	c.cacheStats.misses.Add(1)
+	return key, nil
+}
+
+func (c *Client) cacheResponse(key string, resp *Response) {
+	if c.cache == nil || resp == nil || resp.Code != 0 || resp.Error.Message != "" || len(resp.Choices) == 0 {
+		return
+	}
+
+	data, err := json.Marshal(resp)
+	if err != nil {
+		return
+	}
+	c.cache.Set(key, data)
+}
+
+// replayChunks splits a cached response into stream chunks: one per choice
+// with the whole message as delta, and one with usage.
+func replayChunks(resp *Response) []*Response {
+	var chunks []*Response
+	for _, choice := range resp.Choices {
+		chunk := *resp
+		chunk.Object += ".chunk"
+		chunk.Usage = nil
+
+		delta := Message{}
+		if choice.Message != nil {
+			delta = cloneMessage(*choice.Message)
+		}
+		for i := range delta.ToolCalls {
+			delta.ToolCalls[i].Index = i
+		}
+
+		choice.Message = nil
+		choice.Delta = &delta
+		chunk.Choices = []Choice{choice}
+		chunks = append(chunks, &chunk)
+	}
+
+	if resp.Usage != nil {
+		chunk := *resp
+		chunk.Object += ".chunk"
+		chunk.Choices = []Choice{}
+		chunks = append(chunks, &chunk)
+	}
+	return chunks
+}
+
+// MemoryCache is an in-memory LRU cache.
+type MemoryCache struct {
+	mu      sync.Mutex
+	size    int
+	ttl     time.Duration
+	entries map[string]*list.Element
+	order   *list.List
+}
+
+type memoryCacheEntry struct {
+	key     string
+	value   []byte
+	expires time.Time
+}
+
+// NewMemoryCache creates a cache of up to size responses. If ttl is 0,
+// responses don't expire.
+func NewMemoryCache(size int, ttl time.Duration) *MemoryCache {
+	return &MemoryCache{
+		size:    size,
+		ttl:     ttl,
+		entries: make(map[string]*list.Element),
+		order:   list.New(),
+	}
+}
+
+func (c *MemoryCache) Get(key string) ([]byte, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	elem, ok := c.entries[key]
+	if !ok {
+		return nil, false
+	}
+
+	entry := elem.Value.(*memoryCacheEntry)
+	if !entry.expires.IsZero() && time.Now().After(entry.expires) {
+		c.order.Remove(elem)
+		delete(c.entries, key)
+		return nil, false
+	}
+
+	c.order.MoveToFront(elem)
+	return entry.value, true
+}
+
+func (c *MemoryCache) Set(key string, value []byte) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	entry := &memoryCacheEntry{
+		key:   key,
+		value: value,
+	}
+	if c.ttl > 0 {
+		entry.expires = time.Now().Add(c.ttl)
+	}
+
+	if elem, ok := c.entries[key]; ok {
+		elem.Value = entry
+		c.order.MoveToFront(elem)
+		return
+	}
+
+	c.entries[key] = c.order.PushFront(entry)
+	for c.size > 0 && c.order.Len() > c.size {
+		oldest := c.order.Back()
+		c.order.Remove(oldest)
+		delete(c.entries, oldest.Value.(*memoryCacheEntry).key)
+	}
+}
+
+// DiskCache stores responses as files in a directory.
+type DiskCache struct {
+	dir string
+	ttl time.Duration
+}
+
+// DefaultCacheDir returns llm-stuff/responses in the user cache directory.
+func DefaultCacheDir() (string, error) {
+	dir, err := os.UserCacheDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, "llm-stuff", "responses"), nil
+}
+
+// NewDiskCache creates a cache in the directory. If ttl is 0, responses
+// don't expire.
+func NewDiskCache(dir string, ttl time.Duration) (*DiskCache, error) {
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return nil, err
+	}
+
+	return &DiskCache{
+		dir: dir,
+		ttl: ttl,
+	}, nil
+}
+
+func (c *DiskCache) path(key string) string {
+	return filepath.Join(c.dir, key[:2], key+".json")
+}
+
+func (c *DiskCache) Get(key string) ([]byte, bool) {
+	path := c.path(key)
+
+	info, err := os.Stat(path)
+	if err != nil {
+		return nil, false
+	}
+	if c.ttl > 0 && time.Since(info.ModTime()) > c.ttl {
+		_ = os.Remove(path)
+		return nil, false
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, false
+	}
+	return data, true
+}
+
+func (c *DiskCache) Set(key string, value []byte) {
+	path := c.path(key)
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return
+	}
+
+	// Concurrent readers must not see partially written files
+	file, err := os.CreateTemp(filepath.Dir(path), ".tmp-*")
+	if err != nil {
+		return
+	}
+
+	_, err = file.Write(value)
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Rename(file.Name(), path)
+	}
+	if err != nil {
+		_ = os.Remove(file.Name())
+	}
+}
+
+// Prune removes expired responses.
+func (c *DiskCache) Prune() error {
+	if c.ttl <= 0 {
+		return nil
+	}
+
+	return filepath.WalkDir(c.dir, func(path string, entry fs.DirEntry, err error) error {
+		if err != nil {
+			if errors.Is(err, fs.ErrNotExist) {
+				return nil
+			}
+			return err
+		}
+		if entry.IsDir() {
+			return nil
+		}
+
+		info, err := entry.Info()
+		if err == nil && time.Since(info.ModTime()) > c.ttl {
+			_ = os.Remove(path)
+		}
+		return nil
+	})
+}
@@ -0,0 +1,59 @@
+package llm
+
+import (
+	"encoding/json"
+	"net/http"
+	"testing"
+)
+
+func TestCacheKeyBaseURL(t *testing.T) {
+	cache := NewMemoryCache(10, 0)
+
+	newClient := func(answer string, requests *int) *Client {
+		c := newTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+			*requests++
+			w.Header().Set("Content-Type", "application/json")
+			json.NewEncoder(w).Encode(Response{Choices: []Choice{{
+				Message:      &Message{Role: "assistant", Content: answer},
+				FinishReason: FinishReasonStop,
+			}}})
+		})
+		c.SetCache(cache)
+		return c
+	}
+
+	var requestsA, requestsB int
+	a := newClient("a", &requestsA)
+	b := newClient("b", &requestsB)
+
+	ask := func(c *Client) string {
+		t.Helper()
+		chat := NewChatClientWithClient[string](c, nil)
+		chat.SetModel("m")
+		chat.AddMessage("user", "Hi")
+		answer, err := chat.GetResponse(nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return answer
+	}
+
+	if answer := ask(a); answer != "a" {
+		t.Errorf("got %q from a", answer)
+	}
+	// The same request to another provider is not answered from the cache
+	if answer := ask(b); answer != "b" {
+		t.Errorf("got %q from b", answer)
+	}
+	if answer := ask(a); answer != "a" || requestsA != 1 || requestsB != 1 {
+		t.Errorf("got %q, requests %d and %d", answer, requestsA, requestsB)
+	}
+	if stats := a.CacheStats(); stats.Hits != 1 || stats.Misses != 1 {
+		t.Errorf("got stats %+v", stats)
+	}
+
+	req := &Request{Model: "m"}
+	if CacheKey("https://a.example/v1/", req) == CacheKey("https://b.example/v1/", req) {
+		t.Error("keys of different base URLs are equal")
+	}
+}
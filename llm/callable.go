@@ -4,6 +4,7 @@ import (
 	"encoding/json"
 	"reflect"
 	"strings"
+	"sync"
 )
 
 type CallableFunctionImpl[Req any, Resp any] struct {
@@ -11,7 +12,8 @@ type CallableFunctionImpl[Req any, Resp any] struct {
 	Description string
 	Handler     func(*Req) *Resp
 
-	parameters  *ParamDef
+	parameters     *ParamDef
+	parametersOnce sync.Once
 }
 
 func NewCallableFunction[Req any, Resp any](name string, description string, handler func(*Req) *Resp) *CallableFunctionImpl[Req, Resp] {
@@ -52,9 +54,7 @@ func (c *CallableFunctionImpl[Req, Resp]) GetDescription() string {
 }
 
 func (c *CallableFunctionImpl[Req, Resp]) GetParameters() ParamDef {
-	if c.parameters == nil {
-		c.makeParameters()
-	}
+	c.parametersOnce.Do(c.makeParameters)
 	return *c.parameters
 }
 
@@ -81,6 +81,13 @@ func getParamDef(ty reflect.Type) *ParamDef {
 		}
 	}
 
+	if ty.Kind() == reflect.Map {
+		return &ParamDef{
+			Type:               "object",
+			AdditionProperties: true,
+		}
+	}
+
 	if ty.Kind() != reflect.Struct {
 		return &ParamDef{
 			Type: getTypeName(ty),
@@ -139,4 +146,4 @@ func getTypeName(ty reflect.Type) string {
 	default:
 		return "object"
 	}
-}
\ No newline at end of file
+}
@@ -1,17 +1,83 @@
 package llm
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
+	"io"
+	"maps"
 	"reflect"
+	"slices"
 	"strings"
+	"sync"
 )
 
+// ChatClient is a conversation with a model.
+//
+// Settings of the client (functions, options, moderator etc.) are shared with
+// its clones and copied on write, so a client can be set up once, e.g. with a
+// system prompt, and cloned for every conversation of a server. Clones can be
+// used concurrently. Methods of a single client are safe for concurrent use
+// too, but GetResponse holds the client for the whole call, so methods of
+// the same client must not be called from chunk consumers, tools or approvers.
 type ChatClient[T any] struct {
+	mu sync.Mutex
+
+	cfg *chatConfig
+	// cfg is shared with clones and has to be copied before changes
+	sharedCfg bool
+
+	messages []Message
+	// Number of messages already checked by the moderator
+	moderated int
+
+	// Session the conversation is recorded in and ID of its last message there
+	session *Session
+	head    int
+}
+
+// chatConfig holds settings of a chat client. It's not changed once shared.
+type chatConfig struct {
 	client   *Client
 	funcs    []CallableFunction
 	funcsMap map[string]CallableFunction
-	req      *Request
+	// Template of requests, without messages
+	req  *Request
+	opts []Option
+
+	moderator Moderator
+
+	responseMode ResponseMode
+
+	toolPolicies      map[string]ToolPolicy
+	defaultToolPolicy ToolPolicy
+	approver          Approver
+}
+
+func (c *chatConfig) clone() *chatConfig {
+	clone := *c
+
+	req := *c.req
+	req.Tools = slices.Clone(c.req.Tools)
+	req.Stop = slices.Clone(c.req.Stop)
+	if c.req.ResponseFormat.JSONSchema != nil {
+		schema := *c.req.ResponseFormat.JSONSchema
+		req.ResponseFormat.JSONSchema = &schema
+	}
+	clone.req = &req
+
+	clone.opts = slices.Clone(c.opts)
+	clone.toolPolicies = maps.Clone(c.toolPolicies)
+	return &clone
+}
+
+// config returns the settings for changing, c.mu must be held.
+func (c *ChatClient[T]) config() *chatConfig {
+	if c.sharedCfg {
+		c.cfg = c.cfg.clone()
+		c.sharedCfg = false
+	}
+	return c.cfg
 }
 
 func NewChatClient(token string, funcs []CallableFunction) *ChatClient[string] {
@@ -19,8 +85,13 @@ func NewChatClient(token string, funcs []CallableFunction) *ChatClient[string] {
 }
 
 func NewChatClientWithType[T any](token string, funcs []CallableFunction) *ChatClient[T] {
-	client := NewClient(token)
+	return NewChatClientWithClient[T](NewClient(token), funcs)
+}
 
+// NewChatClientWithClient creates a chat client on top of an existing client,
+// e.g. one made by NewClientFromConfig. Model, temperature and max tokens
+// set on the client are used as defaults.
+func NewChatClientWithClient[T any](client *Client, funcs []CallableFunction) *ChatClient[T] {
 	tools := make([]Tool, 0, len(funcs))
 	funcsMap := make(map[string]CallableFunction)
 
@@ -38,12 +109,18 @@ func NewChatClientWithType[T any](token string, funcs []CallableFunction) *ChatC
 
 	toolChoice := ""
 	if len(tools) > 0 {
-		toolChoice = "auto"
+		toolChoice = ToolChoiceAuto
 	}
 
 	req := &Request{
 		Tools:      tools,
 		ToolChoice: toolChoice,
+		Model:      client.model,
+		MaxTokens:  client.maxTokens,
+	}
+	if client.temperature != nil {
+		temperature := *client.temperature
+		req.Temperature = &temperature
 	}
 
 	ty := reflect.TypeOf((*T)(nil)).Elem()
@@ -62,107 +139,532 @@ func NewChatClientWithType[T any](token string, funcs []CallableFunction) *ChatC
 	}
 
 	return &ChatClient[T]{
-		client: client,
-
-		funcs:    funcs,
-		funcsMap: funcsMap,
-		req:      req,
+		cfg: &chatConfig{
+			client:   client,
+			funcs:    funcs,
+			funcsMap: funcsMap,
+			req:      req,
+		},
+
+		head: noNode,
 	}
 }
 
+// SetLogger sets the logger of the underlying Client. The Client is shared
+// with clones, forks and the client they're made from, so the logger changes
+// for all of them, and like other setters of Client, it must not be called
+// while any of them sends requests.
 func (c *ChatClient[T]) SetLogger(logger Logger) {
-	c.client.SetLogger(logger)
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.cfg.client.SetLogger(logger)
 }
 
 func (c *ChatClient[T]) SetModel(model string) {
-	c.req.Model = model
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.config().req.Model = model
 }
 
-// Some models don't support JSON Schema, or generate it incorrectly
+// SetOptions adds options that are applied to every request of the client.
+func (c *ChatClient[T]) SetOptions(opts ...Option) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	cfg := c.config()
+	cfg.opts = append(cfg.opts, opts...)
+}
+
+// SetModerator sets a moderator that checks new user messages before they're sent.
+func (c *ChatClient[T]) SetModerator(moderator Moderator) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.config().moderator = moderator
+	c.moderated = len(c.messages)
+}
+
+// Some models don't support JSON Schema, or generate it incorrectly.
+// See also SetResponseMode.
 func (c *ChatClient[T]) SetObjectResponse() {
-	c.req.ResponseFormat.Type = "json_object"
-	c.req.ResponseFormat.JSONSchema = nil
+	c.SetResponseMode(ResponseModeObject)
 }
 
 func (c *ChatClient[T]) AddMessage(role string, content string) {
-	c.req.Messages = append(c.req.Messages, Message{
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.appendMessages(Message{
 		Role:    role,
 		Content: content,
 	})
 }
 
-func (c *ChatClient[T]) GetResponse(chunkChan chan<- string) (T, error) {
+// newCallConfig makes the settings of a call, c.mu must be held.
+func (c *ChatClient[T]) newCallConfig(opts []Option) *callConfig {
+	req := *c.cfg.req
+	req.Messages = c.messages
+	cfg := &callConfig{
+		req:    &req,
+		limits: DefaultLimits,
+		result: &RunResult{},
+
+		responseMode: c.cfg.responseMode,
+	}
+
+	for _, opt := range c.cfg.opts {
+		opt(cfg)
+	}
+	for _, opt := range opts {
+		opt(cfg)
+	}
+
+	if cfg.limits.MaxCost > 0 {
+		cfg.req.Usage = &UsageOptions{Include: true}
+	}
+	if len(cfg.req.Tools) == 0 {
+		cfg.req.ToolChoice = nil
+	}
+	return cfg
+}
+
+func (c *ChatClient[T]) GetResponse(chunkChan chan<- string, opts ...Option) (T, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	result, _, err := c.getResponse(chunkChan, c.newCallConfig(opts))
+	return result, err
+}
+
+// GetResponseWithResult works like GetResponse, but also returns how the
+// call went: why the tool loop ended, number of rounds and total usage.
+func (c *ChatClient[T]) GetResponseWithResult(chunkChan chan<- string, opts ...Option) (T, *RunResult, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	cfg := c.newCallConfig(opts)
+	result, _, err := c.getResponse(chunkChan, cfg)
+	return result, cfg.result, err
+}
+
+// getResponse runs the conversation until the final response and returns it
+// along with the choice it was made from. c.mu must be held.
+func (c *ChatClient[T]) getResponse(chunkChan chan<- string, cfg *callConfig) (T, *Choice, error) {
 	if chunkChan != nil {
 		defer close(chunkChan)
 	}
 
+	req := cfg.req
+
 	result := *new(T)
-	if c.req.Model == "" {
-		return result, fmt.Errorf("model not set")
+	if req.Model == "" {
+		return result, nil, fmt.Errorf("model not set")
 	}
-	for {
-		var resp *Response
-		var err error
-		if chunkChan != nil {
-			subChunkChan := make(chan *Response)
-			doneChan := make(chan struct{})
-
-			go func() {
-				defer close(doneChan)
-				for chunk := range subChunkChan {
-					if len(chunk.Choices) > 0 {
-						chunkChan <- chunk.Choices[0].Delta.Content
+
+	if err := c.applyResponseMode(cfg); err != nil {
+		return result, nil, err
+	}
+
+	if err := checkToolChoice(req); err != nil {
+		return result, nil, err
+	}
+
+	if err := c.moderateInput(); err != nil {
+		return result, nil, err
+	}
+
+	// Log probabilities cover only the text generated in a single round
+	if cfg.confidence && cfg.respond {
+		return result, nil, fmt.Errorf("confidence is not supported in the tool response mode")
+	}
+	if cfg.confidence && cfg.maxContinuations > 0 {
+		return result, nil, fmt.Errorf("confidence is not supported with continuations")
+	}
+
+	// The prefill is a trailing assistant message, which the model continues
+	prefilling := cfg.prefill != "" || cfg.continueLast
+	prefill, err := c.startPrefill(cfg)
+	if err != nil {
+		return result, nil, err
+	}
+	// A failed request leaves the history as it was
+	dropPrefill := func() {
+		if prefilling {
+			c.dropPrefill(cfg)
+		}
+	}
+	output := func(content string) string {
+		return cfg.output(content, prefill)
+	}
+	if chunkChan != nil && prefill != "" && !cfg.stripPrefill {
+		chunkChan <- prefill
+	}
+
+	var stops *stopFilter
+	if len(req.Stop) > 0 && !cfg.respond {
+		stops = &stopFilter{stops: req.Stop}
+	}
+
+	// Continuations repeat the end of the truncated response, so they're
+	// streamed only after they're stitched
+	buffering := false
+
+	var onChunk func(*Response)
+	if chunkChan != nil || cfg.onReasoning != nil {
+		// In the tool response mode the response is streamed from arguments
+		// of the respond call, its name comes only in the first delta
+		respondIndex := -1
+
+		onChunk = func(chunk *Response) {
+			for _, choice := range chunk.Choices {
+				if choice.Index != 0 || choice.Delta == nil {
+					continue
+				}
+				if choice.Delta.Reasoning != "" && cfg.onReasoning != nil {
+					cfg.onReasoning(choice.Delta.Reasoning)
+				}
+				if chunkChan == nil || buffering {
+					continue
+				}
+				if stops != nil {
+					chunkChan <- stops.write(choice.Delta.Content)
+				} else if choice.Delta.Content != "" || !cfg.respond {
+					chunkChan <- choice.Delta.Content
+				}
+
+				for _, toolCall := range choice.Delta.ToolCalls {
+					if cfg.respond && toolCall.Function.Name == respondToolName {
+						respondIndex = toolCall.Index
+					}
+					if toolCall.Index == respondIndex && toolCall.Function.Arguments != "" {
+						chunkChan <- toolCall.Function.Arguments
 					}
 				}
-			}()
-			resp, err = c.client.SendStreamRequest(c.req, subChunkChan)
-			<-doneChan
-		} else {
-			resp, err = c.client.SendRequest(c.req)
+			}
 		}
-		if err != nil {
-			return result, err
+	}
+
+	isJSON := req.ResponseFormat.Type != "text"
+	run := newRun(cfg.limits, cfg.result)
+	run.stopTools = cfg.stopTools
+
+	// The loop may end before the calls of the last response are run, e.g.
+	// on a limit or a refusal, but every call has to be answered in the
+	// history for the next request
+	defer func() {
+		reason := "the response ended the tool loop"
+		if run.forced {
+			reason = "the limit of the tool loop was reached"
 		}
+		c.answerPendingCalls(reason)
+	}()
+
+	// State of continuation of a truncated response: text so far and index of
+	// the first message of it in the history
+	partial := ""
+	continuations := 0
+	continuationStart := -1
 
-		if resp.Code != 0 {
-			return result, fmt.Errorf("error code %d", resp.Code)
+	for {
+		req.Messages = c.messages
+
+		resp, err := c.sendRequest(req, onChunk)
+		if err != nil {
+			dropPrefill()
+			return result, nil, err
 		}
+		run.addUsage(resp.Usage)
 
-		if resp.Error.Message != "" {
-			return result, fmt.Errorf("error: %s", resp.Error.Message)
+		choice := &resp.Choices[0]
+
+		if choice.Message == nil {
+			dropPrefill()
+			return result, nil, fmt.Errorf("no message")
 		}
 
-		if len(resp.Choices) == 0 {
-			return result, fmt.Errorf("no choices")
+		if stops != nil {
+			if chunkChan != nil && !buffering {
+				chunkChan <- stops.flush()
+			}
+			stops = &stopFilter{stops: req.Stop}
+			cfg.result.StopSequence = applyStop(choice, req.Stop)
+		}
+		cfg.generated = choice.Message.Content
+
+		if prefilling {
+			// The response continues the prefill, so they're one message
+			prefilling = false
+			choice.Message.Content = prefill + choice.Message.Content
+			last := len(c.messages) - 1
+			c.setMessages(append(c.messages[:last:last], *choice.Message), last)
+		} else {
+			c.appendMessages(*choice.Message)
 		}
 
-		if len(resp.Choices) != 1 {
-			fmt.Printf("multiple choices: %#v\n", resp.Choices)
+		// Some providers report forced calls with finish reason stop
+		if call := cfg.respondCall(choice.Message); call != nil {
+			c.answerRespondCall(choice.Message.ToolCalls)
+			run.done()
+			result, err := c.convertResult(call.Function.Arguments)
+			return result, choice, err
 		}
 
-		choice := resp.Choices[0]
+		reason := choice.FinishReason.Normalize()
+		content := choice.Message.Content
+		if continuationStart >= 0 && reason != FinishReasonToolCalls {
+			content = stitchContinuation(partial, content, isJSON)
+			choice.Message.Content = content
+			c.collapseContinuation(continuationStart, content)
+			if chunkChan != nil && strings.HasPrefix(content, partial) {
+				chunkChan <- content[len(partial):]
+			}
+		}
 
-		if choice.Message == nil {
-			return result, fmt.Errorf("no message")
+		if refusal := choice.Message.Refusal; refusal != "" {
+			result, _ = c.convertResult(output(content))
+			return result, choice, &RefusalError{Refusal: refusal, Partial: output(content)}
 		}
 
-		c.req.Messages = append(c.req.Messages, *choice.Message)
+		switch reason {
+		case FinishReasonStop, "":
+			// It seems that some models don't send finish reason, at least in the stream mode
+			run.done()
+			result, err := c.convertResult(output(content))
+			return result, choice, err
+		case FinishReasonToolCalls:
+			if run.forced {
+				return result, choice, &LimitError{Reason: cfg.result.StopReason}
+			}
 
-		switch strings.ToLower(choice.FinishReason) {
-		case "stop", "":
-			// It seems that some models don't send finish reason, at least in the stream mode			
-			return c.convertResult(choice.Message.Content)
-		case "tool_calls":
-			err := c.handleToolCalls(choice.Message.ToolCalls)
+			err := c.handleToolCalls(choice.Message.ToolCalls, req.Tools, run)
 			if err != nil {
-				return result, err
+				return result, nil, err
+			}
+
+			if run.endRound() {
+				if cfg.result.StopReason == StopReasonStopTool {
+					return result, choice, nil
+				}
+
+				// Tool results are in the history, the model has to answer with them
+				run.forced = true
+				cfg.forceAnswer()
+			} else {
+				cfg.relaxToolChoice()
+			}
+		case FinishReasonLength:
+			// Truncated arguments of the respond call can't be continued
+			if continuations >= cfg.maxContinuations || cfg.respond {
+				result, _ = c.convertResult(output(content))
+				return result, choice, &IncompleteError{Reason: reason, Partial: output(content)}
 			}
+
+			if continuationStart < 0 {
+				continuationStart = len(c.messages) - 1
+			}
+			partial = content
+			continuations++
+			buffering = true
+
+			// Continuation is plain text that is appended to the truncated JSON,
+			// so the response format can't be enforced anymore
+			prompt := continuationPrompt
+			if isJSON {
+				prompt = jsonContinuationPrompt
+			}
+			req.ResponseFormat = ResponseFormat{Type: "text"}
+			if len(req.Tools) > 0 {
+				req.ToolChoice = ToolChoiceNone
+			}
+
+			c.appendMessages(Message{
+				Role:    "user",
+				Content: prompt,
+			})
+		case FinishReasonContentFilter:
+			result, _ = c.convertResult(output(content))
+			return result, choice, &ContentFilterError{Partial: output(content)}
 		default:
-			return result, fmt.Errorf("unknown finish reason %s", choice.FinishReason)
+			result, _ = c.convertResult(output(content))
+			return result, choice, &IncompleteError{Reason: reason, Partial: output(content)}
 		}
 	}
 }
 
+// checkToolChoice checks that a forced tool is available.
+func checkToolChoice(req *Request) error {
+	choice, ok := req.ToolChoice.(ToolChoice)
+	if !ok {
+		return nil
+	}
+
+	for _, tool := range req.Tools {
+		if tool.Function.Name == choice.Function.Name {
+			return nil
+		}
+	}
+	return fmt.Errorf("forced tool %s is not available", choice.Function.Name)
+}
+
+// answerRespondCall adds results of the calls that come with the respond
+// call, as every call has to be answered in the history.
+func (c *ChatClient[T]) answerRespondCall(toolCalls []ToolCall) {
+	for _, toolCall := range toolCalls {
+		content := "{}"
+		if toolCall.Function.Name != respondToolName {
+			content = rejectedToolResult(toolCall.Function.Name, "the response was already sent")
+		}
+
+		c.appendMessages(Message{
+			Role:       "tool",
+			Content:    content,
+			ToolCallID: toolCall.ID,
+		})
+	}
+}
+
+// answerPendingCalls adds results of the calls of the last assistant
+// message that are not answered yet.
+func (c *ChatClient[T]) answerPendingCalls(reason string) {
+	last := len(c.messages) - 1
+	for last >= 0 && c.messages[last].Role != "assistant" {
+		last--
+	}
+	if last < 0 {
+		return
+	}
+
+	answered := make(map[string]bool)
+	for _, msg := range c.messages[last+1:] {
+		if msg.Role == "tool" {
+			answered[msg.ToolCallID] = true
+		}
+	}
+
+	var results []Message
+	for _, toolCall := range c.messages[last].ToolCalls {
+		if !answered[toolCall.ID] {
+			results = append(results, Message{
+				Role:       "tool",
+				Content:    rejectedToolResult(toolCall.Function.Name, reason),
+				ToolCallID: toolCall.ID,
+			})
+		}
+	}
+	if len(results) > 0 {
+		c.appendMessages(results...)
+	}
+}
+
+// moderateInput runs the moderator over user messages added since the last check.
+func (c *ChatClient[T]) moderateInput() error {
+	if c.cfg.moderator == nil || c.moderated > len(c.messages) {
+		c.moderated = len(c.messages)
+	}
+	if c.cfg.moderator == nil {
+		return nil
+	}
+
+	checked := c.moderated
+	pending := c.messages[checked:]
+	messages := append([]Message(nil), c.messages[:c.moderated]...)
+
+	for i, msg := range pending {
+		messages = append(messages, msg)
+		if msg.Role != "user" {
+			continue
+		}
+
+		res, err := c.cfg.moderator.Moderate(msg.Content)
+		if err != nil {
+			return err
+		}
+
+		if res.Blocked {
+			// Blocked input must not get to the model with the next message either
+			messages = messages[:len(messages)-1]
+			c.moderated = len(messages)
+			c.setMessages(append(messages, pending[i+1:]...), checked)
+			return &ModerationError{Input: msg.Content, Result: res}
+		}
+
+		if res.Annotation != "" {
+			messages = append(messages, Message{
+				Role:    "system",
+				Content: res.Annotation,
+			})
+		}
+	}
+
+	c.setMessages(messages, checked)
+	c.moderated = len(messages)
+	return nil
+}
+
+// sendRequest sends the request and checks the response for errors. If onChunk
+// is set, the response is streamed and onChunk is called for every chunk.
+func (c *ChatClient[T]) sendRequest(req *Request, onChunk func(*Response)) (*Response, error) {
+	if req.promptCaching {
+		req = addCacheBreakpoints(req)
+	}
+
+	var resp *Response
+	var err error
+	if onChunk != nil {
+		resp, err = c.streamRequest(req, onChunk)
+	} else {
+		resp, err = c.cfg.client.SendRequest(req)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if resp.Code != 0 {
+		return nil, fmt.Errorf("error code %d", resp.Code)
+	}
+
+	if resp.Error.Message != "" {
+		return nil, fmt.Errorf("error: %s", resp.Error.Message)
+	}
+
+	if len(resp.Choices) == 0 {
+		return nil, fmt.Errorf("no choices")
+	}
+
+	return resp, nil
+}
+
+// streamRequest streams the response and calls onChunk for every chunk.
+func (c *ChatClient[T]) streamRequest(req *Request, onChunk func(*Response)) (*Response, error) {
+	stream, err := c.cfg.client.Stream(context.Background(), req)
+	if err != nil {
+		return nil, err
+	}
+	defer stream.Close()
+
+	for {
+		chunk, err := stream.Recv()
+		if err == io.EOF {
+			return stream.Response(), nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		onChunk(chunk)
+	}
+}
+
+// collapseContinuation replaces the truncated responses and continuation
+// prompts starting at index start with a single assistant message.
+func (c *ChatClient[T]) collapseContinuation(start int, content string) {
+	msg := c.messages[len(c.messages)-1]
+	msg.Content = content
+
+	c.setMessages(append(c.messages[:start], msg), start)
+}
+
 func (c *ChatClient[T]) convertResult(content string) (T, error) {
 	result := *new(T)
 	tv := reflect.ValueOf(&result).Elem()
@@ -177,14 +679,40 @@ func (c *ChatClient[T]) convertResult(content string) (T, error) {
 	return result, nil
 }
 
-func (c *ChatClient[T]) handleToolCalls(toolCalls []ToolCall) error {
+// handleToolCalls runs the calls and adds their results to the history.
+// Functions not among the tools of the request are not run.
+func (c *ChatClient[T]) handleToolCalls(toolCalls []ToolCall, tools []Tool, run *run) error {
 	for _, toolCall := range toolCalls {
-		fn, ok := c.funcsMap[toolCall.Function.Name]
+		fn, ok := c.cfg.funcsMap[toolCall.Function.Name]
 		if !ok {
 			return fmt.Errorf("unknown function %s", toolCall.Function.Name)
 		}
 
-		result := fn.Call(toolCall.Function.Arguments)
+		var rejected string
+		if !slices.ContainsFunc(tools, func(tool Tool) bool { return tool.Function.Name == toolCall.Function.Name }) {
+			rejected = "the tool is not available now"
+		} else {
+			rejected = run.allowCall(toolCall)
+		}
+
+		args := toolCall.Function.Arguments
+		if rejected == "" {
+			var err error
+			args, rejected, err = c.approveToolCall(toolCall)
+			if err != nil {
+				return err
+			}
+		}
+
+		var result string
+		if rejected != "" {
+			result = rejectedToolResult(toolCall.Function.Name, rejected)
+		} else {
+			result = fn.Call(args)
+			if slices.Contains(run.stopTools, toolCall.Function.Name) {
+				run.stop(StopReasonStopTool)
+			}
+		}
 
 		resultMessage := Message{
 			Role:       "tool",
@@ -192,7 +720,7 @@ func (c *ChatClient[T]) handleToolCalls(toolCalls []ToolCall) error {
 			ToolCallID: toolCall.ID,
 		}
 
-		c.req.Messages = append(c.req.Messages, resultMessage)
+		c.appendMessages(resultMessage)
 	}
 	return nil
 }
@@ -0,0 +1,176 @@
+package llm
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sync"
+	"testing"
+)
+
+// echoHandler answers every request with its model, temperature and last
+// message, so that tests can check which settings a request was sent with.
+func echoHandler(t *testing.T) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var req Request
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			t.Error(err)
+			return
+		}
+
+		temperature := "none"
+		if req.Temperature != nil {
+			temperature = fmt.Sprint(*req.Temperature)
+		}
+		last := req.Messages[len(req.Messages)-1]
+		content := fmt.Sprintf("%s %s %s", req.Model, temperature, last.Content)
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(Response{Choices: []Choice{{
+			Message:      &Message{Role: "assistant", Content: content},
+			FinishReason: FinishReasonStop,
+		}}})
+	}
+}
+
+func TestConcurrentClones(t *testing.T) {
+	checkGoroutines(t)
+	c := newTestClient(t, echoHandler(t))
+
+	session := NewSession()
+	base := NewChatClientWithClient[string](c, nil)
+	base.SetModel("base")
+	base.AddMessage("system", "Be brief.")
+	base.SetSession(session)
+
+	const n = 16
+	var wg sync.WaitGroup
+	for i := 0; i < n; i++ {
+		i := i
+		wg.Add(2)
+
+		go func() {
+			defer wg.Done()
+
+			var conv *ChatClient[string]
+			if i%2 == 0 {
+				conv = base.Fork()
+			} else {
+				conv = base.Clone()
+			}
+			conv.SetModel(fmt.Sprint("m", i))
+			conv.SetOptions(WithTemperature(float64(i)))
+			conv.AddMessage("user", fmt.Sprint("q", i))
+
+			answer, err := conv.GetResponse(nil)
+			if err != nil {
+				t.Error(err)
+				return
+			}
+			if want := fmt.Sprintf("m%d %d q%d", i, i, i); answer != want {
+				t.Errorf("got %q, want %q", answer, want)
+			}
+
+			messages := conv.Messages()
+			if last := messages[len(messages)-1]; last.Content != answer {
+				t.Errorf("last message is %+v", last)
+			}
+			if i%2 == 0 {
+				if path := session.Path(conv.SessionHead()); path[len(path)-1].Content != answer {
+					t.Errorf("fork %d is not recorded in the session", i)
+				}
+			} else if conv.SessionHead() != noNode {
+				t.Errorf("clone %d is attached to the session", i)
+			}
+		}()
+
+		// The base changes while it's cloned
+		go func() {
+			defer wg.Done()
+
+			base.SetOptions(WithSeed(i))
+			base.Clone().SetOptions(WithTemperature(-1))
+			base.Fork().SetModel("changed")
+			base.Messages()
+		}()
+	}
+	wg.Wait()
+
+	base.AddMessage("user", "q")
+	answer, err := base.GetResponse(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if answer != "base none q" {
+		t.Errorf("settings of the base changed: %q", answer)
+	}
+	if messages := base.Messages(); len(messages) != 3 {
+		t.Errorf("base has %d messages: %+v", len(messages), messages)
+	}
+}
+
+func TestConcurrentGetResponse(t *testing.T) {
+	checkGoroutines(t)
+	c := newTestClient(t, echoHandler(t))
+
+	chat := NewChatClientWithClient[string](c, nil)
+	chat.SetModel("m")
+
+	const n = 8
+	var wg sync.WaitGroup
+	for i := 0; i < n; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+
+			chat.AddMessage("user", "q")
+			if _, err := chat.GetResponse(nil); err != nil {
+				t.Error(err)
+			}
+			chat.SetOptions(WithTemperature(1))
+			chat.Clone()
+		}()
+	}
+	wg.Wait()
+
+	if messages := chat.Messages(); len(messages) != 2*n {
+		t.Errorf("got %d messages, want %d", len(messages), 2*n)
+	}
+}
+
+type logFunc func(args ...string)
+
+func (f logFunc) Log(args ...string) {
+	f(args...)
+}
+
+func TestSetLogger(t *testing.T) {
+	c := newTestClient(t, echoHandler(t))
+	base := NewChatClientWithClient[string](c, nil)
+	clone := base.Clone()
+
+	// Settings of the clone are copied while the logger is set
+	var wg sync.WaitGroup
+	wg.Add(2)
+	go func() {
+		defer wg.Done()
+		clone.SetModel("m")
+	}()
+	go func() {
+		defer wg.Done()
+		clone.SetLogger(logFunc(func(...string) {}))
+	}()
+	wg.Wait()
+
+	// The logger belongs to the shared Client
+	logged := 0
+	clone.SetLogger(logFunc(func(...string) { logged++ }))
+	base.SetModel("m")
+	base.AddMessage("user", "q")
+	if _, err := base.GetResponse(nil); err != nil {
+		t.Fatal(err)
+	}
+	if logged == 0 {
+		t.Error("logger of the clone was not used by the base")
+	}
+}
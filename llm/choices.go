@@ -0,0 +1,484 @@
+package llm
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+)
+
+// ChoiceChunk is a streamed piece of content of one of the candidate responses.
+type ChoiceChunk struct {
+	Index   int
+	Content string
+}
+
+// Candidate is one of the responses generated with WithN. Err is set if the
+// response couldn't be converted to T or was incomplete.
+type Candidate[T any] struct {
+	Index    int
+	Value    T
+	Content  string
+	Logprobs *Logprobs
+	Err      error
+}
+
+// GetCandidates works like GetResponse, but returns all choices generated by
+// the model. Set the number of choices with WithN. If the model calls tools,
+// the conversation follows the first choice that does so. Prefill, stop
+// sequences and continuations apply to every candidate.
+//
+// The candidates are not added to the conversation, use GetBestResponse or
+// add the chosen one with AddMessage to continue.
+func (c *ChatClient[T]) GetCandidates(chunkChan chan<- ChoiceChunk, opts ...Option) ([]Candidate[T], error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	candidates, _, err := c.getCandidates(chunkChan, opts, nil)
+	return candidates, err
+}
+
+// getCandidates generates the candidates. If the selector is set, the one it
+// picks is added to the conversation and its position is returned.
+func (c *ChatClient[T]) getCandidates(chunkChan chan<- ChoiceChunk, opts []Option, selector Selector[T]) ([]Candidate[T], int, error) {
+	if chunkChan != nil {
+		defer close(chunkChan)
+	}
+
+	cfg := c.newCallConfig(opts)
+	req := cfg.req
+
+	if req.Model == "" {
+		return nil, -1, fmt.Errorf("model not set")
+	}
+
+	if err := c.applyResponseMode(cfg); err != nil {
+		return nil, -1, err
+	}
+
+	if err := checkToolChoice(req); err != nil {
+		return nil, -1, err
+	}
+
+	if err := c.moderateInput(); err != nil {
+		return nil, -1, err
+	}
+
+	prefilling := cfg.prefill != "" || cfg.continueLast
+	prefill, err := c.startPrefill(cfg)
+	if err != nil {
+		return nil, -1, err
+	}
+	// Candidates that are not picked leave the history as it was
+	dropPrefill := func() {
+		if prefilling {
+			c.dropPrefill(cfg)
+		}
+	}
+
+	var stops []string
+	if !cfg.respond {
+		stops = req.Stop
+	}
+
+	// Stop filters of the streamed choices, by index
+	var filters map[int]*stopFilter
+	var onChunk func(*Response)
+	if chunkChan != nil {
+		onChunk = func(chunk *Response) {
+			for _, choice := range chunk.Choices {
+				if choice.Delta == nil {
+					continue
+				}
+				filter, ok := filters[choice.Index]
+				if !ok {
+					filter = &stopFilter{stops: stops}
+					filters[choice.Index] = filter
+					if prefill != "" && !cfg.stripPrefill {
+						chunkChan <- ChoiceChunk{Index: choice.Index, Content: prefill}
+					}
+				}
+				if content := filter.write(choice.Delta.Content); content != "" {
+					chunkChan <- ChoiceChunk{Index: choice.Index, Content: content}
+				}
+			}
+		}
+	}
+
+	run := newRun(cfg.limits, cfg.result)
+
+	for {
+		req.Messages = c.messages
+		filters = make(map[int]*stopFilter)
+
+		resp, err := c.sendRequest(req, onChunk)
+		if err != nil {
+			dropPrefill()
+			return nil, -1, err
+		}
+		run.addUsage(resp.Usage)
+
+		for index, filter := range filters {
+			if content := filter.flush(); content != "" {
+				chunkChan <- ChoiceChunk{Index: index, Content: content}
+			}
+		}
+
+		var toolCallChoice *Choice
+		for i := range resp.Choices {
+			choice := &resp.Choices[i]
+			if choice.Message == nil {
+				continue
+			}
+			applyStop(choice, stops)
+			if prefilling {
+				// The response continues the prefill, so they're one message
+				choice.Message.Content = prefill + choice.Message.Content
+			}
+			if toolCallChoice == nil && choice.FinishReason.Normalize() == FinishReasonToolCalls && cfg.respondCall(choice.Message) == nil {
+				toolCallChoice = choice
+			}
+		}
+
+		if toolCallChoice != nil {
+			if run.forced {
+				dropPrefill()
+				return nil, -1, &LimitError{Reason: cfg.result.StopReason}
+			}
+
+			c.addCandidate(*toolCallChoice.Message, prefilling)
+			prefilling = false
+			if err := c.handleToolCalls(toolCallChoice.Message.ToolCalls, req.Tools, run); err != nil {
+				return nil, -1, err
+			}
+
+			if run.endRound() {
+				run.forced = true
+				cfg.forceAnswer()
+			} else {
+				cfg.relaxToolChoice()
+			}
+			continue
+		}
+
+		run.done()
+
+		candidates := make([]Candidate[T], 0, len(resp.Choices))
+		for i := range resp.Choices {
+			choice := &resp.Choices[i]
+			if err := c.continueCandidate(choice, cfg, run, prefilling, chunkChan); err != nil {
+				dropPrefill()
+				return nil, -1, err
+			}
+			candidates = append(candidates, c.newCandidate(choice, cfg, prefill))
+		}
+
+		if selector == nil {
+			dropPrefill()
+			return candidates, -1, nil
+		}
+
+		i, err := selector.Select(candidates)
+		if err == nil && (i < 0 || i >= len(candidates) || resp.Choices[i].Message == nil) {
+			err = fmt.Errorf("selected candidate %d is not valid", i)
+		}
+		if err != nil {
+			dropPrefill()
+			return candidates, -1, err
+		}
+
+		// The history has the same shape as after GetResponse
+		msg := *resp.Choices[i].Message
+		c.addCandidate(msg, prefilling)
+		c.answerRespondCall(msg.ToolCalls)
+		return candidates, i, nil
+	}
+}
+
+// addCandidate adds the message to the conversation, in place of the
+// prefill if it continues one.
+func (c *ChatClient[T]) addCandidate(msg Message, prefilling bool) {
+	if prefilling {
+		last := len(c.messages) - 1
+		c.setMessages(append(c.messages[:last:last], msg), last)
+		return
+	}
+	c.appendMessages(msg)
+}
+
+// continueCandidate asks the model to continue a candidate truncated by the
+// token limit, see WithContinuation. The continuations are stitched into the
+// message of the choice, the conversation is not changed.
+func (c *ChatClient[T]) continueCandidate(choice *Choice, cfg *callConfig, run *run, prefilling bool, chunkChan chan<- ChoiceChunk) error {
+	// Truncated arguments of the respond call can't be continued
+	if choice.Message == nil || cfg.respond {
+		return nil
+	}
+
+	isJSON := cfg.req.ResponseFormat.Type != "text"
+	prompt := continuationPrompt
+	if isJSON {
+		prompt = jsonContinuationPrompt
+	}
+
+	req := *cfg.req
+	req.N = 0
+	req.ResponseFormat = ResponseFormat{Type: "text"}
+	if len(req.Tools) > 0 {
+		req.ToolChoice = ToolChoiceNone
+	}
+	history := c.messages
+	if prefilling {
+		history = history[:len(history)-1]
+	}
+
+	for i := 0; i < cfg.maxContinuations && choice.FinishReason.Normalize() == FinishReasonLength; i++ {
+		partial := choice.Message.Content
+		req.Messages = append(history[:len(history):len(history)],
+			Message{Role: "assistant", Content: partial},
+			Message{Role: "user", Content: prompt},
+		)
+
+		resp, err := c.sendRequest(&req, nil)
+		if err != nil {
+			return err
+		}
+		run.addUsage(resp.Usage)
+
+		next := &resp.Choices[0]
+		if next.Message == nil {
+			return fmt.Errorf("no message")
+		}
+		applyStop(next, req.Stop)
+
+		choice.Message.Content = stitchContinuation(partial, next.Message.Content, isJSON)
+		choice.FinishReason = next.FinishReason
+		if chunkChan != nil && strings.HasPrefix(choice.Message.Content, partial) {
+			chunkChan <- ChoiceChunk{Index: choice.Index, Content: choice.Message.Content[len(partial):]}
+		}
+	}
+	return nil
+}
+
+func (c *ChatClient[T]) newCandidate(choice *Choice, cfg *callConfig, prefill string) Candidate[T] {
+	cand := Candidate[T]{
+		Index: choice.Index,
+	}
+
+	if choice.Message == nil {
+		cand.Err = fmt.Errorf("no message")
+		return cand
+	}
+
+	cand.Content = cfg.output(choice.Message.Content, prefill)
+	cand.Logprobs = choice.Logprobs
+
+	if call := cfg.respondCall(choice.Message); call != nil {
+		cand.Content = call.Function.Arguments
+		cand.Value, cand.Err = c.convertResult(cand.Content)
+		return cand
+	}
+
+	cand.Value, cand.Err = c.convertResult(cand.Content)
+
+	if refusal := choice.Message.Refusal; refusal != "" {
+		cand.Err = &RefusalError{Refusal: refusal, Partial: cand.Content}
+		return cand
+	}
+
+	switch reason := choice.FinishReason.Normalize(); reason {
+	case FinishReasonStop, "":
+	case FinishReasonContentFilter:
+		cand.Err = &ContentFilterError{Partial: cand.Content}
+	default:
+		cand.Err = &IncompleteError{Reason: reason, Partial: cand.Content}
+	}
+	return cand
+}
+
+// GetResponses returns values of all valid candidates, see GetCandidates.
+func (c *ChatClient[T]) GetResponses(chunkChan chan<- ChoiceChunk, opts ...Option) ([]T, error) {
+	candidates, err := c.GetCandidates(chunkChan, opts...)
+	if err != nil {
+		return nil, err
+	}
+
+	results := make([]T, 0, len(candidates))
+	for _, cand := range candidates {
+		if cand.Err == nil {
+			results = append(results, cand.Value)
+		}
+	}
+
+	if len(results) == 0 {
+		return nil, candidates[0].Err
+	}
+	return results, nil
+}
+
+// GetBestResponse generates candidates, picks one with the selector and adds
+// it to the conversation.
+func (c *ChatClient[T]) GetBestResponse(chunkChan chan<- ChoiceChunk, selector Selector[T], opts ...Option) (T, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	candidates, i, err := c.getCandidates(chunkChan, opts, selector)
+	if err != nil {
+		return *new(T), err
+	}
+	return candidates[i].Value, nil
+}
+
+// Selector picks the best of the candidates and returns its position in the slice.
+type Selector[T any] interface {
+	Select(candidates []Candidate[T]) (int, error)
+}
+
+type SelectorFunc[T any] func(candidates []Candidate[T]) (int, error)
+
+func (f SelectorFunc[T]) Select(candidates []Candidate[T]) (int, error) {
+	return f(candidates)
+}
+
+type noValidCandidatesError struct {
+	err error
+}
+
+func (e *noValidCandidatesError) Error() string {
+	return fmt.Sprintf("no valid candidates: %v", e.err)
+}
+
+func (e *noValidCandidatesError) Unwrap() error {
+	return e.err
+}
+
+func checkCandidates[T any](candidates []Candidate[T]) error {
+	if len(candidates) == 0 {
+		return fmt.Errorf("no candidates")
+	}
+
+	for _, cand := range candidates {
+		if cand.Err == nil {
+			return nil
+		}
+	}
+	return &noValidCandidatesError{err: candidates[0].Err}
+}
+
+// FirstValid selects the first candidate without error.
+func FirstValid[T any]() Selector[T] {
+	return SelectorFunc[T](func(candidates []Candidate[T]) (int, error) {
+		for i, cand := range candidates {
+			if cand.Err == nil {
+				return i, nil
+			}
+		}
+		return 0, checkCandidates(candidates)
+	})
+}
+
+// MajorityVote selects the most common value among valid candidates
+// (self-consistency). Values are compared by their JSON representation,
+// ties are resolved in favor of the earlier candidate.
+func MajorityVote[T any]() Selector[T] {
+	return SelectorFunc[T](func(candidates []Candidate[T]) (int, error) {
+		if err := checkCandidates(candidates); err != nil {
+			return 0, err
+		}
+
+		counts := make(map[string]int)
+		first := make(map[string]int)
+		best := -1
+		bestKey := ""
+
+		for i, cand := range candidates {
+			if cand.Err != nil {
+				continue
+			}
+
+			keyJSON, err := json.Marshal(cand.Value)
+			if err != nil {
+				return 0, err
+			}
+			key := strings.TrimSpace(string(keyJSON))
+
+			if _, ok := first[key]; !ok {
+				first[key] = i
+			}
+			counts[key]++
+
+			if best < 0 || counts[key] > counts[bestKey] {
+				best = first[key]
+				bestKey = key
+			}
+		}
+
+		return best, nil
+	})
+}
+
+type judgeVerdict struct {
+	Best   int    `json:"best" desc:"Number of the best candidate."`
+	Reason string `json:"reason" desc:"Short explanation of the choice."`
+}
+
+const judgePrompt = `You are a judge. You receive several numbered candidate responses to the same request.
+You pick the best candidate. You respond with JSON without any extra text.
+`
+
+type judgeSelector[T any] struct {
+	client   *Client
+	model    string
+	criteria string
+}
+
+// NewJudgeSelector returns a selector that asks a model to rank the valid
+// candidates. Criteria, if set, are added to the judge's instructions.
+func NewJudgeSelector[T any](client *Client, model string, criteria string) Selector[T] {
+	return &judgeSelector[T]{
+		client:   client,
+		model:    model,
+		criteria: criteria,
+	}
+}
+
+func (j *judgeSelector[T]) Select(candidates []Candidate[T]) (int, error) {
+	if err := checkCandidates(candidates); err != nil {
+		return 0, err
+	}
+
+	valid := make([]int, 0, len(candidates))
+	var sb strings.Builder
+	for i, cand := range candidates {
+		if cand.Err != nil {
+			continue
+		}
+		valid = append(valid, i)
+		fmt.Fprintf(&sb, "Candidate %d:\n%s\n\n", len(valid), cand.Content)
+	}
+
+	if len(valid) == 1 {
+		return valid[0], nil
+	}
+
+	chat := NewChatClientWithClient[judgeVerdict](j.client, nil)
+	if j.model != "" {
+		chat.SetModel(j.model)
+	}
+
+	prompt := judgePrompt
+	if j.criteria != "" {
+		prompt += "Criteria: " + j.criteria
+	}
+	chat.AddMessage("system", prompt)
+	chat.AddMessage("user", sb.String())
+
+	verdict, err := chat.GetResponse(nil, WithTemperature(0))
+	if err != nil {
+		return 0, fmt.Errorf("judge: %w", err)
+	}
+
+	if verdict.Best < 1 || verdict.Best > len(valid) {
+		return 0, fmt.Errorf("judge picked invalid candidate %d", verdict.Best)
+	}
+	return valid[verdict.Best-1], nil
+}
@@ -0,0 +1,150 @@
+package llm
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"reflect"
+	"testing"
+)
+
+// writeChoices answers with a choice for every message, the finish reason is
+// length for messages ending with "...".
+func writeChoices(w http.ResponseWriter, messages ...Message) {
+	resp := Response{}
+	for i, msg := range messages {
+		msg := msg
+		finish := FinishReasonStop
+		if len(msg.ToolCalls) > 0 {
+			finish = FinishReasonToolCalls
+		}
+		if n := len(msg.Content); n >= 3 && msg.Content[n-3:] == "..." {
+			msg.Content = msg.Content[:n-3]
+			finish = FinishReasonLength
+		}
+		resp.Choices = append(resp.Choices, Choice{Index: i, Message: &msg, FinishReason: finish})
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(resp)
+}
+
+func candidateContents[T any](candidates []Candidate[T]) []string {
+	var contents []string
+	for _, cand := range candidates {
+		contents = append(contents, cand.Content)
+	}
+	return contents
+}
+
+func TestGetCandidatesPrefillAndStop(t *testing.T) {
+	c := newTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+		var req Request
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			t.Error(err)
+		}
+		if last := req.Messages[len(req.Messages)-1]; last.Role != "assistant" || last.Content != "The answer is" {
+			t.Errorf("got last message %+v", last)
+		}
+		// The provider ignores the stop sequence
+		writeChoices(w, Message{Role: "assistant", Content: " yesEND more"}, Message{Role: "assistant", Content: " no"})
+	})
+
+	chat := NewChatClientWithClient[string](c, nil)
+	chat.SetModel("m")
+	chat.AddMessage("user", "Is it?")
+
+	candidates, err := chat.GetCandidates(nil, WithN(2), WithPrefill("The answer is"), WithStop([]string{"END"}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := candidateContents(candidates); !reflect.DeepEqual(got, []string{"The answer is yes", "The answer is no"}) {
+		t.Errorf("got %q", got)
+	}
+	if messages := chat.Messages(); len(messages) != 1 {
+		t.Errorf("history changed to %+v", messages)
+	}
+
+	result, err := chat.GetBestResponse(nil, FirstValid[string](), WithN(2), WithPrefill("The answer is"), WithStop([]string{"END"}), WithStripPrefill())
+	if err != nil || result != " yes" {
+		t.Fatalf("got %q, %v", result, err)
+	}
+	want := []Message{{Role: "user", Content: "Is it?"}, {Role: "assistant", Content: "The answer is yes"}}
+	if messages := chat.Messages(); !reflect.DeepEqual(messages, want) {
+		t.Errorf("got history %+v", messages)
+	}
+}
+
+func TestGetCandidatesContinuation(t *testing.T) {
+	requests := 0
+	c := newTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		var req Request
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			t.Error(err)
+		}
+		if last := req.Messages[len(req.Messages)-1]; last.Content == continuationPrompt {
+			if partial := req.Messages[len(req.Messages)-2]; partial.Content != "Hello wo" || req.N != 0 {
+				t.Errorf("continued %+v with n %d", partial, req.N)
+			}
+			writeChoices(w, Message{Role: "assistant", Content: "rld"})
+			return
+		}
+		writeChoices(w, Message{Role: "assistant", Content: "Hello wo..."}, Message{Role: "assistant", Content: "Hi"})
+	})
+
+	chat := NewChatClientWithClient[string](c, nil)
+	chat.SetModel("m")
+	chat.AddMessage("user", "Greet")
+
+	candidates, err := chat.GetCandidates(nil, WithN(2))
+	var incomplete *IncompleteError
+	if err != nil || !errors.As(candidates[0].Err, &incomplete) || candidates[1].Err != nil {
+		t.Fatalf("got %+v, %v", candidates, err)
+	}
+
+	requests = 0
+	candidates, err = chat.GetCandidates(nil, WithN(2), WithContinuation(1))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := candidateContents(candidates); !reflect.DeepEqual(got, []string{"Hello world", "Hi"}) || candidates[0].Err != nil {
+		t.Errorf("got %+v", candidates)
+	}
+	if requests != 2 {
+		t.Errorf("got %d requests", requests)
+	}
+	if messages := chat.Messages(); len(messages) != 1 {
+		t.Errorf("history changed to %+v", messages)
+	}
+}
+
+func TestGetBestResponseToolMode(t *testing.T) {
+	c := newTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+		var choices []Message
+		for _, answer := range []string{"yes", "no"} {
+			msg := toolCallMessage("call_"+answer, respondToolName)
+			msg.ToolCalls[0].Function.Arguments = `{"answer": "` + answer + `"}`
+			choices = append(choices, msg)
+		}
+		writeChoices(w, choices...)
+	})
+
+	chat := NewChatClientWithClient[confidenceResult](c, nil)
+	chat.SetModel("m")
+	chat.SetResponseMode(ResponseModeTool)
+	chat.AddMessage("user", "Is it?")
+
+	result, err := chat.GetBestResponse(nil, FirstValid[confidenceResult](), WithN(2))
+	if err != nil || result.Answer != "yes" {
+		t.Fatalf("got %+v, %v", result, err)
+	}
+
+	// The respond call and its result, like after GetResponse
+	messages := chat.Messages()
+	if len(messages) != 3 || messages[1].ToolCalls[0].ID != "call_yes" || messages[2].Role != "tool" {
+		t.Errorf("got history %+v", messages)
+	}
+	if err := checkToolCallsAnswered(messages); err != nil {
+		t.Error(err)
+	}
+}
@@ -2,18 +2,37 @@ package llm
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
 	"fmt"
 	"io"
+	"mime"
 	"net/http"
 	"strings"
+	"sync"
+	"time"
 )
 
+// Client sends requests to an OpenAI-compatible API. It's safe for concurrent
+// use once it's set up, the setters must not be called while requests are sent.
 type Client struct {
 	client  *http.Client
 	token   string
 	baseURL string
+	headers map[string]string
+	retry   RetryPolicy
 	logger  Logger
+
+	// Defaults for chat clients created on top of this client
+	model       string
+	temperature *float64
+	maxTokens   int
+
+	modelsMu sync.Mutex
+	models   []ModelInfo
+
+	cache      Cache
+	cacheStats cacheStats
 }
 
 func NewClient(token string) *Client {
@@ -24,31 +43,20 @@ func NewClient(token string) *Client {
 	}
 }
 
+// mergeResponse merges a stream chunk into the accumulated response. Deltas
+// are matched to choices by Choice.Index and to tool calls by ToolCall.Index.
 func mergeResponse(base, update *Response) *Response {
 	if base == nil {
 		base = &Response{}
 		*base = *update
 
 		base.Object, _ = strings.CutSuffix(base.Object, ".chunk")
-
-		base.Choices = make([]Choice, len(update.Choices))
-
-		for i := range base.Choices {
-			base.Choices[i] = update.Choices[i]
-			base.Choices[i].Message = &Message{}
-			*base.Choices[i].Message = *base.Choices[i].Delta
-			base.Choices[i].Delta = nil
-		}
-		return base
+		base.Choices = nil
+		base.Usage = nil
 	}
 
-	// fmt.Println(base.Choices, update.Choices)
-
-	for i, choice := range update.Choices {
-		base.Choices[i].Message.Content += choice.Delta.Content
-		if base.Choices[i].FinishReason == "" {
-			base.Choices[i].FinishReason = choice.FinishReason
-		}
+	for i := range update.Choices {
+		mergeChoice(base.getChoice(update.Choices[i].Index), &update.Choices[i])
 	}
 
 	if base.Usage == nil && update.Usage != nil {
@@ -56,106 +64,160 @@ func mergeResponse(base, update *Response) *Response {
 		*base.Usage = *update.Usage
 	}
 
-	return base
-}
+	if base.Error.Message == "" {
+		base.Error = update.Error
+	}
 
-func (c *Client) SetLogger(logger Logger) {
-	c.logger = logger
+	return base
 }
 
-func (c *Client) SendStreamRequest(req *Request, chunkChan chan<- *Response) (*Response, error) {
-	req.Stream = true
-	reqURL := c.baseURL + "chat/completions"
-
-	httpResp, err := c.sendRequest(req, reqURL)
-	if err != nil {
-		return nil, err
+// getChoice returns the choice with the given index, adding it if needed
+// and keeping the choices ordered by index.
+func (r *Response) getChoice(index int) *Choice {
+	pos := len(r.Choices)
+	for i := range r.Choices {
+		if r.Choices[i].Index == index {
+			return &r.Choices[i]
+		}
+		if r.Choices[i].Index > index {
+			pos = i
+			break
+		}
 	}
 
-	contentType := httpResp.Header.Get("Content-Type")
-
-	if contentType != "text/event-stream" {
-		return nil, fmt.Errorf("expected stream, got %s", contentType)
+	r.Choices = append(r.Choices, Choice{})
+	copy(r.Choices[pos+1:], r.Choices[pos:])
+	r.Choices[pos] = Choice{
+		Index:   index,
+		Message: &Message{},
 	}
+	return &r.Choices[pos]
+}
 
-	errChan := make(chan error, 1)
-	responseChan := make(chan *Response, 1)
-
-	go func() {
-		defer close(errChan)
-		defer close(responseChan)
+func mergeChoice(base, update *Choice) {
+	if base.FinishReason == "" {
+		base.FinishReason = update.FinishReason
+	}
+	if base.NativeFinishReason == "" {
+		base.NativeFinishReason = update.NativeFinishReason
+	}
+	if base.StopReason == nil {
+		base.StopReason = update.StopReason
+	}
 
-		response, err := func() (*Response, error) {
-			defer close(chunkChan)
+	if update.Logprobs != nil {
+		if base.Logprobs == nil {
+			base.Logprobs = &Logprobs{}
+		}
+		base.Logprobs.Content = append(base.Logprobs.Content, update.Logprobs.Content...)
+		base.Logprobs.Refusal = append(base.Logprobs.Refusal, update.Logprobs.Refusal...)
+	}
 
-			var response *Response
+	delta := update.Delta
+	if delta == nil {
+		delta = update.Message
+	}
+	if delta == nil {
+		return
+	}
 
-			reader := NewSSEReader(httpResp.Body)
+	msg := base.Message
+	if msg.Role == "" {
+		msg.Role = delta.Role
+	}
+	msg.Content += delta.Content
+	msg.Refusal += delta.Refusal
+	msg.Reasoning += delta.Reasoning
+	msg.ReasoningDetails = mergeReasoningDetails(msg.ReasoningDetails, delta.ReasoningDetails)
+
+	for _, toolCall := range delta.ToolCalls {
+		var target *ToolCall
+		for i := range msg.ToolCalls {
+			if msg.ToolCalls[i].Index == toolCall.Index {
+				target = &msg.ToolCalls[i]
+				break
+			}
+		}
 
-			for {
-				event, err := reader.ReadEvent()
-				if err != nil {
-					if err != io.EOF {
-						errChan <- err
-					}
+		if target == nil {
+			msg.ToolCalls = append(msg.ToolCalls, toolCall)
+			continue
+		}
 
-					return response, nil
-				}
+		if target.ID == "" {
+			target.ID = toolCall.ID
+		}
+		if target.Type == "" {
+			target.Type = toolCall.Type
+		}
+		if target.Function.Name == "" {
+			target.Function.Name = toolCall.Function.Name
+		}
+		target.Function.Arguments += toolCall.Function.Arguments
+	}
+}
 
-				if event.Event != "" {
-					continue
-				}
+func (c *Client) SetLogger(logger Logger) {
+	c.logger = logger
+}
 
-				data := event.Data
+func (c *Client) SetBaseURL(baseURL string) {
+	if !strings.HasSuffix(baseURL, "/") {
+		baseURL += "/"
+	}
+	c.baseURL = baseURL
+}
 
-				if c.logger != nil {
-					c.logger.Log("Chunk: ", data)
-				}
+func (c *Client) SetHeader(key, value string) {
+	if c.headers == nil {
+		c.headers = make(map[string]string)
+	}
+	c.headers[key] = value
+}
 
-				if data == "[DONE]" {
-					return response, nil
-				}
+func (c *Client) SetRetryPolicy(retry RetryPolicy) {
+	c.retry = retry
+}
 
-				var resp Response
-				if err := json.Unmarshal([]byte(data), &resp); err != nil {
-					return nil, err
-				}
+// DefaultModel returns the model from the profile the client was created with.
+func (c *Client) DefaultModel() string {
+	return c.model
+}
 
-				response = mergeResponse(response, &resp)
+func (c *Client) SendRequest(req *Request) (*Response, error) {
+	return c.SendRequestContext(context.Background(), req)
+}
 
-				chunkChan <- &resp
-			}
-		}()
-		if err != nil {
-			errChan <- err
-		} else {
-			responseChan <- response
-		}
-	}()
+func (c *Client) SendRequestContext(ctx context.Context, req *Request) (*Response, error) {
+	key, cached := c.cachedResponse(req)
+	if cached != nil {
+		return cached, nil
+	}
 
-	select {
-	case err := <-errChan:
-		return nil, err
-	case resp := <-responseChan:
-		return resp, nil
+	resp, err := c.sendPlainRequest(ctx, req)
+	if err == nil && key != "" {
+		c.cacheResponse(key, resp)
 	}
+	return resp, err
 }
 
-func (c *Client) SendRequest(req *Request) (*Response, error) {
-	req.Stream = false
+func (c *Client) sendPlainRequest(ctx context.Context, req *Request) (*Response, error) {
+	if req.Stream {
+		plainReq := *req
+		plainReq.Stream = false
+		req = &plainReq
+	}
 	reqURL := c.baseURL + "chat/completions"
 
-	httpResp, err := c.sendRequest(req, reqURL)
+	httpResp, err := c.sendRequest(ctx, req, reqURL, nil)
 	if err != nil {
 		return nil, err
 	}
 
 	defer httpResp.Body.Close()
 
-	contentType := httpResp.Header.Get("Content-Type")
-
-	if contentType != "application/json" {
-		return nil, fmt.Errorf("expected application/json, got %s", contentType)
+	if httpResp.StatusCode >= 400 || !hasContentType(httpResp, "application/json") {
+		return nil, decodeAPIError(httpResp)
 	}
 
 	body, err := io.ReadAll(httpResp.Body)
@@ -175,7 +237,71 @@ func (c *Client) SendRequest(req *Request) (*Response, error) {
 	return &resp, nil
 }
 
-func (c *Client) sendRequest(req *Request, reqURL string) (*http.Response, error) {
+// APIError is an error response of the API, or a response of an unexpected
+// type, e.g. an HTML page of a proxy.
+type APIError struct {
+	StatusCode int
+	Message    string
+	// Code reported by the provider, if any
+	Code int
+}
+
+func (e *APIError) Error() string {
+	return fmt.Sprintf("API error %d: %s", e.StatusCode, e.Message)
+}
+
+// maxErrorBody limits how much of an error response is read, and
+// maxErrorMessage how much of a text one is put into the error.
+const (
+	maxErrorBody    = 64 << 10
+	maxErrorMessage = 500
+)
+
+// decodeAPIError reads the error from the response body: JSON like
+// {"error": {"message": "...", "code": 401}}, or plain text.
+func decodeAPIError(httpResp *http.Response) error {
+	body, err := io.ReadAll(io.LimitReader(httpResp.Body, maxErrorBody))
+	if err != nil {
+		return err
+	}
+
+	apiErr := &APIError{StatusCode: httpResp.StatusCode}
+
+	var payload struct {
+		Error struct {
+			Message string `json:"message"`
+			Code    any    `json:"code"`
+		} `json:"error"`
+		Message string `json:"message"`
+	}
+	if hasContentType(httpResp, "application/json") && json.Unmarshal(body, &payload) == nil {
+		apiErr.Message = payload.Error.Message
+		if apiErr.Message == "" {
+			apiErr.Message = payload.Message
+		}
+		if code, ok := payload.Error.Code.(float64); ok {
+			apiErr.Code = int(code)
+		}
+	}
+
+	if apiErr.Message == "" {
+		apiErr.Message = strings.TrimSpace(string(body))
+		if len(apiErr.Message) > maxErrorMessage {
+			apiErr.Message = apiErr.Message[:maxErrorMessage] + "..."
+		}
+	}
+	if apiErr.Message == "" {
+		apiErr.Message = fmt.Sprintf("unexpected response of type %s", httpResp.Header.Get("Content-Type"))
+	}
+	return apiErr
+}
+
+func hasContentType(httpResp *http.Response, contentType string) bool {
+	mediaType, _, err := mime.ParseMediaType(httpResp.Header.Get("Content-Type"))
+	return err == nil && mediaType == contentType
+}
+
+func (c *Client) sendRequest(ctx context.Context, req *Request, reqURL string, headers map[string]string) (*http.Response, error) {
 	reqJSON, err := json.Marshal(req)
 	if err != nil {
 		return nil, err
@@ -185,24 +311,69 @@ func (c *Client) sendRequest(req *Request, reqURL string) (*http.Response, error
 		c.logger.Log("Request: ", string(reqJSON))
 	}
 
-	httpReq, err := http.NewRequest("POST", reqURL, bytes.NewBuffer(reqJSON))
-
-	if err != nil {
-		return nil, err
+	backoff := time.Duration(c.retry.InitialBackoff)
+	if backoff <= 0 {
+		backoff = time.Second
 	}
 
-	httpReq.Header.Set("Authorization", "Bearer "+c.token)
-	httpReq.Header.Set("Content-Type", "application/json")
+	for attempt := 0; ; attempt++ {
+		httpReq, err := http.NewRequestWithContext(ctx, "POST", reqURL, bytes.NewReader(reqJSON))
+		if err != nil {
+			return nil, err
+		}
 
-	accept := []string{"application/json"}
-	if req.Stream {
-		accept = append(accept, "text/event-stream")
+		if c.token != "" {
+			httpReq.Header.Set("Authorization", "Bearer "+c.token)
+		}
+		httpReq.Header.Set("Content-Type", "application/json")
+
+		accept := []string{"application/json"}
+		if req.Stream {
+			accept = append(accept, "text/event-stream")
+		}
+		httpReq.Header.Set("Accept", strings.Join(accept, ", "))
+
+		for k, v := range c.headers {
+			httpReq.Header.Set(k, v)
+		}
+		for k, v := range headers {
+			httpReq.Header.Set(k, v)
+		}
+
+		httpResp, err := c.client.Do(httpReq)
+		if attempt >= c.retry.MaxRetries || ctx.Err() != nil || !shouldRetry(httpResp, err) {
+			return httpResp, err
+		}
+
+		if err == nil {
+			_ = httpResp.Body.Close()
+		}
+
+		if c.logger != nil {
+			c.logger.Log("Retrying after ", backoff.String())
+		}
+
+		select {
+		case <-time.After(backoff):
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+		backoff *= 2
+		if maxBackoff := time.Duration(c.retry.MaxBackoff); maxBackoff > 0 && backoff > maxBackoff {
+			backoff = maxBackoff
+		}
 	}
-	httpReq.Header.Set("Accept", strings.Join(accept, ", "))
+}
 
-	httpResp, err := c.client.Do(httpReq)
+func shouldRetry(httpResp *http.Response, err error) bool {
 	if err != nil {
-		return nil, err
+		return true
+	}
+
+	switch httpResp.StatusCode {
+	case http.StatusTooManyRequests, http.StatusInternalServerError, http.StatusBadGateway,
+		http.StatusServiceUnavailable, http.StatusGatewayTimeout:
+		return true
 	}
-	return httpResp, nil
+	return false
 }
@@ -0,0 +1,308 @@
+package llm
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Confidence describes how sure the model was about its response, based on
+// the log probabilities of the generated tokens.
+type Confidence struct {
+	// Joint probability of the whole response
+	Prob float64
+	// Probability of the least likely token
+	MinProb float64
+
+	// Confidence of each JSON value of the response, keyed by path like
+	// "items[0].name". Nil if the response is not JSON.
+	Fields map[string]FieldConfidence
+}
+
+type FieldConfidence struct {
+	// Raw JSON of the value
+	Value string
+	// Joint probability of the tokens of the value
+	Prob float64
+	// Probability of the least likely token of the value
+	MinProb float64
+}
+
+// LowConfidenceFields returns paths of the fields whose least likely token
+// is below the threshold, sorted.
+func (c *Confidence) LowConfidenceFields(threshold float64) []string {
+	var paths []string
+	for path, field := range c.Fields {
+		if field.MinProb < threshold {
+			paths = append(paths, path)
+		}
+	}
+	sort.Strings(paths)
+	return paths
+}
+
+// GetResponseWithConfidence works like GetResponse, but asks for log
+// probabilities and computes confidence of the response and its fields.
+// Only the text generated in the last round is scored, so with a prefill
+// the prefill is not a part of it. The tool response mode and continuations
+// are not supported.
+func (c *ChatClient[T]) GetResponseWithConfidence(chunkChan chan<- string, opts ...Option) (T, *Confidence, error) {
+	opts = append([]Option{WithLogprobs(0)}, opts...)
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	cfg := c.newCallConfig(opts)
+	cfg.confidence = true
+	result, choice, err := c.getResponse(chunkChan, cfg)
+	if err != nil {
+		return result, nil, err
+	}
+
+	confidence, err := ComputeConfidence(cfg.generated, choice.Logprobs)
+	return result, confidence, err
+}
+
+// ComputeConfidence maps tokens to the content and computes confidence of
+// the content and, if it's JSON, of every value in it. The content must be
+// the text the tokens were generated for. The tokens may start with
+// whitespace trimmed from the content, and go on past its end, e.g. when
+// it's cut at a stop sequence.
+func ComputeConfidence(content string, logprobs *Logprobs) (*Confidence, error) {
+	if logprobs == nil || len(logprobs.Content) == 0 {
+		return nil, fmt.Errorf("no logprobs in response")
+	}
+
+	tokens := logprobs.Content
+
+	var sb strings.Builder
+	starts := make([]int, len(tokens))
+	for i, token := range tokens {
+		starts[i] = sb.Len()
+		sb.WriteString(token.Token)
+	}
+
+	generated := sb.String()
+	shift := 0
+	if !strings.HasPrefix(generated, content) {
+		// Some providers trim the content
+		trimmed := strings.TrimLeft(generated, " \t\r\n")
+		shift = len(generated) - len(trimmed)
+		if !strings.HasPrefix(trimmed, content) {
+			return nil, fmt.Errorf("logprobs don't match the response content")
+		}
+	}
+
+	span := func(start, end int) (prob float64, minProb float64) {
+		sum := 0.0
+		minProb = 1.0
+		for i, token := range tokens {
+			ts := starts[i] - shift
+			te := ts + len(token.Token)
+			if ts < end && te > start {
+				sum += token.Logprob
+				minProb = math.Min(minProb, math.Exp(token.Logprob))
+			}
+		}
+		return math.Exp(sum), minProb
+	}
+
+	res := &Confidence{}
+	res.Prob, res.MinProb = span(0, len(content))
+
+	spans, err := jsonSpans(content)
+	if err != nil {
+		// Not JSON, e.g. a text response
+		return res, nil
+	}
+
+	res.Fields = make(map[string]FieldConfidence, len(spans))
+	for path, sp := range spans {
+		prob, minProb := span(sp[0], sp[1])
+		res.Fields[path] = FieldConfidence{
+			Value:   content[sp[0]:sp[1]],
+			Prob:    prob,
+			MinProb: minProb,
+		}
+	}
+	return res, nil
+}
+
+// jsonSpans parses JSON and returns byte ranges of all values except the
+// root one, keyed by their path.
+func jsonSpans(content string) (map[string][2]int, error) {
+	p := &jsonSpanParser{
+		data:  content,
+		spans: make(map[string][2]int),
+	}
+
+	p.skipSpace()
+	if err := p.value(""); err != nil {
+		return nil, err
+	}
+
+	p.skipSpace()
+	if p.pos != len(p.data) {
+		return nil, fmt.Errorf("unexpected data at %d", p.pos)
+	}
+
+	delete(p.spans, "")
+	return p.spans, nil
+}
+
+type jsonSpanParser struct {
+	data  string
+	pos   int
+	spans map[string][2]int
+}
+
+func (p *jsonSpanParser) skipSpace() {
+	for p.pos < len(p.data) && strings.IndexByte(" \t\r\n", p.data[p.pos]) >= 0 {
+		p.pos++
+	}
+}
+
+func (p *jsonSpanParser) value(path string) error {
+	if p.pos >= len(p.data) {
+		return fmt.Errorf("unexpected end of JSON")
+	}
+
+	start := p.pos
+	var err error
+
+	switch c := p.data[p.pos]; {
+	case c == '{':
+		err = p.object(path)
+	case c == '[':
+		err = p.array(path)
+	case c == '"':
+		_, err = p.string()
+	case c == '-' || (c >= '0' && c <= '9'):
+		p.number()
+	default:
+		err = p.literal()
+	}
+	if err != nil {
+		return err
+	}
+
+	p.spans[path] = [2]int{start, p.pos}
+	return nil
+}
+
+func (p *jsonSpanParser) object(path string) error {
+	p.pos++
+	p.skipSpace()
+	if p.pos < len(p.data) && p.data[p.pos] == '}' {
+		p.pos++
+		return nil
+	}
+
+	for {
+		p.skipSpace()
+		if p.pos >= len(p.data) || p.data[p.pos] != '"' {
+			return fmt.Errorf("expected key at %d", p.pos)
+		}
+
+		key, err := p.string()
+		if err != nil {
+			return err
+		}
+
+		p.skipSpace()
+		if p.pos >= len(p.data) || p.data[p.pos] != ':' {
+			return fmt.Errorf("expected ':' at %d", p.pos)
+		}
+		p.pos++
+		p.skipSpace()
+
+		fieldPath := key
+		if path != "" {
+			fieldPath = path + "." + key
+		}
+		if err := p.value(fieldPath); err != nil {
+			return err
+		}
+
+		if done, err := p.next('}'); done || err != nil {
+			return err
+		}
+	}
+}
+
+func (p *jsonSpanParser) array(path string) error {
+	p.pos++
+	p.skipSpace()
+	if p.pos < len(p.data) && p.data[p.pos] == ']' {
+		p.pos++
+		return nil
+	}
+
+	for i := 0; ; i++ {
+		p.skipSpace()
+		if err := p.value(path + "[" + strconv.Itoa(i) + "]"); err != nil {
+			return err
+		}
+
+		if done, err := p.next(']'); done || err != nil {
+			return err
+		}
+	}
+}
+
+// next consumes the separator after a value and reports whether the
+// container is closed.
+func (p *jsonSpanParser) next(closing byte) (bool, error) {
+	p.skipSpace()
+	if p.pos >= len(p.data) {
+		return false, fmt.Errorf("unexpected end of JSON")
+	}
+
+	switch p.data[p.pos] {
+	case ',':
+		p.pos++
+		return false, nil
+	case closing:
+		p.pos++
+		return true, nil
+	}
+	return false, fmt.Errorf("unexpected %q at %d", p.data[p.pos], p.pos)
+}
+
+func (p *jsonSpanParser) string() (string, error) {
+	start := p.pos
+	p.pos++
+	for p.pos < len(p.data) {
+		switch p.data[p.pos] {
+		case '\\':
+			p.pos += 2
+		case '"':
+			p.pos++
+			var s string
+			err := json.Unmarshal([]byte(p.data[start:p.pos]), &s)
+			return s, err
+		default:
+			p.pos++
+		}
+	}
+	return "", fmt.Errorf("unterminated string at %d", start)
+}
+
+func (p *jsonSpanParser) number() {
+	for p.pos < len(p.data) && strings.IndexByte("+-0123456789.eE", p.data[p.pos]) >= 0 {
+		p.pos++
+	}
+}
+
+func (p *jsonSpanParser) literal() error {
+	for _, lit := range []string{"true", "false", "null"} {
+		if strings.HasPrefix(p.data[p.pos:], lit) {
+			p.pos += len(lit)
+			return nil
+		}
+	}
+	return fmt.Errorf("unexpected %q at %d", p.data[p.pos], p.pos)
+}
@@ -0,0 +1,133 @@
+package llm
+
+import (
+	"encoding/json"
+	"math"
+	"net/http"
+	"testing"
+)
+
+func tokenLogprobs(tokens ...string) *Logprobs {
+	logprobs := &Logprobs{}
+	for _, token := range tokens {
+		logprobs.Content = append(logprobs.Content, TokenLogprob{Token: token, Logprob: math.Log(0.5)})
+	}
+	return logprobs
+}
+
+func near(a, b float64) bool {
+	return math.Abs(a-b) < 1e-9
+}
+
+func TestComputeConfidence(t *testing.T) {
+	tests := []struct {
+		name     string
+		content  string
+		tokens   []string
+		wantProb float64
+		wantErr  bool
+	}{
+		{"exact", "Hello world", []string{"Hello", " world"}, 0.25, false},
+		{"trimmed whitespace", "Hello", []string{"\n", "Hello"}, 0.5, false},
+		{"cut at a stop sequence", "Hello", []string{"Hello", " STOP"}, 0.5, false},
+		{"content later in the tokens", "world", []string{"Hello", " world"}, 0, true},
+		{"content longer than the tokens", "Hello world", []string{"Hello"}, 0, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			res, err := ComputeConfidence(tt.content, tokenLogprobs(tt.tokens...))
+			if tt.wantErr {
+				if err == nil {
+					t.Errorf("got %+v, want error", res)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !near(res.Prob, tt.wantProb) || !near(res.MinProb, 0.5) || res.Fields != nil {
+				t.Errorf("got %+v", res)
+			}
+		})
+	}
+}
+
+func TestComputeConfidenceFields(t *testing.T) {
+	res, err := ComputeConfidence(`{"a": 1, "b": [true]}`, tokenLogprobs(`{"`, `a`, `": `, `1`, `, "b": [`, `true`, `]}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]string{"a": "1", "b": "[true]", "b[0]": "true"}
+	if len(res.Fields) != len(want) {
+		t.Fatalf("got fields %+v", res.Fields)
+	}
+	for path, value := range want {
+		field := res.Fields[path]
+		if field.Value != value || !near(field.MinProb, 0.5) {
+			t.Errorf("%s: got %+v", path, field)
+		}
+	}
+	if !near(res.Fields["a"].Prob, 0.5) || !near(res.Fields["b"].Prob, 0.125) {
+		t.Errorf("got fields %+v", res.Fields)
+	}
+}
+
+type confidenceResult struct {
+	Answer string `json:"answer"`
+}
+
+func TestGetResponseWithConfidence(t *testing.T) {
+	requests := 0
+	var content string
+	var logprobs *Logprobs
+	c := newTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(Response{Choices: []Choice{{
+			Message:      &Message{Role: "assistant", Content: content},
+			Logprobs:     logprobs,
+			FinishReason: FinishReasonStop,
+		}}})
+	})
+
+	chat := NewChatClientWithClient[string](c, nil)
+	chat.SetModel("m")
+	chat.AddMessage("user", "Is it?")
+
+	// Only the generated text is scored, the prefill is not a part of it
+	content, logprobs = " yes", tokenLogprobs(" yes")
+	result, confidence, err := chat.GetResponseWithConfidence(nil, WithPrefill("The answer is"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result != "The answer is yes" || !near(confidence.Prob, 0.5) {
+		t.Errorf("got %q, %+v", result, confidence)
+	}
+
+	obj := NewChatClientWithClient[confidenceResult](c, nil)
+	obj.SetModel("m")
+	obj.AddMessage("user", "Is it?")
+
+	content, logprobs = `{"answer": "yes"}`, tokenLogprobs(`{"answer": "`, `yes`, `"}`)
+	_, confidence, err = obj.GetResponseWithConfidence(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if field := confidence.Fields["answer"]; field.Value != `"yes"` || !near(field.Prob, 0.125) {
+		t.Errorf("got %+v", confidence.Fields)
+	}
+
+	// Unsupported modes fail before the request is sent
+	requests = 0
+	obj.SetResponseMode(ResponseModeTool)
+	if _, _, err := obj.GetResponseWithConfidence(nil); err == nil {
+		t.Error("confidence in the tool response mode succeeded")
+	}
+	if _, _, err := chat.GetResponseWithConfidence(nil, WithContinuation(1)); err == nil {
+		t.Error("confidence with continuations succeeded")
+	}
+	if requests != 0 {
+		t.Errorf("%d requests were sent", requests)
+	}
+}
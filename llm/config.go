@@ -0,0 +1,273 @@
+package llm
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+const (
+	ProfileEnv = "LLM_PROFILE"
+	ConfigEnv  = "LLM_CONFIG"
+
+	DefaultProfileName = "default"
+	DefaultModel       = "openai/gpt-4o-mini"
+)
+
+type providerInfo struct {
+	baseURL  string
+	tokenEnv string
+}
+
+var providers = map[string]providerInfo{
+	"openrouter": {baseURL: "https://openrouter.ai/api/v1/", tokenEnv: "OPENROUTER_TOKEN"},
+	"openai":     {baseURL: "https://api.openai.com/v1/", tokenEnv: "OPENAI_API_KEY"},
+	"groq":       {baseURL: "https://api.groq.com/openai/v1/", tokenEnv: "GROQ_API_KEY"},
+	"mistral":    {baseURL: "https://api.mistral.ai/v1/", tokenEnv: "MISTRAL_API_KEY"},
+	"ollama":     {baseURL: "http://localhost:11434/v1/"},
+}
+
+// Config is the content of the config file: a set of named profiles.
+//
+//	{
+//	  "default_profile": "work",
+//	  "profiles": {
+//	    "work": {
+//	      "provider": "openrouter",
+//	      "token": {"command": ["pass", "show", "openrouter"]},
+//	      "model": "openai/gpt-4o",
+//	      "temperature": 0.2,
+//	      "retry": {"max_retries": 3, "initial_backoff": "1s", "max_stream_resumes": 2}
+//	    }
+//	  }
+//	}
+type Config struct {
+	DefaultProfile string              `json:"default_profile,omitempty"`
+	Profiles       map[string]*Profile `json:"profiles,omitempty"`
+}
+
+type Profile struct {
+	Name string `json:"-"`
+
+	Provider    string            `json:"provider,omitempty"`
+	BaseURL     string            `json:"base_url,omitempty"`
+	Token       TokenSource       `json:"token,omitempty"`
+	Model       string            `json:"model,omitempty"`
+	Temperature *float64          `json:"temperature,omitempty"`
+	MaxTokens   int               `json:"max_tokens,omitempty"`
+	Headers     map[string]string `json:"headers,omitempty"`
+	Retry       RetryPolicy       `json:"retry,omitempty"`
+}
+
+// TokenSource says where the API token comes from. The first non-empty
+// source is used. If none is set, the provider's environment variable is
+// checked, and for OpenRouter the legacy lookup of GetToken is used.
+type TokenSource struct {
+	Env     string   `json:"env,omitempty"`
+	File    string   `json:"file,omitempty"`
+	Keyring string   `json:"keyring,omitempty"`
+	Command []string `json:"command,omitempty"`
+}
+
+type RetryPolicy struct {
+	MaxRetries     int      `json:"max_retries,omitempty"`
+	InitialBackoff Duration `json:"initial_backoff,omitempty"`
+	MaxBackoff     Duration `json:"max_backoff,omitempty"`
+
+	// Streams without any data for this time are considered broken,
+	// DefaultStreamIdleTimeout if not set
+	StreamIdleTimeout Duration `json:"stream_idle_timeout,omitempty"`
+	// Number of attempts to resume a broken stream, see SendStreamRequest
+	MaxStreamResumes int `json:"max_stream_resumes,omitempty"`
+}
+
+// Duration is a time.Duration that is written in JSON as a string like "1.5s".
+type Duration time.Duration
+
+func (d Duration) MarshalJSON() ([]byte, error) {
+	return json.Marshal(time.Duration(d).String())
+}
+
+func (d *Duration) UnmarshalJSON(data []byte) error {
+	var s string
+	if err := json.Unmarshal(data, &s); err != nil {
+		var secs float64
+		if err := json.Unmarshal(data, &secs); err != nil {
+			return fmt.Errorf("invalid duration %s", data)
+		}
+		*d = Duration(secs * float64(time.Second))
+		return nil
+	}
+
+	v, err := time.ParseDuration(s)
+	if err != nil {
+		return err
+	}
+	*d = Duration(v)
+	return nil
+}
+
+func ConfigPath() (string, error) {
+	if path := os.Getenv(ConfigEnv); path != "" {
+		return path, nil
+	}
+
+	dir, err := os.UserConfigDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, "llm-stuff", "config.json"), nil
+}
+
+// LoadConfig reads the config file at path. Missing file is not an error,
+// an empty config is returned instead.
+func LoadConfig(path string) (*Config, error) {
+	config := &Config{}
+
+	content, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return config, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if err := json.Unmarshal(content, config); err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	return config, nil
+}
+
+// Profile returns the profile with the given name. Empty name means
+// $LLM_PROFILE, then the default profile from the config.
+func (c *Config) Profile(name string) (*Profile, error) {
+	if name == "" {
+		name = os.Getenv(ProfileEnv)
+	}
+	if name == "" {
+		name = c.DefaultProfile
+	}
+	if name == "" {
+		name = DefaultProfileName
+	}
+
+	profile, ok := c.Profiles[name]
+	if !ok {
+		if name != DefaultProfileName {
+			return nil, fmt.Errorf("profile %s not found", name)
+		}
+		profile = &Profile{}
+	}
+
+	res := *profile
+	res.Name = name
+	if res.Provider == "" {
+		res.Provider = "openrouter"
+	}
+	if res.Model == "" && res.Provider == "openrouter" {
+		res.Model = DefaultModel
+	}
+	return &res, nil
+}
+
+// LoadProfile loads the config from the default location and returns the named profile.
+func LoadProfile(name string) (*Profile, error) {
+	path, err := ConfigPath()
+	if err != nil {
+		return nil, err
+	}
+
+	config, err := LoadConfig(path)
+	if err != nil {
+		return nil, err
+	}
+
+	return config.Profile(name)
+}
+
+func (p *Profile) GetBaseURL() (string, error) {
+	baseURL := p.BaseURL
+	if baseURL == "" {
+		info, ok := providers[p.Provider]
+		if !ok {
+			return "", fmt.Errorf("unknown provider %s, base_url must be set", p.Provider)
+		}
+		baseURL = info.baseURL
+	}
+
+	if !strings.HasSuffix(baseURL, "/") {
+		baseURL += "/"
+	}
+	return baseURL, nil
+}
+
+func (p *Profile) GetToken() (string, error) {
+	src := p.Token
+
+	switch {
+	case src.Env != "":
+		if token := os.Getenv(src.Env); token != "" {
+			return token, nil
+		}
+		return "", fmt.Errorf("environment variable %s is not set", src.Env)
+	case src.File != "":
+		content, err := os.ReadFile(os.ExpandEnv(src.File))
+		if err != nil {
+			return "", err
+		}
+		return strings.TrimSpace(string(content)), nil
+	case src.Keyring != "":
+		return keyringGetToken(src.Keyring)
+	case len(src.Command) > 0:
+		cmd := exec.Command(src.Command[0], src.Command[1:]...)
+		cmd.Stderr = os.Stderr
+		out, err := cmd.Output()
+		if err != nil {
+			return "", fmt.Errorf("token command: %w", err)
+		}
+		return strings.TrimSpace(string(out)), nil
+	}
+
+	if p.Provider == "openrouter" {
+		return GetToken()
+	}
+
+	info := providers[p.Provider]
+	if info.tokenEnv == "" {
+		return "", nil
+	}
+	if token := os.Getenv(info.tokenEnv); token != "" {
+		return token, nil
+	}
+	return "", fmt.Errorf("token not found, set %s or configure token source", info.tokenEnv)
+}
+
+func NewClientFromConfig(profile *Profile) (*Client, error) {
+	token, err := profile.GetToken()
+	if err != nil {
+		return nil, err
+	}
+
+	baseURL, err := profile.GetBaseURL()
+	if err != nil {
+		return nil, err
+	}
+
+	client := NewClient(token)
+	client.baseURL = baseURL
+	client.retry = profile.Retry
+	client.model = profile.Model
+	client.temperature = profile.Temperature
+	client.maxTokens = profile.MaxTokens
+
+	for k, v := range profile.Headers {
+		client.SetHeader(k, v)
+	}
+
+	return client, nil
+}
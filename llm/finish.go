@@ -0,0 +1,103 @@
+package llm
+
+import (
+	"fmt"
+	"strings"
+)
+
+type FinishReason string
+
+const (
+	FinishReasonStop          FinishReason = "stop"
+	FinishReasonLength        FinishReason = "length"
+	FinishReasonToolCalls     FinishReason = "tool_calls"
+	FinishReasonContentFilter FinishReason = "content_filter"
+	FinishReasonError         FinishReason = "error"
+)
+
+// Normalize maps provider-specific finish reasons to the standard ones.
+// Unknown reasons are returned lowercased.
+func (r FinishReason) Normalize() FinishReason {
+	reason := FinishReason(strings.ToLower(string(r)))
+	switch reason {
+	case "end_turn", "stop_sequence", "eos", "eos_token", "finish_reason_stop":
+		return FinishReasonStop
+	case "max_tokens", "max_output_tokens", "model_length":
+		return FinishReasonLength
+	case "tool_use", "function_call":
+		return FinishReasonToolCalls
+	case "safety", "recitation", "blocklist", "prohibited_content", "spii":
+		return FinishReasonContentFilter
+	}
+	return reason
+}
+
+// IncompleteError is returned when the model stopped before finishing the response,
+// e.g. because of the token limit. Partial contains the text generated so far.
+type IncompleteError struct {
+	Reason  FinishReason
+	Partial string
+}
+
+func (e *IncompleteError) Error() string {
+	return fmt.Sprintf("response incomplete, finish reason %s", e.Reason)
+}
+
+// ContentFilterError is returned when the provider's content filter stopped the response.
+type ContentFilterError struct {
+	Partial string
+}
+
+func (e *ContentFilterError) Error() string {
+	return "response stopped by content filter"
+}
+
+const continuationPrompt = "Your previous response was cut off. Continue exactly from where it stopped. " +
+	"Do not repeat anything, do not add any preamble, comments or markdown."
+
+const jsonContinuationPrompt = "Your previous response was cut off. Continue the JSON exactly from the last character. " +
+	"Do not start a new JSON document, do not repeat anything, do not add any preamble, comments or markdown."
+
+// Repeated text shorter than minContinuationOverlap is likely a coincidence
+// and is not treated as overlap.
+const (
+	minContinuationOverlap = 8
+	maxContinuationOverlap = 1000
+)
+
+// stitchContinuation appends the continuation to the truncated text. Models
+// often repeat the tail of the previous message or wrap the continuation
+// into a code block, both are removed.
+func stitchContinuation(prev, next string, isJSON bool) string {
+	if isJSON {
+		trimmed := strings.TrimLeft(next, " \t\r\n")
+		if strings.HasPrefix(trimmed, "```") {
+			_, rest, found := strings.Cut(trimmed, "\n")
+			if found {
+				next = rest
+			}
+		}
+		if trimmed := strings.TrimRight(next, " \t\r\n"); strings.HasSuffix(trimmed, "```") {
+			next = strings.TrimSuffix(trimmed, "```")
+		}
+	}
+
+	maxOverlap := min(len(prev), len(next), maxContinuationOverlap)
+	for n := maxOverlap; n >= minContinuationOverlap; n-- {
+		if strings.HasSuffix(prev, next[:n]) {
+			return prev + next[n:]
+		}
+	}
+	return prev + next
+}
+
+// RefusalError is returned when the model refused to respond. Refusal is the
+// explanation from the model, Partial is the content generated before it.
+type RefusalError struct {
+	Refusal string
+	Partial string
+}
+
+func (e *RefusalError) Error() string {
+	return fmt.Sprintf("model refused: %s", e.Refusal)
+}
@@ -0,0 +1,53 @@
+package llm
+
+import (
+	"net/http"
+	"strings"
+	"testing"
+)
+
+func TestContinuationStream(t *testing.T) {
+	rounds := []string{"line one\nline two\n", "line two\nline three\n"}
+	requests := 0
+	c := newTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+		finish := FinishReasonLength
+		if requests == len(rounds)-1 {
+			finish = FinishReasonStop
+		}
+		startStream(w)
+		// The content is streamed in pieces to go through the stop filter
+		for _, line := range strings.SplitAfter(rounds[requests], "\n") {
+			writeEvents(w, contentChunk(line, ""))
+		}
+		writeEvents(w, contentChunk("", finish), "data: [DONE]")
+		requests++
+	})
+
+	chat := NewChatClientWithClient[string](c, nil)
+	chat.SetModel("m")
+	chat.AddMessage("user", "Hi")
+
+	chunkChan := make(chan string)
+	var streamed strings.Builder
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for chunk := range chunkChan {
+			streamed.WriteString(chunk)
+		}
+	}()
+
+	result, err := chat.GetResponse(chunkChan, WithContinuation(2), WithStop([]string{"```"}))
+	<-done
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := "line one\nline two\nline three\n"
+	if result != want || streamed.String() != want {
+		t.Errorf("got %q, streamed %q", result, streamed.String())
+	}
+	if messages := chat.Messages(); len(messages) != 2 || messages[1].Content != want {
+		t.Errorf("got messages %+v", messages)
+	}
+}
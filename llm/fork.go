@@ -0,0 +1,223 @@
+package llm
+
+import (
+	"fmt"
+	"slices"
+)
+
+func cloneMessage(msg Message) Message {
+	msg.ToolCalls = slices.Clone(msg.ToolCalls)
+	msg.Parts = slices.Clone(msg.Parts)
+	msg.ReasoningDetails = slices.Clone(msg.ReasoningDetails)
+	return msg
+}
+
+func cloneMessages(messages []Message) []Message {
+	if messages == nil {
+		return nil
+	}
+
+	res := make([]Message, len(messages))
+	for i, msg := range messages {
+		res[i] = cloneMessage(msg)
+	}
+	return res
+}
+
+// Clone returns an independent copy of the client with the same settings
+// and conversation. The clone is not attached to the session of the client,
+// see Fork. Functions, moderator and approver are shared. Settings are
+// copied only when one of the clients changes them, so cloning is cheap.
+func (c *ChatClient[T]) Clone() *ChatClient[T] {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return c.clone()
+}
+
+func (c *ChatClient[T]) clone() *ChatClient[T] {
+	c.sharedCfg = true
+	return &ChatClient[T]{
+		cfg:       c.cfg,
+		sharedCfg: true,
+
+		messages:  cloneMessages(c.messages),
+		moderated: c.moderated,
+
+		head: noNode,
+	}
+}
+
+// Fork works like Clone, but the fork stays attached to the session of the
+// client, so its messages become a new branch of the conversation.
+func (c *ChatClient[T]) Fork() *ChatClient[T] {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	fork := c.clone()
+	fork.session = c.session
+	fork.head = c.head
+	return fork
+}
+
+// Messages returns a copy of the conversation.
+func (c *ChatClient[T]) Messages() []Message {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return cloneMessages(c.messages)
+}
+
+// SetSession attaches the client to a session and records the current
+// conversation in it.
+func (c *ChatClient[T]) SetSession(session *Session) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.session = session
+	c.head = noNode
+	if session != nil {
+		c.head = session.record(noNode, c.messages, 0)
+	}
+}
+
+// SessionHead returns ID of the last message of the conversation in the
+// session, or -1 if there's none.
+func (c *ChatClient[T]) SessionHead() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return c.head
+}
+
+// Checkout replaces the conversation with the path to a message of the
+// session, e.g. to continue another branch.
+func (c *ChatClient[T]) Checkout(id int) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.session == nil {
+		return fmt.Errorf("no session")
+	}
+	if c.session.Node(id) == nil {
+		return fmt.Errorf("no message %d in the session", id)
+	}
+
+	c.messages = c.session.Path(id)
+	c.head = id
+	c.moderated = len(c.messages)
+	return nil
+}
+
+// SetMessages replaces the conversation, e.g. with one saved earlier. The
+// messages are not moderated again.
+func (c *ChatClient[T]) SetMessages(messages []Message) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.setMessages(cloneMessages(messages), 0)
+	c.moderated = len(c.messages)
+}
+
+// appendMessages is the only way messages are added to the conversation,
+// so that they're recorded in the session.
+func (c *ChatClient[T]) appendMessages(messages ...Message) {
+	c.messages = append(c.messages, messages...)
+	// The head is the last message of the conversation, so only the new
+	// ones have to be added
+	if c.session != nil {
+		c.head = c.session.extend(c.head, messages)
+	}
+}
+
+// setMessages replaces the conversation, e.g. after it's rewound. The first
+// keep messages are the same as before.
+func (c *ChatClient[T]) setMessages(messages []Message, keep int) {
+	c.messages = messages
+	if c.moderated > len(messages) {
+		c.moderated = len(messages)
+	}
+	if c.session != nil {
+		c.head = c.session.record(c.head, messages, keep)
+	}
+}
+
+// turnStarts returns indices of the user messages, each of them starts a turn.
+func (c *ChatClient[T]) turnStarts() []int {
+	var starts []int
+	for i, msg := range c.messages {
+		if msg.Role == "user" {
+			starts = append(starts, i)
+		}
+	}
+	return starts
+}
+
+// Turns returns the number of turns, that is user messages, in the conversation.
+func (c *ChatClient[T]) Turns() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return len(c.turnStarts())
+}
+
+// Rewind drops the last n turns: user messages with all responses to them.
+func (c *ChatClient[T]) Rewind(n int) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	starts := c.turnStarts()
+	if n < 0 || n > len(starts) {
+		return fmt.Errorf("can't rewind %d turns, there are %d", n, len(starts))
+	}
+	if n == 0 {
+		return nil
+	}
+
+	// Copy, so that a branch doesn't overwrite messages of another one
+	// sharing the array
+	keep := starts[len(starts)-n]
+	c.setMessages(cloneMessages(c.messages[:keep]), keep)
+	return nil
+}
+
+// EditTurn replaces the user message of a turn, counting from 0, and drops
+// everything after it. Call GetResponse to regenerate the response.
+func (c *ChatClient[T]) EditTurn(turn int, content string) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	starts := c.turnStarts()
+	if turn < 0 || turn >= len(starts) {
+		return fmt.Errorf("no turn %d, there are %d", turn, len(starts))
+	}
+
+	start := starts[turn]
+	messages := cloneMessages(c.messages[:start+1])
+	messages[start].Content = content
+
+	c.setMessages(messages, start)
+	// The new content has to be checked
+	c.moderated = min(c.moderated, start)
+	return nil
+}
+
+// Regenerate drops the responses to the last user message and gets a new one.
+func (c *ChatClient[T]) Regenerate(chunkChan chan<- string, opts ...Option) (T, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	starts := c.turnStarts()
+	if len(starts) == 0 {
+		if chunkChan != nil {
+			close(chunkChan)
+		}
+		return *new(T), fmt.Errorf("no user message to respond to")
+	}
+
+	last := starts[len(starts)-1]
+	c.setMessages(cloneMessages(c.messages[:last+1]), last+1)
+
+	result, _, err := c.getResponse(chunkChan, c.newCallConfig(opts))
+	return result, err
+}
@@ -0,0 +1,164 @@
+package llm
+
+import (
+	"bytes"
+	"reflect"
+	"slices"
+	"strings"
+	"testing"
+)
+
+func newTestConversation() *ChatClient[string] {
+	chat := NewChatClient("token", nil)
+	chat.SetModel("base")
+	chat.SetOptions(WithStop([]string{"END"}))
+	chat.AddMessage("system", "Be brief.")
+	chat.AddMessage("user", "q1")
+	chat.SetMessages(append(chat.Messages(), toolCallMessage("call1", "ping"), Message{
+		Role:       "tool",
+		Content:    "{}",
+		ToolCallID: "call1",
+	}))
+	chat.AddMessage("assistant", "a1")
+	chat.AddMessage("user", "q2")
+	chat.AddMessage("assistant", "a2")
+	return chat
+}
+
+// checkHead fails if the session head of the client is not its last message.
+func checkHead(t *testing.T, chat *ChatClient[string]) {
+	t.Helper()
+	if path := chat.session.Path(chat.SessionHead()); !reflect.DeepEqual(path, chat.Messages()) {
+		t.Errorf("session path %+v, want %+v", path, chat.Messages())
+	}
+}
+
+func TestForkCopies(t *testing.T) {
+	base := newTestConversation()
+	base.SetSession(NewSession())
+	messages := base.Messages()
+	req := *base.cfg.req
+
+	for _, fork := range []*ChatClient[string]{base.Clone(), base.Fork()} {
+		fork.SetModel("fork")
+		fork.SetOptions(WithTemperature(1))
+		fork.config().req.ResponseFormat.Type = "json_object"
+
+		// Messages are not shared, not even the arrays of tool calls
+		fork.messages[2].ToolCalls[0].Function.Name = "changed"
+		if err := fork.Rewind(1); err != nil {
+			t.Fatal(err)
+		}
+		fork.AddMessage("user", "other")
+
+		if got := base.Messages(); !reflect.DeepEqual(got, messages) {
+			t.Errorf("messages of the base changed to %+v", got)
+		}
+		if !reflect.DeepEqual(*base.cfg.req, req) || len(base.cfg.opts) != 1 {
+			t.Errorf("settings of the base changed to %+v, %d options", base.cfg.req, len(base.cfg.opts))
+		}
+	}
+	checkHead(t, base)
+}
+
+func TestRewind(t *testing.T) {
+	chat := newTestConversation()
+	chat.SetSession(NewSession())
+
+	for _, n := range []int{-1, 3} {
+		if err := chat.Rewind(n); err == nil {
+			t.Errorf("Rewind(%d) succeeded", n)
+		}
+	}
+	if err := chat.Rewind(0); err != nil || chat.Turns() != 2 {
+		t.Errorf("Rewind(0): %v, %d turns", err, chat.Turns())
+	}
+
+	if err := chat.Rewind(1); err != nil {
+		t.Fatal(err)
+	}
+	if messages := chat.Messages(); len(messages) != 5 || messages[4].Content != "a1" {
+		t.Errorf("got %+v", messages)
+	}
+	checkHead(t, chat)
+
+	// Messages before the first turn are kept
+	if err := chat.Rewind(1); err != nil {
+		t.Fatal(err)
+	}
+	if messages := chat.Messages(); len(messages) != 1 || messages[0].Role != "system" {
+		t.Errorf("got %+v", messages)
+	}
+	checkHead(t, chat)
+}
+
+func TestSessionSaveLoad(t *testing.T) {
+	session := NewSession()
+	chat := newTestConversation()
+	chat.SetSession(session)
+
+	// Three branches: the original one, an edited second turn and a fork
+	// of the first turn
+	fork := chat.Fork()
+	if err := fork.Rewind(1); err != nil {
+		t.Fatal(err)
+	}
+	fork.AddMessage("user", "q2 of the fork")
+
+	edited := chat.Fork()
+	if err := edited.EditTurn(1, "q2 edited"); err != nil {
+		t.Fatal(err)
+	}
+	edited.AddMessage("assistant", "a2 edited")
+
+	for _, c := range []*ChatClient[string]{chat, fork, edited} {
+		checkHead(t, c)
+	}
+
+	var buf bytes.Buffer
+	if err := session.Save(&buf); err != nil {
+		t.Fatal(err)
+	}
+	loaded, err := LoadSession(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	leaves := loaded.Leaves()
+	if !slices.Equal(leaves, session.Leaves()) || len(leaves) != 3 {
+		t.Errorf("got leaves %v, want %v", leaves, session.Leaves())
+	}
+	for _, c := range []*ChatClient[string]{chat, fork, edited} {
+		if path := loaded.Path(c.SessionHead()); !reflect.DeepEqual(path, c.Messages()) {
+			t.Errorf("loaded path %+v, want %+v", path, c.Messages())
+		}
+	}
+
+	// The second turn is where the branches meet
+	branch := loaded.Children(4)
+	if len(branch) != 3 {
+		t.Errorf("got children %v of the branch point", branch)
+	}
+
+	resumed := NewChatClient("token", nil)
+	resumed.SetSession(loaded)
+	if err := resumed.Checkout(edited.SessionHead()); err != nil {
+		t.Fatal(err)
+	}
+	resumed.AddMessage("user", "q3")
+	checkHead(t, resumed)
+}
+
+func TestLoadSessionInvalid(t *testing.T) {
+	for _, data := range []string{
+		`{"nodes": [null]}`,
+		`{"nodes": [{"id": 1, "parent": -1}]}`,
+		`{"nodes": [{"id": 0, "parent": 0}]}`,
+		`{"nodes": [{"id": 0, "parent": -2}]}`,
+		`{"nodes": [`,
+	} {
+		if _, err := LoadSession(strings.NewReader(data)); err == nil {
+			t.Errorf("%s was loaded", data)
+		}
+	}
+}
@@ -0,0 +1,172 @@
+package llm
+
+import (
+	"fmt"
+	"time"
+)
+
+// Limits stop the tool loop of GetResponse. When a limit is hit, the model is
+// asked for a final answer without tools, so that final request may go over
+// the token and cost budgets. Zero values mean no limit.
+type Limits struct {
+	// Rounds of tool calls
+	MaxRounds int
+	// Tool calls in total, calls over the limit are not run
+	MaxToolCalls int
+	// Time since the start of GetResponse
+	MaxDuration time.Duration
+	// Total tokens of all requests
+	MaxTokens int
+	// Total cost of all requests, see Usage.Cost
+	MaxCost float64
+	// How many times the model may make the same call with the same arguments
+	MaxRepeatedCalls int
+}
+
+// DefaultLimits protect against models that never stop calling tools.
+var DefaultLimits = Limits{
+	MaxRounds:        20,
+	MaxRepeatedCalls: 3,
+}
+
+type StopReason string
+
+const (
+	StopReasonDone          StopReason = "done"
+	StopReasonMaxRounds     StopReason = "max_rounds"
+	StopReasonMaxToolCalls  StopReason = "max_tool_calls"
+	StopReasonMaxDuration   StopReason = "max_duration"
+	StopReasonMaxTokens     StopReason = "max_tokens"
+	StopReasonMaxCost       StopReason = "max_cost"
+	StopReasonRepeatedCalls StopReason = "repeated_calls"
+	StopReasonStopTool      StopReason = "stop_tool"
+)
+
+// RunResult describes how GetResponse went.
+type RunResult struct {
+	// StopReason is StopReasonDone if the model answered on its own, the
+	// limit that made it answer, or StopReasonStopTool
+	StopReason StopReason
+	Rounds     int
+	ToolCalls  int
+	// Usage summed over all requests
+	Usage    Usage
+	Duration time.Duration
+	// Stop sequence that ended the response, if it's known, see WithStop
+	StopSequence string
+}
+
+// LimitError is returned if the model keeps calling tools after a limit is
+// hit and it's asked to answer.
+type LimitError struct {
+	Reason StopReason
+}
+
+func (e *LimitError) Error() string {
+	return fmt.Sprintf("tool loop stopped: %s", e.Reason)
+}
+
+// WithLimits sets limits of the tool loop, DefaultLimits by default.
+func WithLimits(limits Limits) Option {
+	return func(c *callConfig) {
+		c.limits = limits
+	}
+}
+
+// run tracks a single GetResponse call against its limits.
+type run struct {
+	limits Limits
+	result *RunResult
+	start  time.Time
+	calls  map[string]int
+	// Tools that end the loop, see WithStopTools
+	stopTools []string
+	// The model was asked to answer without tools
+	forced bool
+}
+
+func newRun(limits Limits, result *RunResult) *run {
+	return &run{
+		limits: limits,
+		result: result,
+		start:  time.Now(),
+		calls:  make(map[string]int),
+	}
+}
+
+func (r *run) stop(reason StopReason) {
+	if r.result.StopReason == "" {
+		r.result.StopReason = reason
+	}
+}
+
+func (r *run) addUsage(usage *Usage) {
+	r.result.Duration = time.Since(r.start)
+	r.result.Usage.Add(usage)
+}
+
+// Add adds the tokens and the cost of other to u.
+func (u *Usage) Add(other *Usage) {
+	if other == nil {
+		return
+	}
+
+	u.PromptTokens += other.PromptTokens
+	u.CompletionTokens += other.CompletionTokens
+	u.TotalTokens += other.TotalTokens
+	u.Cost += other.Cost
+	if cached := other.CachedTokens(); cached > 0 {
+		if u.PromptTokensDetails == nil {
+			u.PromptTokensDetails = &PromptTokensDetails{}
+		}
+		u.PromptTokensDetails.CachedTokens += cached
+	}
+	if reasoning := other.ReasoningTokens(); reasoning > 0 {
+		if u.CompletionTokensDetails == nil {
+			u.CompletionTokensDetails = &CompletionTokensDetails{}
+		}
+		u.CompletionTokensDetails.ReasoningTokens += reasoning
+	}
+}
+
+// allowCall returns the reason why a tool call must not be run.
+func (r *run) allowCall(toolCall ToolCall) string {
+	if r.limits.MaxToolCalls > 0 && r.result.ToolCalls >= r.limits.MaxToolCalls {
+		r.stop(StopReasonMaxToolCalls)
+		return "the limit of tool calls is reached"
+	}
+
+	key := toolCall.Function.Name + "\x00" + toolCall.Function.Arguments
+	r.calls[key]++
+	if r.limits.MaxRepeatedCalls > 0 && r.calls[key] > r.limits.MaxRepeatedCalls {
+		r.stop(StopReasonRepeatedCalls)
+		return "the same call was already made, use its result"
+	}
+
+	r.result.ToolCalls++
+	return ""
+}
+
+// endRound is called after the tool calls of a round are handled and
+// reports whether the model must answer now.
+func (r *run) endRound() bool {
+	r.result.Rounds++
+	r.result.Duration = time.Since(r.start)
+
+	switch {
+	case r.limits.MaxRounds > 0 && r.result.Rounds >= r.limits.MaxRounds:
+		r.stop(StopReasonMaxRounds)
+	case r.limits.MaxDuration > 0 && r.result.Duration >= r.limits.MaxDuration:
+		r.stop(StopReasonMaxDuration)
+	case r.limits.MaxTokens > 0 && r.result.Usage.TotalTokens >= r.limits.MaxTokens:
+		r.stop(StopReasonMaxTokens)
+	case r.limits.MaxCost > 0 && r.result.Usage.Cost >= r.limits.MaxCost:
+		r.stop(StopReasonMaxCost)
+	}
+	return r.result.StopReason != ""
+}
+
+func (r *run) done() {
+	r.stop(StopReasonDone)
+	r.result.Duration = time.Since(r.start)
+}
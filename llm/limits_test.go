@@ -0,0 +1,118 @@
+package llm
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"testing"
+)
+
+func writeMessage(w http.ResponseWriter, msg Message, finish FinishReason) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(Response{Choices: []Choice{{
+		Message:      &msg,
+		FinishReason: finish,
+	}}})
+}
+
+func toolCallMessage(id, name string) Message {
+	return Message{Role: "assistant", ToolCalls: []ToolCall{{
+		ID:       id,
+		Type:     "function",
+		Function: FunctionCall{Name: name, Arguments: "{}"},
+	}}}
+}
+
+// checkToolCallsAnswered returns an error if a call in the history has no
+// result, which APIs reject.
+func checkToolCallsAnswered(messages []Message) error {
+	for i, msg := range messages {
+		for _, toolCall := range msg.ToolCalls {
+			answered := false
+			for _, next := range messages[i+1:] {
+				if next.Role != "tool" {
+					break
+				}
+				answered = answered || next.ToolCallID == toolCall.ID
+			}
+			if !answered {
+				return fmt.Errorf("call %s of message %d is not answered", toolCall.ID, i)
+			}
+		}
+	}
+	return nil
+}
+
+type pingRequest struct{}
+
+type pingResponse struct {
+	Pong bool `json:"pong"`
+}
+
+func TestLimitErrorHistory(t *testing.T) {
+	calls := 0
+	c := newTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+		var req Request
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			t.Error(err)
+		}
+		if err := checkToolCallsAnswered(req.Messages); err != nil {
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusBadRequest)
+			fmt.Fprintf(w, `{"error":{"message":%q,"code":400}}`, err.Error())
+			return
+		}
+
+		if req.Messages[len(req.Messages)-1].Content == "Stop" {
+			writeMessage(w, Message{Role: "assistant", Content: "Stopped"}, FinishReasonStop)
+			return
+		}
+		// The model keeps calling the tool even when it's asked to answer
+		calls++
+		writeMessage(w, toolCallMessage(fmt.Sprint("call", calls), "ping"), FinishReasonToolCalls)
+	})
+
+	ping := NewCallableFunction("ping", "Pings", func(*pingRequest) *pingResponse {
+		return &pingResponse{Pong: true}
+	})
+	chat := NewChatClientWithClient[string](c, []CallableFunction{ping})
+	chat.SetModel("m")
+	chat.AddMessage("user", "Ping")
+
+	_, err := chat.GetResponse(nil, WithLimits(Limits{MaxRounds: 1}))
+	var limitErr *LimitError
+	if !errors.As(err, &limitErr) || limitErr.Reason != StopReasonMaxRounds {
+		t.Fatalf("got error %v, want LimitError", err)
+	}
+	if err := checkToolCallsAnswered(chat.Messages()); err != nil {
+		t.Fatal(err)
+	}
+
+	// The conversation can go on
+	chat.AddMessage("user", "Stop")
+	answer, err := chat.GetResponse(nil)
+	if err != nil || answer != "Stopped" {
+		t.Errorf("got %q, %v", answer, err)
+	}
+}
+
+func TestRefusalErrorHistory(t *testing.T) {
+	c := newTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+		msg := toolCallMessage("call1", "ping")
+		msg.Refusal = "No"
+		writeMessage(w, msg, FinishReasonStop)
+	})
+
+	chat := NewChatClientWithClient[string](c, nil)
+	chat.SetModel("m")
+	chat.AddMessage("user", "Ping")
+
+	var refusalErr *RefusalError
+	if _, err := chat.GetResponse(nil); !errors.As(err, &refusalErr) {
+		t.Fatalf("got error %v, want RefusalError", err)
+	}
+	if err := checkToolCallsAnswered(chat.Messages()); err != nil {
+		t.Error(err)
+	}
+}
@@ -5,6 +5,7 @@ import (
 	"encoding/json"
 	"os"
 	"strings"
+	"sync"
 )
 
 type Logger interface {
@@ -47,8 +48,14 @@ func logMessage(msgs ...string) error {
 
 var DefaultLogger Logger = &defaultLogger{}
 
-type defaultLogger struct{}
+type defaultLogger struct {
+	// Messages of concurrent requests must not be interleaved
+	mu sync.Mutex
+}
 
 func (l *defaultLogger) Log(args ...string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
 	logMessage(args...)
 }
@@ -0,0 +1,522 @@
+package mcp
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/xe0r/llm-stuff/llm"
+)
+
+const (
+	DefaultTimeout     = 60 * time.Second
+	DefaultMaxRestarts = 3
+	// RestartWindow is the time in which restarts are counted, so that a
+	// long-lived client survives occasional crashes of the server
+	RestartWindow = 10 * time.Minute
+)
+
+var errClientClosed = errors.New("mcp client closed")
+
+// Client talks to a single MCP server. The connection is established lazily
+// and, if the server process dies, restarted on the next call up to
+// SetMaxRestarts times within RestartWindow.
+type Client struct {
+	// stdio transport
+	command string
+	args    []string
+	env     []string
+	stderr  io.Writer
+
+	// streams of a running server
+	in  io.Reader
+	out io.WriteCloser
+
+	// streamable HTTP transport
+	url        string
+	headers    map[string]string
+	httpClient *http.Client
+
+	info        Implementation
+	timeout     time.Duration
+	maxRestarts int
+	toolPrefix  string
+	logger      llm.Logger
+
+	// OnNotification is called for server notifications the client doesn't handle itself.
+	OnNotification func(method string, params json.RawMessage)
+	// OnToolsChanged is called after the server's tool list changed.
+	OnToolsChanged func(tools []Tool)
+
+	nextID atomic.Int64
+	connMu sync.Mutex
+
+	mu       sync.Mutex
+	t        transport
+	started  bool
+	restarts int
+	// Time of the first restart counted in restarts
+	restarted time.Time
+	closed    bool
+	server   *InitializeResult
+	tools    []Tool
+	pending  map[string]chan *Message
+}
+
+// NewStdioClient creates a client for a local server launched as a subprocess.
+func NewStdioClient(command string, args ...string) *Client {
+	c := newClient()
+	c.command = command
+	c.args = args
+	c.stderr = os.Stderr
+	return c
+}
+
+// NewHTTPClient creates a client for a server that speaks streamable HTTP at url.
+func NewHTTPClient(url string) *Client {
+	c := newClient()
+	c.url = url
+	c.httpClient = &http.Client{}
+	return c
+}
+
+// NewPipeClient creates a client for a server that is already running, e.g.
+// one served in the same process with ServeStdio of the mcpserver package.
+// Messages of the server are read from in and requests are written to out,
+// which is closed by Close. The streams can't be reopened, so the connection
+// is not restarted.
+func NewPipeClient(in io.Reader, out io.WriteCloser) *Client {
+	c := newClient()
+	c.in = in
+	c.out = out
+	c.maxRestarts = 0
+	return c
+}
+
+func newClient() *Client {
+	return &Client{
+		info: Implementation{
+			Name:    "llm-stuff",
+			Version: "0.1.0",
+		},
+		timeout:     DefaultTimeout,
+		maxRestarts: DefaultMaxRestarts,
+		pending:     make(map[string]chan *Message),
+	}
+}
+
+// SetEnv sets extra environment variables for the server process, as "KEY=value".
+func (c *Client) SetEnv(env ...string) {
+	c.env = env
+}
+
+// SetStderr sets where the stderr of the server process goes, os.Stderr by default.
+func (c *Client) SetStderr(w io.Writer) {
+	c.stderr = w
+}
+
+func (c *Client) SetHeader(key, value string) {
+	if c.headers == nil {
+		c.headers = make(map[string]string)
+	}
+	c.headers[key] = value
+}
+
+// SetTimeout sets the timeout of a single request, 0 means no timeout.
+func (c *Client) SetTimeout(timeout time.Duration) {
+	c.timeout = timeout
+}
+
+func (c *Client) SetMaxRestarts(maxRestarts int) {
+	c.maxRestarts = maxRestarts
+}
+
+// SetToolPrefix sets a prefix added to names of the functions returned by
+// Functions, to avoid collisions between servers.
+func (c *Client) SetToolPrefix(prefix string) {
+	c.toolPrefix = prefix
+}
+
+func (c *Client) SetLogger(logger llm.Logger) {
+	c.logger = logger
+}
+
+func (c *Client) newTransport() transport {
+	if c.url != "" {
+		return &httpTransport{
+			url:     c.url,
+			headers: c.headers,
+			client:  c.httpClient,
+		}
+	}
+
+	if c.out != nil {
+		return &pipeTransport{
+			r: c.in,
+			w: c.out,
+		}
+	}
+
+	return &stdioTransport{
+		command: c.command,
+		args:    c.args,
+		env:     c.env,
+		stderr:  c.stderr,
+	}
+}
+
+// Connect starts the server if needed, performs the initialization and
+// fetches the list of tools.
+func (c *Client) Connect(ctx context.Context) error {
+	_, err := c.conn(ctx)
+	return err
+}
+
+// ServerInfo returns the result of initialization, nil if not connected yet.
+func (c *Client) ServerInfo() *InitializeResult {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.server
+}
+
+func (c *Client) conn(ctx context.Context) (transport, error) {
+	c.connMu.Lock()
+	defer c.connMu.Unlock()
+
+	c.mu.Lock()
+	t, started, closed := c.t, c.started, c.closed
+	if closed {
+		c.mu.Unlock()
+		return nil, errClientClosed
+	}
+	if t != nil {
+		c.mu.Unlock()
+		return t, nil
+	}
+	if started {
+		if time.Since(c.restarted) > RestartWindow {
+			c.restarts = 0
+		}
+		if c.restarts >= c.maxRestarts {
+			c.mu.Unlock()
+			return nil, fmt.Errorf("mcp server restarted too many times")
+		}
+		if c.restarts == 0 {
+			c.restarted = time.Now()
+		}
+		c.restarts++
+	}
+	c.started = true
+	c.mu.Unlock()
+
+	return c.connect(ctx)
+}
+
+func (c *Client) connect(ctx context.Context) (transport, error) {
+	t := c.newTransport()
+	if err := t.start(c.handleMessage, func(err error) { c.transportClosed(t, err) }); err != nil {
+		return nil, err
+	}
+
+	c.mu.Lock()
+	c.t = t
+	c.mu.Unlock()
+
+	fail := func(err error) (transport, error) {
+		c.mu.Lock()
+		if c.t == t {
+			c.t = nil
+		}
+		c.mu.Unlock()
+		_ = t.close()
+		return nil, err
+	}
+
+	var res InitializeResult
+	err := c.roundTrip(ctx, t, MethodInitialize, InitializeParams{
+		ProtocolVersion: ProtocolVersion,
+		ClientInfo:      c.info,
+	}, &res)
+	if err != nil {
+		return fail(fmt.Errorf("initialize: %w", err))
+	}
+
+	if err := c.notify(ctx, t, MethodInitialized, nil); err != nil {
+		return fail(err)
+	}
+
+	tools, err := c.listTools(ctx, t)
+	if err != nil {
+		return fail(err)
+	}
+
+	c.mu.Lock()
+	c.server = &res
+	c.tools = tools
+	c.mu.Unlock()
+
+	return t, nil
+}
+
+func (c *Client) transportClosed(t transport, err error) {
+	if c.logger != nil {
+		c.logger.Log("MCP connection closed: ", err.Error())
+	}
+
+	c.mu.Lock()
+	if c.t == t {
+		c.t = nil
+	}
+	pending := c.pending
+	c.pending = make(map[string]chan *Message)
+	c.mu.Unlock()
+
+	for _, ch := range pending {
+		ch <- &Message{
+			Error: &RPCError{Code: CodeInternalError, Message: err.Error()},
+		}
+	}
+}
+
+func (c *Client) handleMessage(msg *Message) {
+	switch {
+	case msg.IsResponse():
+		c.mu.Lock()
+		ch, ok := c.pending[string(msg.ID)]
+		delete(c.pending, string(msg.ID))
+		c.mu.Unlock()
+
+		if ok {
+			ch <- msg
+		}
+	case msg.IsRequest():
+		go c.handleRequest(msg)
+	case msg.IsNotification():
+		c.handleNotification(msg)
+	}
+}
+
+// handleRequest answers requests from the server. Only ping is supported,
+// the client doesn't declare any other capabilities.
+func (c *Client) handleRequest(msg *Message) {
+	resp := &Message{
+		JSONRPC: jsonRPCVersion,
+		ID:      msg.ID,
+	}
+	if msg.Method == MethodPing {
+		resp.Result = json.RawMessage("{}")
+	} else {
+		resp.Error = &RPCError{Code: CodeMethodNotFound, Message: "method not found: " + msg.Method}
+	}
+
+	c.mu.Lock()
+	t := c.t
+	c.mu.Unlock()
+
+	if t != nil {
+		_ = t.send(context.Background(), resp)
+	}
+}
+
+func (c *Client) handleNotification(msg *Message) {
+	switch msg.Method {
+	case MethodToolsListChanged:
+		go func() {
+			if _, err := c.ListTools(context.Background()); err != nil && c.logger != nil {
+				c.logger.Log("MCP refreshing tools: ", err.Error())
+			}
+		}()
+	case MethodMessage:
+		if c.logger != nil {
+			var params LogMessageParams
+			if err := json.Unmarshal(msg.Params, &params); err == nil {
+				c.logger.Log("MCP "+params.Level+": ", string(params.Data))
+			}
+		}
+	default:
+		if c.OnNotification != nil {
+			c.OnNotification(msg.Method, msg.Params)
+		}
+	}
+}
+
+func (c *Client) roundTrip(ctx context.Context, t transport, method string, params any, result any) error {
+	id := json.RawMessage(strconv.FormatInt(c.nextID.Add(1), 10))
+
+	msg := &Message{
+		JSONRPC: jsonRPCVersion,
+		ID:      id,
+		Method:  method,
+	}
+	if params != nil {
+		paramsJSON, err := json.Marshal(params)
+		if err != nil {
+			return err
+		}
+		msg.Params = paramsJSON
+	}
+
+	ch := make(chan *Message, 1)
+	c.mu.Lock()
+	c.pending[string(id)] = ch
+	c.mu.Unlock()
+
+	defer func() {
+		c.mu.Lock()
+		delete(c.pending, string(id))
+		c.mu.Unlock()
+	}()
+
+	if c.timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, c.timeout)
+		defer cancel()
+	}
+
+	if c.logger != nil {
+		c.logger.Log("MCP request: ", method)
+	}
+
+	if err := t.send(ctx, msg); err != nil {
+		return err
+	}
+
+	select {
+	case resp := <-ch:
+		if resp.Error != nil {
+			return resp.Error
+		}
+		if result != nil {
+			return json.Unmarshal(resp.Result, result)
+		}
+		return nil
+	case <-ctx.Done():
+		_ = c.notify(context.Background(), t, MethodCancelled, map[string]any{
+			"requestId": id,
+			"reason":    ctx.Err().Error(),
+		})
+		return ctx.Err()
+	}
+}
+
+func (c *Client) notify(ctx context.Context, t transport, method string, params any) error {
+	msg := &Message{
+		JSONRPC: jsonRPCVersion,
+		Method:  method,
+	}
+	if params != nil {
+		paramsJSON, err := json.Marshal(params)
+		if err != nil {
+			return err
+		}
+		msg.Params = paramsJSON
+	}
+	return t.send(ctx, msg)
+}
+
+// Call sends a raw request to the server and decodes the result into result.
+func (c *Client) Call(ctx context.Context, method string, params any, result any) error {
+	return c.call(ctx, method, params, result)
+}
+
+func (c *Client) call(ctx context.Context, method string, params any, result any) error {
+	t, err := c.conn(ctx)
+	if err != nil {
+		return err
+	}
+	return c.roundTrip(ctx, t, method, params, result)
+}
+
+func (c *Client) listTools(ctx context.Context, t transport) ([]Tool, error) {
+	var tools []Tool
+	cursor := ""
+	for {
+		var res ListToolsResult
+		if err := c.roundTrip(ctx, t, MethodToolsList, ListToolsParams{Cursor: cursor}, &res); err != nil {
+			return nil, fmt.Errorf("tools/list: %w", err)
+		}
+
+		tools = append(tools, res.Tools...)
+		if res.NextCursor == "" {
+			return tools, nil
+		}
+		cursor = res.NextCursor
+	}
+}
+
+// ListTools fetches the current list of tools from the server.
+func (c *Client) ListTools(ctx context.Context) ([]Tool, error) {
+	t, err := c.conn(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	tools, err := c.listTools(ctx, t)
+	if err != nil {
+		return nil, err
+	}
+
+	c.mu.Lock()
+	c.tools = tools
+	c.mu.Unlock()
+
+	if c.OnToolsChanged != nil {
+		c.OnToolsChanged(tools)
+	}
+	return tools, nil
+}
+
+func (c *Client) CallTool(ctx context.Context, name string, args json.RawMessage) (*CallToolResult, error) {
+	var res CallToolResult
+	if err := c.call(ctx, MethodToolsCall, CallToolParams{Name: name, Arguments: args}, &res); err != nil {
+		return nil, err
+	}
+	return &res, nil
+}
+
+func (c *Client) Ping(ctx context.Context) error {
+	return c.call(ctx, MethodPing, nil, nil)
+}
+
+// Functions connects to the server and returns its tools as functions for
+// llm.NewChatClient.
+func (c *Client) Functions(ctx context.Context) ([]llm.CallableFunction, error) {
+	if err := c.Connect(ctx); err != nil {
+		return nil, err
+	}
+
+	c.mu.Lock()
+	tools := c.tools
+	c.mu.Unlock()
+
+	funcs := make([]llm.CallableFunction, 0, len(tools))
+	for _, tool := range tools {
+		funcs = append(funcs, &remoteTool{
+			client: c,
+			name:   c.toolPrefix + tool.Name,
+			tool:   tool,
+		})
+	}
+	return funcs, nil
+}
+
+func (c *Client) Close() error {
+	c.mu.Lock()
+	c.closed = true
+	t := c.t
+	c.t = nil
+	c.mu.Unlock()
+
+	if t == nil {
+		return nil
+	}
+	return t.close()
+}
@@ -0,0 +1,275 @@
+package mcp
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"os"
+	"slices"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+)
+
+// The test binary serves the stub over stdio when it's started by
+// NewStdioClient in the tests.
+const stubEnv = "MCP_STUB_SERVER"
+
+func TestMain(m *testing.M) {
+	if os.Getenv(stubEnv) == "1" {
+		newStubServer(os.Stdout).serve(os.Stdin)
+		os.Exit(0)
+	}
+	os.Exit(m.Run())
+}
+
+// stubServer is a minimal MCP server. It lists its tools two per page and
+// has tools that add a tool, exit the process and return its PID.
+type stubServer struct {
+	out     io.Writer
+	writeMu sync.Mutex
+
+	mu      sync.Mutex
+	tools   []Tool
+	methods []string
+	init    InitializeParams
+	cursors []string
+}
+
+func newStubServer(out io.Writer) *stubServer {
+	s := &stubServer{out: out}
+	for _, name := range []string{"add", "exit", "pid", "d", "e"} {
+		s.tools = append(s.tools, Tool{Name: name, InputSchema: json.RawMessage(`{"type":"object"}`)})
+	}
+	return s
+}
+
+func (s *stubServer) serve(in io.Reader) {
+	_ = readMessages(in, s.handle)
+}
+
+func (s *stubServer) send(msg *Message) {
+	msg.JSONRPC = jsonRPCVersion
+	_ = writeMessage(&s.writeMu, s.out, msg)
+}
+
+func (s *stubServer) handle(msg *Message) {
+	s.mu.Lock()
+	s.methods = append(s.methods, msg.Method)
+	s.mu.Unlock()
+
+	if !msg.IsRequest() {
+		return
+	}
+
+	var result any
+	switch msg.Method {
+	case MethodInitialize:
+		s.mu.Lock()
+		_ = json.Unmarshal(msg.Params, &s.init)
+		s.mu.Unlock()
+		result = InitializeResult{
+			ProtocolVersion: ProtocolVersion,
+			Capabilities:    ServerCapabilities{Tools: &ToolsCapability{ListChanged: true}},
+			ServerInfo:      Implementation{Name: "stub", Version: "1.0.0"},
+		}
+	case MethodPing:
+		result = struct{}{}
+	case MethodToolsList:
+		var params ListToolsParams
+		_ = json.Unmarshal(msg.Params, &params)
+		start, _ := strconv.Atoi(params.Cursor)
+
+		s.mu.Lock()
+		s.cursors = append(s.cursors, params.Cursor)
+		res := ListToolsResult{Tools: s.tools[start:min(start+2, len(s.tools))]}
+		if start+2 < len(s.tools) {
+			res.NextCursor = strconv.Itoa(start + 2)
+		}
+		s.mu.Unlock()
+		result = res
+	case MethodToolsCall:
+		var params CallToolParams
+		_ = json.Unmarshal(msg.Params, &params)
+		text := "ok"
+		switch params.Name {
+		case "add":
+			s.mu.Lock()
+			s.tools = append(s.tools, Tool{Name: "added", InputSchema: json.RawMessage(`{"type":"object"}`)})
+			s.mu.Unlock()
+			defer s.send(&Message{Method: MethodToolsListChanged})
+		case "exit":
+			os.Exit(0)
+		case "pid":
+			text = strconv.Itoa(os.Getpid())
+		}
+		result = CallToolResult{Content: []Content{{Type: "text", Text: text}}}
+	default:
+		s.send(&Message{ID: msg.ID, Error: &RPCError{Code: CodeMethodNotFound, Message: "method not found"}})
+		return
+	}
+
+	data, _ := json.Marshal(result)
+	s.send(&Message{ID: msg.ID, Result: data})
+}
+
+// newPipeStub serves the stub in the test process.
+func newPipeStub(t *testing.T) (*Client, *stubServer) {
+	t.Helper()
+	clientIn, serverOut := io.Pipe()
+	serverIn, clientOut := io.Pipe()
+
+	stub := newStubServer(serverOut)
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		stub.serve(serverIn)
+		serverOut.Close()
+	}()
+
+	client := NewPipeClient(clientIn, clientOut)
+	client.SetTimeout(5 * time.Second)
+	t.Cleanup(func() {
+		client.Close()
+		<-done
+	})
+	return client, stub
+}
+
+func newStdioStub(t *testing.T) *Client {
+	t.Helper()
+	client := NewStdioClient(os.Args[0])
+	client.SetEnv(stubEnv + "=1")
+	client.SetTimeout(5 * time.Second)
+	t.Cleanup(func() { client.Close() })
+	return client
+}
+
+func toolNames(tools []Tool) []string {
+	names := make([]string, 0, len(tools))
+	for _, tool := range tools {
+		names = append(names, tool.Name)
+	}
+	return names
+}
+
+func TestInitialize(t *testing.T) {
+	client, stub := newPipeStub(t)
+
+	if err := client.Connect(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	info := client.ServerInfo()
+	if info.ServerInfo.Name != "stub" || info.ProtocolVersion != ProtocolVersion {
+		t.Errorf("got server info %+v", info)
+	}
+
+	stub.mu.Lock()
+	defer stub.mu.Unlock()
+	if stub.init.ProtocolVersion != ProtocolVersion || stub.init.ClientInfo.Name == "" {
+		t.Errorf("got initialize params %+v", stub.init)
+	}
+	want := []string{MethodInitialize, MethodInitialized, MethodToolsList}
+	if !slices.Equal(stub.methods[:min(3, len(stub.methods))], want) {
+		t.Errorf("got methods %v, want %v first", stub.methods, want)
+	}
+}
+
+func TestListToolsPagination(t *testing.T) {
+	client, stub := newPipeStub(t)
+	client.SetToolPrefix("stub_")
+
+	funcs, err := client.Functions(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	var names []string
+	for _, fn := range funcs {
+		names = append(names, fn.GetName())
+	}
+	if want := []string{"stub_add", "stub_exit", "stub_pid", "stub_d", "stub_e"}; !slices.Equal(names, want) {
+		t.Errorf("got functions %v, want %v", names, want)
+	}
+
+	stub.mu.Lock()
+	defer stub.mu.Unlock()
+	if want := []string{"", "2", "4"}; !slices.Equal(stub.cursors, want) {
+		t.Errorf("got cursors %q, want %q", stub.cursors, want)
+	}
+}
+
+func TestToolsListChanged(t *testing.T) {
+	client, _ := newPipeStub(t)
+
+	changed := make(chan []Tool, 1)
+	client.OnToolsChanged = func(tools []Tool) {
+		changed <- tools
+	}
+
+	res, err := client.CallTool(context.Background(), "add", nil)
+	if err != nil || res.IsError {
+		t.Fatalf("got %+v, %v", res, err)
+	}
+
+	select {
+	case tools := <-changed:
+		if names := toolNames(tools); !slices.Contains(names, "added") {
+			t.Errorf("got tools %v after the change", names)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("tools were not refreshed")
+	}
+
+	funcs, err := client.Functions(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(funcs) != 6 {
+		t.Errorf("got %d functions, want 6", len(funcs))
+	}
+}
+
+func TestStdioRestart(t *testing.T) {
+	client := newStdioStub(t)
+	client.SetMaxRestarts(1)
+	ctx := context.Background()
+
+	pid := func() string {
+		t.Helper()
+		res, err := client.CallTool(ctx, "pid", nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return res.Content[0].Text
+	}
+
+	first := pid()
+	if _, err := client.CallTool(ctx, "exit", nil); err == nil {
+		t.Fatal("call of a server that exited succeeded")
+	}
+
+	// The server is started again on the next call
+	if second := pid(); second == first {
+		t.Errorf("server was not restarted, PID %s", second)
+	}
+	if info := client.ServerInfo(); info == nil || info.ServerInfo.Name != "stub" {
+		t.Errorf("got server info %+v after the restart", info)
+	}
+
+	if _, err := client.CallTool(ctx, "exit", nil); err == nil {
+		t.Fatal("call of a server that exited succeeded")
+	}
+	if err := client.Ping(ctx); err == nil {
+		t.Error("server was restarted more than once")
+	}
+
+	// Restarts are counted again once the window is over
+	client.mu.Lock()
+	client.restarted = time.Now().Add(-RestartWindow - time.Second)
+	client.mu.Unlock()
+	if err := client.Ping(ctx); err != nil {
+		t.Errorf("server was not restarted after the window: %v", err)
+	}
+}
@@ -0,0 +1,225 @@
+package mcp
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"sync"
+
+	"github.com/xe0r/llm-stuff/llm"
+)
+
+const (
+	headerSessionID       = "Mcp-Session-Id"
+	headerProtocolVersion = "MCP-Protocol-Version"
+)
+
+var errSessionExpired = errors.New("mcp session expired")
+
+// httpTransport implements the streamable HTTP transport: every message is
+// POSTed to the endpoint, the response is either JSON or an SSE stream of
+// messages. Server-initiated messages come over an optional GET stream.
+type httpTransport struct {
+	url     string
+	headers map[string]string
+	client  *http.Client
+
+	handle func(*Message)
+	closed func(error)
+
+	mu        sync.Mutex
+	sessionID string
+	cancel    context.CancelFunc
+}
+
+func (t *httpTransport) start(handle func(*Message), closed func(error)) error {
+	t.handle = handle
+	t.closed = closed
+	return nil
+}
+
+func (t *httpTransport) newRequest(ctx context.Context, method string, body []byte) (*http.Request, error) {
+	var bodyReader io.Reader
+	if body != nil {
+		bodyReader = bytes.NewReader(body)
+	}
+
+	httpReq, err := http.NewRequestWithContext(ctx, method, t.url, bodyReader)
+	if err != nil {
+		return nil, err
+	}
+
+	for k, v := range t.headers {
+		httpReq.Header.Set(k, v)
+	}
+	httpReq.Header.Set(headerProtocolVersion, ProtocolVersion)
+
+	t.mu.Lock()
+	if t.sessionID != "" {
+		httpReq.Header.Set(headerSessionID, t.sessionID)
+	}
+	t.mu.Unlock()
+
+	return httpReq, nil
+}
+
+func (t *httpTransport) send(ctx context.Context, msg *Message) error {
+	body, err := json.Marshal(msg)
+	if err != nil {
+		return err
+	}
+
+	httpReq, err := t.newRequest(ctx, http.MethodPost, body)
+	if err != nil {
+		return err
+	}
+	httpReq.Header.Set("Content-Type", "application/json")
+	httpReq.Header.Set("Accept", "application/json, text/event-stream")
+
+	httpResp, err := t.client.Do(httpReq)
+	if err != nil {
+		return err
+	}
+	defer httpResp.Body.Close()
+
+	if httpResp.StatusCode == http.StatusNotFound && httpReq.Header.Get(headerSessionID) != "" {
+		t.closed(errSessionExpired)
+		return errSessionExpired
+	}
+
+	if httpResp.StatusCode >= 300 {
+		content, _ := io.ReadAll(io.LimitReader(httpResp.Body, 4096))
+		return fmt.Errorf("mcp server returned %s: %s", httpResp.Status, bytes.TrimSpace(content))
+	}
+
+	if sessionID := httpResp.Header.Get(headerSessionID); sessionID != "" {
+		t.mu.Lock()
+		first := t.sessionID == ""
+		t.sessionID = sessionID
+		t.mu.Unlock()
+
+		if first {
+			t.listen()
+		}
+	}
+
+	if httpResp.StatusCode == http.StatusAccepted {
+		return nil
+	}
+
+	return t.readMessages(httpResp)
+}
+
+func (t *httpTransport) readMessages(httpResp *http.Response) error {
+	contentType, _, _ := mime.ParseMediaType(httpResp.Header.Get("Content-Type"))
+
+	switch contentType {
+	case "application/json":
+		content, err := io.ReadAll(httpResp.Body)
+		if err != nil {
+			return err
+		}
+		if len(bytes.TrimSpace(content)) == 0 {
+			return nil
+		}
+
+		msgs, err := ParseMessages(content)
+		if err != nil {
+			return err
+		}
+		for _, msg := range msgs {
+			t.handle(msg)
+		}
+		return nil
+	case "text/event-stream":
+		reader := llm.NewSSEReader(httpResp.Body)
+		for {
+			event, err := reader.ReadEvent()
+			if err == io.EOF {
+				return nil
+			}
+			if err != nil {
+				return err
+			}
+
+			if (event.Event != "" && event.Event != "message") || event.Data == "" {
+				continue
+			}
+
+			msgs, err := ParseMessages([]byte(event.Data))
+			if err != nil {
+				continue
+			}
+			for _, msg := range msgs {
+				t.handle(msg)
+			}
+		}
+	}
+	return fmt.Errorf("unexpected content type %s", contentType)
+}
+
+// listen opens the GET stream for server-initiated messages. Servers that
+// don't support it respond with 405, which is fine.
+func (t *httpTransport) listen() {
+	ctx, cancel := context.WithCancel(context.Background())
+
+	t.mu.Lock()
+	t.cancel = cancel
+	t.mu.Unlock()
+
+	go func() {
+		httpReq, err := t.newRequest(ctx, http.MethodGet, nil)
+		if err != nil {
+			return
+		}
+		httpReq.Header.Set("Accept", "text/event-stream")
+
+		httpResp, err := t.client.Do(httpReq)
+		if err != nil {
+			return
+		}
+		defer httpResp.Body.Close()
+
+		if httpResp.StatusCode != http.StatusOK {
+			return
+		}
+		_ = t.readMessages(httpResp)
+	}()
+}
+
+func (t *httpTransport) close() error {
+	t.mu.Lock()
+	sessionID := t.sessionID
+	cancel := t.cancel
+	t.sessionID = ""
+	t.cancel = nil
+	t.mu.Unlock()
+
+	if cancel != nil {
+		cancel()
+	}
+
+	if sessionID == "" {
+		return nil
+	}
+
+	httpReq, err := http.NewRequest(http.MethodDelete, t.url, nil)
+	if err != nil {
+		return err
+	}
+	for k, v := range t.headers {
+		httpReq.Header.Set(k, v)
+	}
+	httpReq.Header.Set(headerSessionID, sessionID)
+
+	httpResp, err := t.client.Do(httpReq)
+	if err != nil {
+		return err
+	}
+	return httpResp.Body.Close()
+}
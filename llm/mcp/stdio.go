@@ -0,0 +1,180 @@
+package mcp
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"sync"
+	"time"
+)
+
+// transport carries messages between the client and a server. Incoming
+// messages are passed to handle, closed is called once when the connection
+// is lost.
+type transport interface {
+	start(handle func(*Message), closed func(error)) error
+	send(ctx context.Context, msg *Message) error
+	close() error
+}
+
+const (
+	maxStdioMessageSize = 16 << 20
+	stdioExitTimeout    = 2 * time.Second
+)
+
+// stdioTransport runs the server as a subprocess and exchanges
+// newline-delimited JSON messages over its stdin and stdout.
+type stdioTransport struct {
+	command string
+	args    []string
+	env     []string
+	stderr  io.Writer
+
+	cmd     *exec.Cmd
+	stdin   io.WriteCloser
+	writeMu sync.Mutex
+	exited  chan struct{}
+}
+
+func (t *stdioTransport) start(handle func(*Message), closed func(error)) error {
+	cmd := exec.Command(t.command, t.args...)
+	cmd.Env = append(os.Environ(), t.env...)
+	cmd.Stderr = t.stderr
+
+	stdin, err := cmd.StdinPipe()
+	if err != nil {
+		return err
+	}
+
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return err
+	}
+
+	if err := cmd.Start(); err != nil {
+		return fmt.Errorf("starting %s: %w", t.command, err)
+	}
+
+	t.cmd = cmd
+	t.stdin = stdin
+	t.exited = make(chan struct{})
+
+	go func() {
+		readErr := readMessages(stdout, handle)
+		if readErr != nil {
+			_ = cmd.Process.Kill()
+		}
+		waitErr := cmd.Wait()
+		close(t.exited)
+		if readErr != nil {
+			closed(readErr)
+		} else if waitErr != nil {
+			closed(fmt.Errorf("server exited: %w", waitErr))
+		} else {
+			closed(fmt.Errorf("server exited"))
+		}
+	}()
+
+	return nil
+}
+
+func (t *stdioTransport) send(ctx context.Context, msg *Message) error {
+	return writeMessage(&t.writeMu, t.stdin, msg)
+}
+
+func (t *stdioTransport) close() error {
+	if t.cmd == nil {
+		return nil
+	}
+
+	// Closing stdin is the polite way to ask the server to exit
+	_ = t.stdin.Close()
+
+	select {
+	case <-t.exited:
+	case <-time.After(stdioExitTimeout):
+		_ = t.cmd.Process.Kill()
+	}
+	return nil
+}
+
+// pipeTransport exchanges the same messages as stdioTransport over streams
+// of a server that is already running, e.g. in the same process.
+type pipeTransport struct {
+	r io.Reader
+	w io.WriteCloser
+
+	writeMu sync.Mutex
+	done    chan struct{}
+}
+
+func (t *pipeTransport) start(handle func(*Message), closed func(error)) error {
+	t.done = make(chan struct{})
+
+	go func() {
+		err := readMessages(t.r, handle)
+		close(t.done)
+		if err == nil {
+			err = fmt.Errorf("connection closed")
+		}
+		closed(err)
+	}()
+	return nil
+}
+
+func (t *pipeTransport) send(ctx context.Context, msg *Message) error {
+	return writeMessage(&t.writeMu, t.w, msg)
+}
+
+func (t *pipeTransport) close() error {
+	if t.done == nil {
+		return nil
+	}
+
+	// The server is expected to close its end when its input is closed
+	err := t.w.Close()
+	select {
+	case <-t.done:
+	case <-time.After(stdioExitTimeout):
+		if closer, ok := t.r.(io.Closer); ok {
+			_ = closer.Close()
+		}
+	}
+	return err
+}
+
+// readMessages reads newline-delimited messages until r ends.
+func readMessages(r io.Reader, handle func(*Message)) error {
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 0, 64*1024), maxStdioMessageSize)
+
+	for scanner.Scan() {
+		msgs, err := ParseMessages(scanner.Bytes())
+		if err != nil {
+			// Servers sometimes print garbage to stdout, skip it
+			continue
+		}
+		for _, msg := range msgs {
+			handle(msg)
+		}
+	}
+	return scanner.Err()
+}
+
+func writeMessage(mu *sync.Mutex, w io.Writer, msg *Message) error {
+	data, err := json.Marshal(msg)
+	if err != nil {
+		return err
+	}
+	data = append(data, '\n')
+
+	mu.Lock()
+	defer mu.Unlock()
+
+	_, err = w.Write(data)
+	return err
+}
@@ -0,0 +1,87 @@
+package mcp
+
+import (
+	"context"
+	"encoding/json"
+	"strings"
+	"sync"
+
+	"github.com/xe0r/llm-stuff/llm"
+)
+
+// remoteTool is a tool of an MCP server adapted to llm.CallableFunction.
+type remoteTool struct {
+	client *Client
+	name   string
+	tool   Tool
+
+	paramsOnce sync.Once
+	params     llm.ParamDef
+}
+
+func (t *remoteTool) GetName() string {
+	return t.name
+}
+
+func (t *remoteTool) GetDescription() string {
+	if t.tool.Description == "" {
+		return t.tool.Title
+	}
+	return t.tool.Description
+}
+
+// GetParameters returns the input schema of the tool. Nested schemas are kept
+// as they are, so keywords ParamDef doesn't know about are preserved.
+func (t *remoteTool) GetParameters() llm.ParamDef {
+	t.paramsOnce.Do(func() {
+		if err := json.Unmarshal(t.tool.InputSchema, &t.params); err != nil || t.params.Type == "" {
+			t.params = llm.ParamDef{Type: "object"}
+		}
+	})
+	return t.params
+}
+
+func (t *remoteTool) Call(args string) string {
+	if strings.TrimSpace(args) == "" {
+		args = "{}"
+	}
+
+	res, err := t.client.CallTool(context.Background(), t.tool.Name, json.RawMessage(args))
+	if err != nil {
+		return errorJSON(err.Error())
+	}
+
+	text := ResultText(res)
+	if res.IsError {
+		return errorJSON(text)
+	}
+	return text
+}
+
+// ResultText converts a tool result to the text given to the model:
+// structured content if present, otherwise the content blocks.
+func ResultText(res *CallToolResult) string {
+	if len(res.StructuredContent) > 0 && !res.IsError {
+		return string(res.StructuredContent)
+	}
+
+	parts := make([]string, 0, len(res.Content))
+	for _, content := range res.Content {
+		switch {
+		case content.Type == "text":
+			parts = append(parts, content.Text)
+		case content.Resource != nil && content.Resource.Text != "":
+			parts = append(parts, content.Resource.Text)
+		case content.Resource != nil:
+			parts = append(parts, "["+content.Type+" "+content.Resource.URI+"]")
+		default:
+			parts = append(parts, "["+content.Type+" "+content.MimeType+"]")
+		}
+	}
+	return strings.Join(parts, "\n")
+}
+
+func errorJSON(msg string) string {
+	resp, _ := json.Marshal(llm.ErrorResp{Error: msg})
+	return string(resp)
+}
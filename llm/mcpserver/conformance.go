@@ -0,0 +1,134 @@
+package mcpserver
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"slices"
+
+	"github.com/xe0r/llm-stuff/llm/mcp"
+)
+
+type CheckResult struct {
+	Name string
+	Err  error
+}
+
+type check struct {
+	name string
+	run  func(ctx context.Context, client *mcp.Client) error
+}
+
+var checks = []check{
+	{"initialize", checkInitialize},
+	{"ping", checkPing},
+	{"tools/list", checkListTools},
+	{"unknown method", checkUnknownMethod},
+	{"unknown tool", checkUnknownTool},
+	{"invalid params", checkInvalidParams},
+}
+
+// Check runs protocol conformance checks against the server the client is
+// configured for. It works with any MCP server, not only this package's.
+func Check(ctx context.Context, client *mcp.Client) []CheckResult {
+	results := make([]CheckResult, 0, len(checks))
+	for _, c := range checks {
+		results = append(results, CheckResult{
+			Name: c.name,
+			Err:  c.run(ctx, client),
+		})
+	}
+	return results
+}
+
+func checkInitialize(ctx context.Context, client *mcp.Client) error {
+	if err := client.Connect(ctx); err != nil {
+		return err
+	}
+
+	info := client.ServerInfo()
+	if info.ServerInfo.Name == "" {
+		return fmt.Errorf("serverInfo.name is empty")
+	}
+	if !slices.Contains(supportedVersions, info.ProtocolVersion) {
+		return fmt.Errorf("unsupported protocol version %q", info.ProtocolVersion)
+	}
+	if info.Capabilities.Tools == nil {
+		return fmt.Errorf("tools capability is not declared")
+	}
+	return nil
+}
+
+func checkPing(ctx context.Context, client *mcp.Client) error {
+	return client.Ping(ctx)
+}
+
+func checkListTools(ctx context.Context, client *mcp.Client) error {
+	tools, err := client.ListTools(ctx)
+	if err != nil {
+		return err
+	}
+
+	names := make(map[string]bool)
+	for _, tool := range tools {
+		if tool.Name == "" {
+			return fmt.Errorf("tool without name")
+		}
+		if names[tool.Name] {
+			return fmt.Errorf("duplicate tool %s", tool.Name)
+		}
+		names[tool.Name] = true
+
+		var schema struct {
+			Type string `json:"type"`
+		}
+		if err := json.Unmarshal(tool.InputSchema, &schema); err != nil {
+			return fmt.Errorf("tool %s: invalid inputSchema: %w", tool.Name, err)
+		}
+		if schema.Type != "object" {
+			return fmt.Errorf("tool %s: inputSchema type is %q, expected object", tool.Name, schema.Type)
+		}
+	}
+	return nil
+}
+
+func expectRPCError(err error, code int) error {
+	var rpcErr *mcp.RPCError
+	if !errors.As(err, &rpcErr) {
+		if err == nil {
+			return fmt.Errorf("expected error %d, got success", code)
+		}
+		return fmt.Errorf("expected error %d, got %w", code, err)
+	}
+	if rpcErr.Code != code {
+		return fmt.Errorf("expected error %d, got %d", code, rpcErr.Code)
+	}
+	return nil
+}
+
+func checkUnknownMethod(ctx context.Context, client *mcp.Client) error {
+	err := client.Call(ctx, "conformance/unknown", nil, nil)
+	return expectRPCError(err, mcp.CodeMethodNotFound)
+}
+
+func checkUnknownTool(ctx context.Context, client *mcp.Client) error {
+	res, err := client.CallTool(ctx, "conformance_unknown_tool", json.RawMessage("{}"))
+	if err != nil {
+		var rpcErr *mcp.RPCError
+		if errors.As(err, &rpcErr) {
+			return nil
+		}
+		return err
+	}
+
+	if !res.IsError {
+		return fmt.Errorf("call of unknown tool succeeded")
+	}
+	return nil
+}
+
+func checkInvalidParams(ctx context.Context, client *mcp.Client) error {
+	err := client.Call(ctx, mcp.MethodToolsCall, map[string]any{"name": 42}, nil)
+	return expectRPCError(err, mcp.CodeInvalidParams)
+}
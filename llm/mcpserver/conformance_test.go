@@ -0,0 +1,89 @@
+package mcpserver
+
+import (
+	"context"
+	"io"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/xe0r/llm-stuff/llm"
+	"github.com/xe0r/llm-stuff/llm/mcp"
+)
+
+type echoRequest struct {
+	Text string `json:"text"`
+}
+
+type echoResponse struct {
+	Text string `json:"text"`
+}
+
+func newTestServer() *Server {
+	return New("test", "1.0.0", []llm.CallableFunction{
+		llm.NewCallableFunction("echo", "Returns the text", func(req *echoRequest) *echoResponse {
+			return &echoResponse{Text: req.Text}
+		}),
+	})
+}
+
+func checkConformance(t *testing.T, client *mcp.Client) {
+	t.Helper()
+	client.SetTimeout(5 * time.Second)
+
+	results := Check(context.Background(), client)
+	if len(results) != len(checks) {
+		t.Errorf("got %d results, want %d", len(results), len(checks))
+	}
+	for _, res := range results {
+		if res.Err != nil {
+			t.Errorf("%s: %v", res.Name, res.Err)
+		}
+	}
+
+	res, err := client.CallTool(context.Background(), "echo", []byte(`{"text":"hi"}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.IsError || len(res.Content) != 1 || res.Content[0].Text != `{"text":"hi"}` {
+		t.Errorf("got %+v", res)
+	}
+}
+
+func TestConformanceHTTP(t *testing.T) {
+	srv := httptest.NewServer(newTestServer())
+	defer srv.Close()
+
+	client := mcp.NewHTTPClient(srv.URL)
+	defer client.Close()
+	checkConformance(t, client)
+}
+
+func TestConformanceStdio(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	clientIn, serverOut := io.Pipe()
+	serverIn, clientOut := io.Pipe()
+
+	done := make(chan error)
+	go func() {
+		err := newTestServer().ServeStdio(ctx, serverIn, serverOut)
+		serverOut.Close()
+		done <- err
+	}()
+
+	client := mcp.NewPipeClient(clientIn, clientOut)
+	checkConformance(t, client)
+
+	// Closing the client stops the server
+	client.Close()
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Errorf("ServeStdio: %v", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("server didn't stop")
+	}
+}
@@ -0,0 +1,234 @@
+// Package mcpserver exposes llm.CallableFunction tools to other agents over
+// the Model Context Protocol, on stdio or streamable HTTP.
+package mcpserver
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/xe0r/llm-stuff/llm"
+	"github.com/xe0r/llm-stuff/llm/mcp"
+)
+
+// DefaultSessionTimeout is used if SetSessionTimeout is not called.
+const DefaultSessionTimeout = time.Hour
+
+var supportedVersions = []string{mcp.ProtocolVersion, "2025-03-26", "2024-11-05"}
+
+type Server struct {
+	info         mcp.Implementation
+	instructions string
+	logger       llm.Logger
+
+	funcs    []llm.CallableFunction
+	funcsMap map[string]llm.CallableFunction
+
+	sessionsMu sync.Mutex
+	// Time of the last request of every HTTP session
+	sessions       map[string]time.Time
+	sessionTimeout time.Duration
+	origins        []string
+}
+
+func New(name, version string, funcs []llm.CallableFunction) *Server {
+	funcsMap := make(map[string]llm.CallableFunction, len(funcs))
+	for _, fn := range funcs {
+		funcsMap[fn.GetName()] = fn
+	}
+
+	return &Server{
+		info: mcp.Implementation{
+			Name:    name,
+			Version: version,
+		},
+		funcs:    funcs,
+		funcsMap: funcsMap,
+		sessions:       make(map[string]time.Time),
+		sessionTimeout: DefaultSessionTimeout,
+	}
+}
+
+// SetInstructions sets the usage hint the server sends to clients on initialization.
+func (s *Server) SetInstructions(instructions string) {
+	s.instructions = instructions
+}
+
+// SetSessionTimeout sets how long an HTTP session is kept without requests,
+// 0 means that sessions are kept until the client deletes them.
+func (s *Server) SetSessionTimeout(timeout time.Duration) {
+	s.sessionTimeout = timeout
+}
+
+func (s *Server) SetLogger(logger llm.Logger) {
+	s.logger = logger
+}
+
+// Handle processes a single message and returns the response, or nil for
+// notifications and responses.
+func (s *Server) Handle(ctx context.Context, msg *mcp.Message) *mcp.Message {
+	if !msg.IsRequest() {
+		if msg.Method == "" && len(msg.ID) == 0 {
+			return errorResponse(nil, mcp.CodeInvalidRequest, "invalid request")
+		}
+		return nil
+	}
+
+	if s.logger != nil {
+		s.logger.Log("MCP request: ", msg.Method, " ", string(msg.Params))
+	}
+
+	result, err := s.dispatch(ctx, msg)
+	if err != nil {
+		if rpcErr, ok := err.(*mcp.RPCError); ok {
+			return errorResponse(msg.ID, rpcErr.Code, rpcErr.Message)
+		}
+		return errorResponse(msg.ID, mcp.CodeInternalError, err.Error())
+	}
+
+	resultJSON, err := json.Marshal(result)
+	if err != nil {
+		return errorResponse(msg.ID, mcp.CodeInternalError, err.Error())
+	}
+
+	return &mcp.Message{
+		JSONRPC: "2.0",
+		ID:      msg.ID,
+		Result:  resultJSON,
+	}
+}
+
+func errorResponse(id json.RawMessage, code int, message string) *mcp.Message {
+	if len(id) == 0 {
+		id = json.RawMessage("null")
+	}
+
+	return &mcp.Message{
+		JSONRPC: "2.0",
+		ID:      id,
+		Error: &mcp.RPCError{
+			Code:    code,
+			Message: message,
+		},
+	}
+}
+
+func (s *Server) dispatch(ctx context.Context, msg *mcp.Message) (any, error) {
+	switch msg.Method {
+	case mcp.MethodInitialize:
+		var params mcp.InitializeParams
+		if err := unmarshalParams(msg.Params, &params); err != nil {
+			return nil, err
+		}
+		return s.initialize(&params), nil
+	case mcp.MethodPing:
+		return struct{}{}, nil
+	case mcp.MethodToolsList:
+		return s.listTools(), nil
+	case mcp.MethodToolsCall:
+		var params mcp.CallToolParams
+		if err := unmarshalParams(msg.Params, &params); err != nil {
+			return nil, err
+		}
+		return s.callTool(ctx, &params)
+	}
+
+	return nil, &mcp.RPCError{Code: mcp.CodeMethodNotFound, Message: "method not found: " + msg.Method}
+}
+
+func unmarshalParams(params json.RawMessage, v any) error {
+	if len(params) == 0 {
+		return nil
+	}
+	if err := json.Unmarshal(params, v); err != nil {
+		return &mcp.RPCError{Code: mcp.CodeInvalidParams, Message: err.Error()}
+	}
+	return nil
+}
+
+func (s *Server) initialize(params *mcp.InitializeParams) *mcp.InitializeResult {
+	version := mcp.ProtocolVersion
+	if slices.Contains(supportedVersions, params.ProtocolVersion) {
+		version = params.ProtocolVersion
+	}
+
+	return &mcp.InitializeResult{
+		ProtocolVersion: version,
+		Capabilities: mcp.ServerCapabilities{
+			Tools: &mcp.ToolsCapability{},
+		},
+		ServerInfo:   s.info,
+		Instructions: s.instructions,
+	}
+}
+
+func (s *Server) listTools() *mcp.ListToolsResult {
+	tools := make([]mcp.Tool, 0, len(s.funcs))
+	for _, fn := range s.funcs {
+		schema, _ := json.Marshal(fn.GetParameters())
+		tools = append(tools, mcp.Tool{
+			Name:        fn.GetName(),
+			Description: fn.GetDescription(),
+			InputSchema: schema,
+		})
+	}
+
+	return &mcp.ListToolsResult{
+		Tools: tools,
+	}
+}
+
+func (s *Server) callTool(ctx context.Context, params *mcp.CallToolParams) (res *mcp.CallToolResult, err error) {
+	fn, ok := s.funcsMap[params.Name]
+	if !ok {
+		return nil, &mcp.RPCError{Code: mcp.CodeInvalidParams, Message: "unknown tool: " + params.Name}
+	}
+
+	args := string(params.Arguments)
+	if args == "" || args == "null" {
+		args = "{}"
+	}
+
+	defer func() {
+		if r := recover(); r != nil {
+			res = errorResult(fmt.Sprintf("tool %s panicked: %v", params.Name, r))
+			err = nil
+		}
+	}()
+
+	return toolResult(fn.Call(args)), nil
+}
+
+// toolResult converts the output of CallableFunction.Call to a tool result.
+// JSON objects are also returned as structured content, and an object with
+// only an "error" field is reported as a failed call.
+func toolResult(output string) *mcp.CallToolResult {
+	res := &mcp.CallToolResult{
+		Content: []mcp.Content{{Type: "text", Text: output}},
+	}
+
+	var obj map[string]json.RawMessage
+	if err := json.Unmarshal([]byte(output), &obj); err != nil {
+		return res
+	}
+
+	if errMsg, ok := obj["error"]; ok && len(obj) == 1 {
+		var text string
+		if err := json.Unmarshal(errMsg, &text); err == nil {
+			return errorResult(text)
+		}
+	}
+
+	res.StructuredContent = json.RawMessage(output)
+	return res
+}
+
+func errorResult(msg string) *mcp.CallToolResult {
+	return &mcp.CallToolResult{
+		Content: []mcp.Content{{Type: "text", Text: msg}},
+		IsError: true,
+	}
+}
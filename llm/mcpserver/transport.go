@@ -0,0 +1,221 @@
+package mcpserver
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/url"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/xe0r/llm-stuff/llm/mcp"
+)
+
+const maxMessageSize = 16 << 20
+
+// ServeStdio reads newline-delimited messages from in and writes responses
+// to out until in is closed or ctx is done. Requests are handled concurrently.
+// When ctx is done, in is closed if it's an io.Closer, so that the pending
+// read returns.
+func (s *Server) ServeStdio(ctx context.Context, in io.Reader, out io.Writer) error {
+	var writeMu sync.Mutex
+	write := func(msg *mcp.Message) {
+		data, err := json.Marshal(msg)
+		if err != nil {
+			return
+		}
+
+		writeMu.Lock()
+		defer writeMu.Unlock()
+		_, _ = out.Write(append(data, '\n'))
+	}
+
+	var wg sync.WaitGroup
+	defer wg.Wait()
+
+	scanner := bufio.NewScanner(in)
+	scanner.Buffer(make([]byte, 0, 64*1024), maxMessageSize)
+
+	lines := make(chan []byte)
+	go func() {
+		defer close(lines)
+		for scanner.Scan() {
+			select {
+			case lines <- append([]byte(nil), scanner.Bytes()...):
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+
+	for {
+		var line []byte
+		var ok bool
+		select {
+		case <-ctx.Done():
+			if closer, ok := in.(io.Closer); ok {
+				_ = closer.Close()
+			}
+			return ctx.Err()
+		case line, ok = <-lines:
+		}
+		if !ok {
+			return scanner.Err()
+		}
+
+		msgs, err := mcp.ParseMessages(line)
+		if err != nil {
+			write(errorResponse(nil, mcp.CodeParseError, err.Error()))
+			continue
+		}
+
+		for _, msg := range msgs {
+			wg.Add(1)
+			go func(msg *mcp.Message) {
+				defer wg.Done()
+				if resp := s.Handle(ctx, msg); resp != nil {
+					write(resp)
+				}
+			}(msg)
+		}
+	}
+}
+
+// SetAllowedOrigins sets origins allowed to call the HTTP endpoint from a
+// browser. Requests without Origin and from localhost are always allowed.
+func (s *Server) SetAllowedOrigins(origins ...string) {
+	s.origins = origins
+}
+
+func (s *Server) checkOrigin(r *http.Request) bool {
+	origin := r.Header.Get("Origin")
+	if origin == "" || slices.Contains(s.origins, origin) {
+		return true
+	}
+
+	u, err := url.Parse(origin)
+	if err != nil {
+		return false
+	}
+	switch u.Hostname() {
+	case "localhost", "127.0.0.1", "::1":
+		return true
+	}
+	return false
+}
+
+func newSessionID() string {
+	buf := make([]byte, 16)
+	_, _ = rand.Read(buf)
+	return hex.EncodeToString(buf)
+}
+
+// ServeHTTP implements the streamable HTTP transport. Responses are sent as
+// JSON, the server never initiates messages, so GET streams are not supported.
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if !s.checkOrigin(r) {
+		http.Error(w, "origin not allowed", http.StatusForbidden)
+		return
+	}
+
+	sessionID := r.Header.Get("Mcp-Session-Id")
+
+	switch r.Method {
+	case http.MethodPost:
+	case http.MethodDelete:
+		s.sessionsMu.Lock()
+		delete(s.sessions, sessionID)
+		s.sessionsMu.Unlock()
+		w.WriteHeader(http.StatusNoContent)
+		return
+	default:
+		w.Header().Set("Allow", "POST, DELETE")
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	body, err := io.ReadAll(io.LimitReader(r.Body, maxMessageSize))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	msgs, err := mcp.ParseMessages(body)
+	if err != nil {
+		writeJSON(w, http.StatusBadRequest, errorResponse(nil, mcp.CodeParseError, err.Error()))
+		return
+	}
+
+	initialize := len(msgs) == 1 && msgs[0].Method == mcp.MethodInitialize
+	if initialize {
+		sessionID = newSessionID()
+		s.sessionsMu.Lock()
+		s.expireSessions()
+		s.sessions[sessionID] = time.Now()
+		s.sessionsMu.Unlock()
+		w.Header().Set("Mcp-Session-Id", sessionID)
+	} else {
+		if sessionID == "" {
+			http.Error(w, "missing Mcp-Session-Id", http.StatusBadRequest)
+			return
+		}
+
+		s.sessionsMu.Lock()
+		s.expireSessions()
+		_, ok := s.sessions[sessionID]
+		if ok {
+			s.sessions[sessionID] = time.Now()
+		}
+		s.sessionsMu.Unlock()
+		if !ok {
+			http.Error(w, "unknown session", http.StatusNotFound)
+			return
+		}
+	}
+
+	responses := make([]*mcp.Message, 0, len(msgs))
+	for _, msg := range msgs {
+		if resp := s.Handle(r.Context(), msg); resp != nil {
+			responses = append(responses, resp)
+		}
+	}
+
+	switch {
+	case len(responses) == 0:
+		w.WriteHeader(http.StatusAccepted)
+	case len(responses) == 1 && !isBatch(body):
+		writeJSON(w, http.StatusOK, responses[0])
+	default:
+		writeJSON(w, http.StatusOK, responses)
+	}
+}
+
+// expireSessions deletes sessions of clients that went away without DELETE,
+// s.sessionsMu must be held.
+func (s *Server) expireSessions() {
+	if s.sessionTimeout <= 0 {
+		return
+	}
+	for id, lastUsed := range s.sessions {
+		if time.Since(lastUsed) > s.sessionTimeout {
+			delete(s.sessions, id)
+		}
+	}
+}
+
+func isBatch(body []byte) bool {
+	body = bytes.TrimLeft(body, " \t\r\n")
+	return len(body) > 0 && body[0] == '['
+}
+
+func writeJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(v)
+}
@@ -0,0 +1,109 @@
+package mcpserver
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"runtime"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/xe0r/llm-stuff/llm/mcp"
+)
+
+func TestHTTPSessions(t *testing.T) {
+	server := newTestServer()
+	server.SetSessionTimeout(200 * time.Millisecond)
+	srv := httptest.NewServer(server)
+	defer srv.Close()
+
+	send := func(method, sessionID, body string) *http.Response {
+		t.Helper()
+		req, err := http.NewRequest(method, srv.URL, strings.NewReader(body))
+		if err != nil {
+			t.Fatal(err)
+		}
+		req.Header.Set("Content-Type", "application/json")
+		if sessionID != "" {
+			req.Header.Set("Mcp-Session-Id", sessionID)
+		}
+		resp, err := srv.Client().Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		return resp
+	}
+	initialize := func() string {
+		t.Helper()
+		resp := send(http.MethodPost, "", fmt.Sprintf(`{"jsonrpc": "2.0", "id": 1, "method": "initialize", "params": {"protocolVersion": %q, "clientInfo": {"name": "test", "version": "1"}}}`, mcp.ProtocolVersion))
+		sessionID := resp.Header.Get("Mcp-Session-Id")
+		if resp.StatusCode != http.StatusOK || sessionID == "" {
+			t.Fatalf("initialize: status %d, session %q", resp.StatusCode, sessionID)
+		}
+		return sessionID
+	}
+	ping := func(sessionID string) int {
+		t.Helper()
+		return send(http.MethodPost, sessionID, `{"jsonrpc": "2.0", "id": 2, "method": "ping"}`).StatusCode
+	}
+
+	deleted := initialize()
+	if status := ping(deleted); status != http.StatusOK {
+		t.Errorf("ping: status %d", status)
+	}
+	if resp := send(http.MethodDelete, deleted, ""); resp.StatusCode != http.StatusNoContent {
+		t.Errorf("delete: status %d", resp.StatusCode)
+	}
+	if status := ping(deleted); status != http.StatusNotFound {
+		t.Errorf("ping of a deleted session: status %d", status)
+	}
+
+	// A session that is used is kept, an idle one expires
+	idle, active := initialize(), initialize()
+	for i := 0; i < 6; i++ {
+		time.Sleep(50 * time.Millisecond)
+		if status := ping(active); status != http.StatusOK {
+			t.Fatalf("ping of an active session: status %d", status)
+		}
+	}
+	if status := ping(idle); status != http.StatusNotFound {
+		t.Errorf("ping of an idle session: status %d", status)
+	}
+
+	server.sessionsMu.Lock()
+	sessions := len(server.sessions)
+	server.sessionsMu.Unlock()
+	if sessions != 1 {
+		t.Errorf("got %d sessions, want 1", sessions)
+	}
+}
+
+func TestServeStdioCancel(t *testing.T) {
+	before := runtime.NumGoroutine()
+
+	in, inWriter := io.Pipe()
+	defer inWriter.Close()
+	ctx, cancel := context.WithCancel(context.Background())
+
+	done := make(chan error)
+	go func() {
+		done <- newTestServer().ServeStdio(ctx, in, io.Discard)
+	}()
+	cancel()
+	if err := <-done; err != context.Canceled {
+		t.Errorf("got error %v", err)
+	}
+
+	// The goroutine reading in is not left blocked
+	deadline := time.Now().Add(5 * time.Second)
+	for runtime.NumGoroutine() > before {
+		if time.Now().After(deadline) {
+			t.Fatalf("%d goroutines are left running", runtime.NumGoroutine()-before)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
@@ -0,0 +1,93 @@
+package llm
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"slices"
+)
+
+// ModelInfo describes a model in the OpenRouter catalog.
+type ModelInfo struct {
+	ID            string `json:"id"`
+	Name          string `json:"name"`
+	Description   string `json:"description"`
+	ContextLength int    `json:"context_length"`
+	Pricing       struct {
+		Prompt     string `json:"prompt"`
+		Completion string `json:"completion"`
+	} `json:"pricing"`
+	// Request parameters the model supports, e.g. "tools", "response_format"
+	// or "structured_outputs"
+	SupportedParameters []string `json:"supported_parameters"`
+}
+
+func (m *ModelInfo) Supports(param string) bool {
+	return slices.Contains(m.SupportedParameters, param)
+}
+
+// GetModels returns the model catalog. It's fetched once and cached.
+func (c *Client) GetModels() ([]ModelInfo, error) {
+	c.modelsMu.Lock()
+	defer c.modelsMu.Unlock()
+
+	if c.models != nil {
+		return c.models, nil
+	}
+
+	httpReq, err := http.NewRequest("GET", c.baseURL+"models", nil)
+	if err != nil {
+		return nil, err
+	}
+
+	if c.token != "" {
+		httpReq.Header.Set("Authorization", "Bearer "+c.token)
+	}
+	for k, v := range c.headers {
+		httpReq.Header.Set(k, v)
+	}
+
+	httpResp, err := c.client.Do(httpReq)
+	if err != nil {
+		return nil, err
+	}
+	defer httpResp.Body.Close()
+
+	if httpResp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("models: unexpected status %s", httpResp.Status)
+	}
+
+	body, err := io.ReadAll(httpResp.Body)
+	if err != nil {
+		return nil, err
+	}
+
+	var resp struct {
+		Data []ModelInfo `json:"data"`
+	}
+	if err := json.Unmarshal(body, &resp); err != nil {
+		return nil, err
+	}
+
+	c.models = resp.Data
+	if c.models == nil {
+		c.models = []ModelInfo{}
+	}
+	return c.models, nil
+}
+
+// GetModel returns the catalog entry of a model, or nil if it's not there.
+func (c *Client) GetModel(id string) (*ModelInfo, error) {
+	models, err := c.GetModels()
+	if err != nil {
+		return nil, err
+	}
+
+	for i := range models {
+		if models[i].ID == id {
+			return &models[i], nil
+		}
+	}
+	return nil, nil
+}
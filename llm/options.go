@@ -0,0 +1,205 @@
+package llm
+
+import "slices"
+
+// Option changes settings of a chat request. Options can be set for all
+// calls of a client with SetOptions, or passed to a single GetResponse call,
+// in which case they are applied after the client ones.
+type Option func(*callConfig)
+
+type callConfig struct {
+	req *Request
+
+	maxContinuations int
+	limits           Limits
+	responseMode     ResponseMode
+	schemaName       string
+	// The response is sent with the respond tool
+	respond bool
+	// Called with reasoning deltas, see WithReasoningStream
+	onReasoning  func(chunk string)
+	prefill      string
+	continueLast bool
+	stripPrefill bool
+	// The tool loop ends after these tools are called, see WithStopTools
+	stopTools []string
+	// Set by GetResponseWithConfidence
+	confidence bool
+	// Filled in by the call
+	result *RunResult
+	// Content generated in the last round, before the prefill is added and
+	// continuations are stitched
+	generated string
+}
+
+func WithModel(model string) Option {
+	return func(c *callConfig) {
+		c.req.Model = model
+	}
+}
+
+func WithTemperature(temperature float64) Option {
+	return func(c *callConfig) {
+		c.req.Temperature = &temperature
+	}
+}
+
+func WithTopP(topP float64) Option {
+	return func(c *callConfig) {
+		c.req.TopP = &topP
+	}
+}
+
+func WithTopK(topK int) Option {
+	return func(c *callConfig) {
+		c.req.TopK = &topK
+	}
+}
+
+func WithSeed(seed int) Option {
+	return func(c *callConfig) {
+		c.req.Seed = &seed
+	}
+}
+
+func WithMaxTokens(maxTokens int) Option {
+	return func(c *callConfig) {
+		c.req.MaxTokens = maxTokens
+	}
+}
+
+func WithStop(stop []string) Option {
+	return func(c *callConfig) {
+		c.req.Stop = stop
+	}
+}
+
+// WithN asks for n candidate responses, see GetCandidates.
+func WithN(n int) Option {
+	return func(c *callConfig) {
+		c.req.N = n
+	}
+}
+
+// WithLogprobs asks for log probabilities of the output tokens and, if
+// topLogprobs > 0, of the most likely alternatives at each position.
+func WithLogprobs(topLogprobs int) Option {
+	return func(c *callConfig) {
+		c.req.Logprobs = true
+		c.req.TopLogprobs = topLogprobs
+	}
+}
+
+func WithFrequencyPenalty(penalty float64) Option {
+	return func(c *callConfig) {
+		c.req.FrequencyPenalty = &penalty
+	}
+}
+
+func WithPresencePenalty(penalty float64) Option {
+	return func(c *callConfig) {
+		c.req.PresencePenalty = &penalty
+	}
+}
+
+func WithRepetitionPenalty(penalty float64) Option {
+	return func(c *callConfig) {
+		c.req.RepetitionPenalty = &penalty
+	}
+}
+
+// WithLogitBias maps token IDs to a bias from -100 to 100.
+func WithLogitBias(bias map[int]float64) Option {
+	return func(c *callConfig) {
+		c.req.LogitBias = bias
+	}
+}
+
+// WithTransforms sets OpenRouter prompt transforms, e.g. "middle-out".
+func WithTransforms(transforms []string) Option {
+	return func(c *callConfig) {
+		c.req.Transforms = transforms
+	}
+}
+
+func WithParallelToolCalls(parallel bool) Option {
+	return func(c *callConfig) {
+		c.req.ParallelToolCalls = &parallel
+	}
+}
+
+// WithToolChoice sets whether the model may call tools: ToolChoiceAuto,
+// ToolChoiceNone or ToolChoiceRequired. See WithForcedTool to force a
+// specific one.
+func WithToolChoice(choice string) Option {
+	return func(c *callConfig) {
+		c.req.ToolChoice = choice
+	}
+}
+
+// WithForcedTool makes the model call the function with the given name.
+// Only the first round is forced, after that the model chooses on its own.
+func WithForcedTool(name string) Option {
+	return func(c *callConfig) {
+		c.req.ToolChoice = NewToolChoice(name)
+	}
+}
+
+// WithTools makes only the listed functions available in the call.
+func WithTools(names ...string) Option {
+	return func(c *callConfig) {
+		c.filterTools(func(name string) bool { return slices.Contains(names, name) })
+	}
+}
+
+// WithoutTools makes the listed functions unavailable in the call.
+func WithoutTools(names ...string) Option {
+	return func(c *callConfig) {
+		c.filterTools(func(name string) bool { return !slices.Contains(names, name) })
+	}
+}
+
+// WithStopTools ends the tool loop of GetResponse once one of the listed
+// functions is run, e.g. a handoff to another agent. The results of the
+// round are added to the history and the zero result is returned with
+// StopReasonStopTool, without asking the model to answer.
+func WithStopTools(names ...string) Option {
+	return func(c *callConfig) {
+		c.stopTools = names
+	}
+}
+
+func (c *callConfig) filterTools(keep func(name string) bool) {
+	// The tools are shared with the client, so a new slice is made
+	var tools []Tool
+	for _, tool := range c.req.Tools {
+		if keep(tool.Function.Name) {
+			tools = append(tools, tool)
+		}
+	}
+	c.req.Tools = tools
+}
+
+// WithSchemaName sets the name of the JSON schema of the response,
+// "response" by default.
+func WithSchemaName(name string) Option {
+	return func(c *callConfig) {
+		c.schemaName = name
+	}
+}
+
+func WithReasoning(reasoning Reasoning) Option {
+	return func(c *callConfig) {
+		c.req.Reasoning = &reasoning
+	}
+}
+
+// WithContinuation enables continuation of responses truncated by the token
+// limit: the model is asked to continue up to maxContinuations times and the
+// parts are stitched together. Without it a truncated response is returned
+// as IncompleteError.
+func WithContinuation(maxContinuations int) Option {
+	return func(c *callConfig) {
+		c.maxContinuations = maxContinuations
+	}
+}
@@ -0,0 +1,168 @@
+package llm
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+)
+
+// WithPrefill starts the response with the text, e.g. with the opening of
+// a code block, and the model continues it. The prefill is a part of the
+// response, unless WithStripPrefill is set.
+//
+// Not all providers support prefill, some of them respond with a new
+// message instead.
+func WithPrefill(prefill string) Option {
+	return func(c *callConfig) {
+		c.prefill = prefill
+	}
+}
+
+// WithContinueLast continues the last message of the conversation, an
+// assistant message added with AddMessage, like WithPrefill. Without it the
+// model always responds with a new message.
+func WithContinueLast() Option {
+	return func(c *callConfig) {
+		c.continueLast = true
+	}
+}
+
+// WithStripPrefill removes the prefill from the result and the stream. It's
+// kept in the history.
+func WithStripPrefill() Option {
+	return func(c *callConfig) {
+		c.stripPrefill = true
+	}
+}
+
+// startPrefill adds the prefill to the conversation and returns the text of
+// the assistant message the response continues, if the call has a prefill.
+func (c *ChatClient[T]) startPrefill(cfg *callConfig) (string, error) {
+	if cfg.prefill == "" && !cfg.continueLast {
+		return "", nil
+	}
+	if cfg.respond {
+		return "", fmt.Errorf("prefill is not supported in the tool response mode")
+	}
+
+	if cfg.prefill != "" {
+		c.appendMessages(Message{
+			Role:    "assistant",
+			Content: cfg.prefill,
+		})
+	}
+	last := len(c.messages) - 1
+	if last < 0 || c.messages[last].Role != "assistant" || len(c.messages[last].ToolCalls) > 0 {
+		return "", fmt.Errorf("no assistant message to continue")
+	}
+	return c.messages[last].Content, nil
+}
+
+// dropPrefill removes the prefill added by startPrefill.
+func (c *ChatClient[T]) dropPrefill(cfg *callConfig) {
+	if cfg.prefill != "" {
+		last := len(c.messages) - 1
+		c.setMessages(c.messages[:last], last)
+	}
+}
+
+// output returns the content of the response as the result.
+func (c *callConfig) output(content, prefill string) string {
+	if c.stripPrefill {
+		return strings.TrimPrefix(content, prefill)
+	}
+	return content
+}
+
+// stopFilter cuts streamed text at the first stop sequence, for providers
+// that ignore them. Text that may be the start of a stop sequence is held
+// back until the next chunk shows whether it is.
+type stopFilter struct {
+	stops   []string
+	pending string
+	stopped bool
+}
+
+func (f *stopFilter) write(text string) string {
+	if f.stopped {
+		return ""
+	}
+
+	f.pending += text
+	if i, _ := findStop(f.pending, f.stops); i >= 0 {
+		f.stopped = true
+		out := f.pending[:i]
+		f.pending = ""
+		return out
+	}
+
+	keep := 0
+	for _, stop := range f.stops {
+		for n := min(len(stop)-1, len(f.pending)); n > keep; n-- {
+			if strings.HasSuffix(f.pending, stop[:n]) {
+				keep = n
+				break
+			}
+		}
+	}
+
+	out := f.pending[:len(f.pending)-keep]
+	f.pending = f.pending[len(f.pending)-keep:]
+	return out
+}
+
+// flush returns the text held back at the end of the stream.
+func (f *stopFilter) flush() string {
+	out := f.pending
+	f.pending = ""
+	if f.stopped {
+		return ""
+	}
+	return out
+}
+
+// findStop returns the position of the first stop sequence in the text and
+// the sequence, or -1.
+func findStop(text string, stops []string) (int, string) {
+	pos, found := -1, ""
+	for _, stop := range stops {
+		if stop == "" {
+			continue
+		}
+		if i := strings.Index(text, stop); i >= 0 && (pos < 0 || i < pos) {
+			pos, found = i, stop
+		}
+	}
+	return pos, found
+}
+
+// applyStop cuts the message at the first stop sequence if the provider
+// ignored them, and returns the stop sequence that ended the response, if
+// it's known.
+func applyStop(choice *Choice, stops []string) string {
+	if len(stops) == 0 {
+		return ""
+	}
+
+	msg := choice.Message
+	if i, stop := findStop(msg.Content, stops); i >= 0 {
+		msg.Content = msg.Content[:i]
+		// Tool calls still have to be run
+		if len(msg.ToolCalls) == 0 {
+			choice.FinishReason = FinishReasonStop
+		}
+		return stop
+	}
+
+	if choice.FinishReason.Normalize() != FinishReasonStop {
+		return ""
+	}
+	// Some providers report the matched sequence, others only that one matched
+	if stop, ok := choice.StopReason.(string); ok && slices.Contains(stops, stop) {
+		return stop
+	}
+	if len(stops) == 1 && choice.NativeFinishReason == "stop_sequence" {
+		return stops[0]
+	}
+	return ""
+}
@@ -0,0 +1,120 @@
+package llm
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"testing"
+)
+
+func TestPrefill(t *testing.T) {
+	var lastReq Request
+	c := newTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+		if err := json.NewDecoder(r.Body).Decode(&lastReq); err != nil {
+			t.Error(err)
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(Response{Choices: []Choice{{
+			Message:      &Message{Role: "assistant", Content: "world"},
+			FinishReason: FinishReasonStop,
+		}}})
+	})
+
+	chat := NewChatClientWithClient[string](c, nil)
+	chat.SetModel("m")
+	chat.AddMessage("user", "Hi")
+
+	result, err := chat.GetResponse(nil, WithPrefill("Hello "))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result != "Hello world" {
+		t.Errorf("got %q", result)
+	}
+	if last := lastReq.Messages[len(lastReq.Messages)-1]; last.Role != "assistant" || last.Content != "Hello " {
+		t.Errorf("prefill not sent: %+v", last)
+	}
+
+	// A finished answer is not continued without WithContinueLast
+	result, err = chat.GetResponse(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result != "world" {
+		t.Errorf("got %q", result)
+	}
+	messages := chat.Messages()
+	if len(messages) != 3 || messages[1].Content != "Hello world" || messages[2].Content != "world" {
+		t.Errorf("got messages %+v", messages)
+	}
+
+	chat.AddMessage("assistant", "Hello ")
+	result, err = chat.GetResponse(nil, WithContinueLast(), WithStripPrefill())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result != "world" || chat.Messages()[3].Content != "Hello world" {
+		t.Errorf("got %q, messages %+v", result, chat.Messages())
+	}
+
+	chat.AddMessage("user", "Hi")
+	if _, err := chat.GetResponse(nil, WithContinueLast()); err == nil {
+		t.Error("continued a user message")
+	}
+}
+
+func TestPrefillRequestError(t *testing.T) {
+	c := newTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusBadRequest)
+		io.WriteString(w, `{"error":{"message":"bad request","code":400}}`)
+	})
+
+	chat := NewChatClientWithClient[string](c, nil)
+	chat.SetModel("m")
+	chat.AddMessage("user", "Hi")
+
+	if _, err := chat.GetResponse(nil, WithPrefill("Hello ")); err == nil {
+		t.Fatal("request succeeded")
+	}
+	if messages := chat.Messages(); len(messages) != 1 || messages[0].Role != "user" {
+		t.Errorf("prefill left in the history: %+v", messages)
+	}
+}
+
+func TestStopWithToolCalls(t *testing.T) {
+	c := newTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+		var req Request
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			t.Error(err)
+		}
+		if req.Messages[len(req.Messages)-1].Role == "tool" {
+			writeMessage(w, Message{Role: "assistant", Content: "done"}, FinishReasonStop)
+			return
+		}
+		// The provider ignores the stop sequence
+		msg := toolCallMessage("call1", "ping")
+		msg.Content = "Pinging END now"
+		writeMessage(w, msg, FinishReasonToolCalls)
+	})
+
+	called := false
+	ping := NewCallableFunction("ping", "Pings", func(*pingRequest) *pingResponse {
+		called = true
+		return &pingResponse{Pong: true}
+	})
+	chat := NewChatClientWithClient[string](c, []CallableFunction{ping})
+	chat.SetModel("m")
+	chat.AddMessage("user", "Ping")
+
+	result, err := chat.GetResponse(nil, WithStop([]string{"END"}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !called || result != "done" {
+		t.Errorf("tool called %v, got %q", called, result)
+	}
+	if messages := chat.Messages(); messages[1].Content != "Pinging " {
+		t.Errorf("got messages %+v", messages)
+	}
+}
@@ -0,0 +1,102 @@
+package prompt
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// Ext is the extension of prompt files.
+const Ext = ".tmpl"
+
+// DirEnv overrides the directory of user prompts.
+const DirEnv = "LLM_PROMPTS"
+
+// Library loads prompts by name from a stack of file systems, later ones
+// override earlier ones.
+type Library struct {
+	fss []fs.FS
+}
+
+func NewLibrary(fss ...fs.FS) *Library {
+	return &Library{
+		fss: fss,
+	}
+}
+
+// UserDir returns the directory of user prompts: $LLM_PROMPTS, or
+// llm-stuff/prompts in the user config directory.
+func UserDir() (string, error) {
+	if dir := os.Getenv(DirEnv); dir != "" {
+		return dir, nil
+	}
+
+	dir, err := os.UserConfigDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, "llm-stuff", "prompts"), nil
+}
+
+// NewDefaultLibrary creates a library of the embedded prompts of a program,
+// which can be overridden by files in UserDir without recompiling.
+func NewDefaultLibrary(embedded fs.FS) *Library {
+	lib := NewLibrary(embedded)
+	if dir, err := UserDir(); err == nil {
+		lib.fss = append(lib.fss, os.DirFS(dir))
+	}
+	return lib
+}
+
+// Get loads and parses a prompt, name is the file name without extension.
+func (l *Library) Get(name string) (*Prompt, error) {
+	if !fs.ValidPath(name + Ext) {
+		return nil, fmt.Errorf("invalid prompt name %q", name)
+	}
+
+	for i := len(l.fss) - 1; i >= 0; i-- {
+		data, err := fs.ReadFile(l.fss[i], name+Ext)
+		if errors.Is(err, fs.ErrNotExist) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		return Parse(name, string(data))
+	}
+	return nil, fmt.Errorf("prompt %s not found", name)
+}
+
+// List returns names of all prompts in the library.
+func (l *Library) List() ([]string, error) {
+	seen := make(map[string]bool)
+	for _, fsys := range l.fss {
+		err := fs.WalkDir(fsys, ".", func(p string, entry fs.DirEntry, err error) error {
+			if err != nil {
+				// Missing user directory is fine
+				if p == "." {
+					return fs.SkipDir
+				}
+				return err
+			}
+			if !entry.IsDir() && strings.HasSuffix(p, Ext) {
+				seen[strings.TrimSuffix(p, Ext)] = true
+			}
+			return nil
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	names := make([]string, 0, len(seen))
+	for name := range seen {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names, nil
+}
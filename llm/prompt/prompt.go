@@ -0,0 +1,407 @@
+// Package prompt renders chat prompts from text/template files with front
+// matter and typed variables:
+//
+//	---
+//	description: Converts code to another language
+//	model: openai/gpt-4o
+//	temperature: 0.2
+//	vars:
+//	  language: string
+//	  code: string
+//	  hint: string?
+//	---
+//	<|system|>
+//	You convert code to {{.language}}.
+//	<|user|>
+//	{{.code}}
+//
+// Role markers (<|system|>, <|user|>, <|assistant|>) start messages, so
+// few-shot examples are written as user and assistant pairs. Text before the
+// first marker is a system message. Markers are recognized in the template,
+// not in the rendered text, so variables can't inject messages.
+package prompt
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+	"text/template"
+
+	"github.com/xe0r/llm-stuff/llm"
+)
+
+type VarType string
+
+const (
+	TypeString VarType = "string"
+	TypeInt    VarType = "int"
+	TypeFloat  VarType = "float"
+	TypeBool   VarType = "bool"
+	// List of strings
+	TypeList VarType = "list"
+	// Any value, e.g. a map rendered with the json function
+	TypeAny VarType = "any"
+)
+
+type Var struct {
+	Name string
+	Type VarType
+	// Optional variables are declared with a "?" after the type
+	Optional bool
+}
+
+type Message struct {
+	Role    string
+	Content string
+}
+
+type Prompt struct {
+	Name        string
+	Description string
+
+	Model       string
+	Temperature *float64
+	TopP        *float64
+	MaxTokens   int
+	// Name of the JSON schema of the response
+	Response string
+
+	Vars []Var
+
+	roles     []string
+	templates []*template.Template
+}
+
+var funcs = template.FuncMap{
+	"json": func(v any) (string, error) {
+		data, err := json.Marshal(v)
+		return string(data), err
+	},
+	"join": func(sep string, items []string) string {
+		return strings.Join(items, sep)
+	},
+}
+
+var roleMarker = regexp.MustCompile(`(?m)^<\|(system|user|assistant)\|>[ \t]*\r?\n?`)
+
+// Parse parses a prompt file.
+func Parse(name, text string) (*Prompt, error) {
+	p := &Prompt{
+		Name: name,
+	}
+
+	body, err := p.parseFrontMatter(text)
+	if err != nil {
+		return nil, fmt.Errorf("prompt %s: %w", name, err)
+	}
+
+	role := "system"
+	start := 0
+	sections := [][2]string{}
+	for _, loc := range roleMarker.FindAllStringSubmatchIndex(body, -1) {
+		sections = append(sections, [2]string{role, body[start:loc[0]]})
+		role = body[loc[2]:loc[3]]
+		start = loc[1]
+	}
+	sections = append(sections, [2]string{role, body[start:]})
+
+	for i, section := range sections {
+		// The line break before the next marker belongs to the marker
+		text := strings.TrimSuffix(strings.TrimSuffix(section[1], "\n"), "\r")
+		if i == 0 && strings.TrimSpace(text) == "" {
+			continue
+		}
+
+		tmpl, err := template.New(fmt.Sprintf("%s#%d", name, i)).
+			Funcs(funcs).
+			Option("missingkey=error").
+			Parse(text)
+		if err != nil {
+			return nil, fmt.Errorf("prompt %s: %w", name, err)
+		}
+
+		p.roles = append(p.roles, section[0])
+		p.templates = append(p.templates, tmpl)
+	}
+
+	if len(p.templates) == 0 {
+		return nil, fmt.Errorf("prompt %s is empty", name)
+	}
+	return p, nil
+}
+
+// parseFrontMatter parses the front matter, if any, and returns the rest of
+// the text. Only a small subset of YAML is supported: "key: value" lines and
+// the indented "vars" block.
+func (p *Prompt) parseFrontMatter(text string) (string, error) {
+	text = strings.TrimPrefix(text, "\ufeff")
+	if !strings.HasPrefix(text, "---\n") && !strings.HasPrefix(text, "---\r\n") {
+		return text, nil
+	}
+
+	_, rest, _ := strings.Cut(text, "\n")
+	header, body, found := strings.Cut("\n"+rest, "\n---")
+	if !found {
+		return "", fmt.Errorf("front matter is not closed")
+	}
+	header = strings.TrimPrefix(header, "\n")
+	// Rest of the closing line
+	if _, after, ok := strings.Cut(body, "\n"); ok {
+		body = after
+	} else {
+		body = ""
+	}
+
+	inVars := false
+	for n, line := range strings.Split(header, "\n") {
+		line = stripComment(strings.TrimRight(line, "\r"))
+		if strings.TrimSpace(line) == "" || strings.HasPrefix(strings.TrimSpace(line), "#") {
+			continue
+		}
+
+		key, value, ok := strings.Cut(line, ":")
+		if !ok {
+			return "", fmt.Errorf("line %d: expected key: value", n+2)
+		}
+		value = unquote(strings.TrimSpace(value))
+
+		indented := strings.HasPrefix(key, " ") || strings.HasPrefix(key, "\t")
+		key = strings.TrimSpace(key)
+
+		if indented {
+			if !inVars {
+				return "", fmt.Errorf("line %d: unexpected indentation", n+2)
+			}
+			v := Var{Name: key, Type: VarType(value)}
+			if strings.HasSuffix(value, "?") {
+				v.Optional = true
+				v.Type = VarType(strings.TrimSuffix(value, "?"))
+			}
+			switch v.Type {
+			case TypeString, TypeInt, TypeFloat, TypeBool, TypeList, TypeAny:
+			default:
+				return "", fmt.Errorf("line %d: unknown type %q of variable %s", n+2, value, key)
+			}
+			p.Vars = append(p.Vars, v)
+			continue
+		}
+
+		inVars = false
+		var err error
+		switch key {
+		case "description":
+			p.Description = value
+		case "model":
+			p.Model = value
+		case "response":
+			p.Response = value
+		case "temperature":
+			p.Temperature, err = parseFloat(value)
+		case "top_p":
+			p.TopP, err = parseFloat(value)
+		case "max_tokens":
+			p.MaxTokens, err = strconv.Atoi(value)
+		case "vars":
+			inVars = true
+		default:
+			err = fmt.Errorf("unknown key %s", key)
+		}
+		if err != nil {
+			return "", fmt.Errorf("line %d: %w", n+2, err)
+		}
+	}
+	return body, nil
+}
+
+// stripComment removes a " #" comment from the line, unless it's quoted.
+func stripComment(line string) string {
+	var quote byte
+	for i := 0; i < len(line); i++ {
+		switch c := line[i]; {
+		case quote != 0:
+			if c == '\\' && quote == '"' {
+				i++
+			} else if c == quote {
+				quote = 0
+			}
+		case c == '"' || c == '\'':
+			quote = c
+		case c == '#' && i > 0 && (line[i-1] == ' ' || line[i-1] == '\t'):
+			return line[:i]
+		}
+	}
+	return line
+}
+
+func unquote(s string) string {
+	if len(s) >= 2 && (s[0] == '"' || s[0] == '\'') && s[len(s)-1] == s[0] {
+		if s[0] == '"' {
+			if u, err := strconv.Unquote(s); err == nil {
+				return u
+			}
+		}
+		return s[1 : len(s)-1]
+	}
+	return s
+}
+
+func parseFloat(s string) (*float64, error) {
+	f, err := strconv.ParseFloat(s, 64)
+	if err != nil {
+		return nil, err
+	}
+	return &f, nil
+}
+
+// Validate checks the variables against the declared ones.
+func (p *Prompt) Validate(vars map[string]any) error {
+	for name := range vars {
+		if !slices.ContainsFunc(p.Vars, func(v Var) bool { return v.Name == name }) {
+			return fmt.Errorf("prompt %s: unknown variable %s", p.Name, name)
+		}
+	}
+
+	for _, v := range p.Vars {
+		value, ok := vars[v.Name]
+		if !ok || value == nil {
+			if v.Optional {
+				continue
+			}
+			return fmt.Errorf("prompt %s: variable %s is not set", p.Name, v.Name)
+		}
+
+		if !v.Type.matches(value) {
+			return fmt.Errorf("prompt %s: variable %s must be %s, got %T", p.Name, v.Name, v.Type, value)
+		}
+	}
+	return nil
+}
+
+func (t VarType) matches(value any) bool {
+	rv := reflect.ValueOf(value)
+	switch t {
+	case TypeString:
+		return rv.Kind() == reflect.String
+	case TypeInt:
+		switch rv.Kind() {
+		case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+			reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+			return true
+		case reflect.Float32, reflect.Float64:
+			// Numbers decoded from JSON
+			return rv.Float() == float64(int64(rv.Float()))
+		}
+		return false
+	case TypeFloat:
+		switch rv.Kind() {
+		case reflect.Float32, reflect.Float64,
+			reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+			reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+			return true
+		}
+		return false
+	case TypeBool:
+		return rv.Kind() == reflect.Bool
+	case TypeList:
+		if rv.Kind() != reflect.Slice {
+			return false
+		}
+		for i := 0; i < rv.Len(); i++ {
+			if reflect.ValueOf(rv.Index(i).Interface()).Kind() != reflect.String {
+				return false
+			}
+		}
+		return true
+	}
+	return true
+}
+
+// Render validates the variables and renders the messages. Optional
+// variables that are not set are rendered as empty values.
+func (p *Prompt) Render(vars map[string]any) ([]Message, error) {
+	if err := p.Validate(vars); err != nil {
+		return nil, err
+	}
+
+	data := make(map[string]any, len(p.Vars))
+	for _, v := range p.Vars {
+		data[v.Name] = v.Type.zero()
+	}
+	for name, value := range vars {
+		if value != nil {
+			data[name] = value
+		}
+	}
+
+	messages := make([]Message, 0, len(p.templates))
+	for i, tmpl := range p.templates {
+		var buf bytes.Buffer
+		if err := tmpl.Execute(&buf, data); err != nil {
+			return nil, fmt.Errorf("prompt %s: %w", p.Name, err)
+		}
+		messages = append(messages, Message{
+			Role:    p.roles[i],
+			Content: buf.String(),
+		})
+	}
+	return messages, nil
+}
+
+func (t VarType) zero() any {
+	switch t {
+	case TypeString:
+		return ""
+	case TypeInt:
+		return 0
+	case TypeFloat:
+		return 0.0
+	case TypeBool:
+		return false
+	case TypeList:
+		return []string(nil)
+	}
+	return nil
+}
+
+// Options returns the request parameters of the prompt.
+func (p *Prompt) Options() []llm.Option {
+	var opts []llm.Option
+	if p.Temperature != nil {
+		opts = append(opts, llm.WithTemperature(*p.Temperature))
+	}
+	if p.TopP != nil {
+		opts = append(opts, llm.WithTopP(*p.TopP))
+	}
+	if p.MaxTokens > 0 {
+		opts = append(opts, llm.WithMaxTokens(p.MaxTokens))
+	}
+	if p.Response != "" {
+		opts = append(opts, llm.WithSchemaName(p.Response))
+	}
+	return opts
+}
+
+// Apply renders the prompt into the conversation of the client and sets
+// the model and the parameters of the prompt. Set the model after Apply to
+// override the one of the prompt.
+func Apply[T any](client *llm.ChatClient[T], p *Prompt, vars map[string]any) error {
+	messages, err := p.Render(vars)
+	if err != nil {
+		return err
+	}
+
+	if p.Model != "" {
+		client.SetModel(p.Model)
+	}
+	client.SetOptions(p.Options()...)
+
+	for _, msg := range messages {
+		client.AddMessage(msg.Role, msg.Content)
+	}
+	return nil
+}
@@ -0,0 +1,187 @@
+package prompt
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"testing/fstest"
+)
+
+func TestParseFrontMatter(t *testing.T) {
+	p, err := Parse("convert", `---
+description: "C # port" # a comment
+model: 'openai/gpt-4o'
+temperature: 0.2
+max_tokens: 100
+vars:
+  language: string
+  hint: string?
+  count: int?
+---
+Convert to {{.language}}.`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if p.Description != "C # port" || p.Model != "openai/gpt-4o" || *p.Temperature != 0.2 || p.MaxTokens != 100 {
+		t.Errorf("got %+v", p)
+	}
+	want := []Var{
+		{Name: "language", Type: TypeString},
+		{Name: "hint", Type: TypeString, Optional: true},
+		{Name: "count", Type: TypeInt, Optional: true},
+	}
+	if !reflect.DeepEqual(p.Vars, want) {
+		t.Errorf("got vars %+v", p.Vars)
+	}
+}
+
+func TestParseFrontMatterErrors(t *testing.T) {
+	tests := []struct {
+		name    string
+		text    string
+		wantErr string
+	}{
+		{"not closed", "---\nmodel: m\n<|user|>\nHi", "not closed"},
+		{"unknown key", "---\nmodel: m\ncolor: red\n---\nHi", "line 3: unknown key color"},
+		{"unknown type", "---\nvars:\n  n: number\n---\nHi", `line 3: unknown type "number" of variable n`},
+		{"unexpected indentation", "---\n  n: int\n---\nHi", "line 2: unexpected indentation"},
+		{"bad temperature", "---\ntemperature: warm\n---\nHi", "line 2:"},
+		{"no value", "---\nmodel\n---\nHi", "line 2: expected key: value"},
+		{"empty", "---\nmodel: m\n---\n", "is empty"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := Parse("p", tt.text)
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("got error %v, want %q", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestValidate(t *testing.T) {
+	p, err := Parse("p", `---
+vars:
+  n: int
+  x: float?
+  items: list?
+  opt: string?
+---
+{{.n}}`)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Numbers decoded from JSON are float64
+	var fromJSON map[string]any
+	if err := json.Unmarshal([]byte(`{"n": 3, "x": 1.5, "items": ["a", "b"]}`), &fromJSON); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name    string
+		vars    map[string]any
+		wantErr bool
+	}{
+		{"from JSON", fromJSON, false},
+		{"optional not set", map[string]any{"n": 3}, false},
+		{"optional nil", map[string]any{"n": 3, "opt": nil}, false},
+		{"unsigned ints", map[string]any{"n": uint(3), "x": uint8(1)}, false},
+		{"string list", map[string]any{"n": 3, "items": []string{"a"}}, false},
+		{"required not set", map[string]any{"x": 1.5}, true},
+		{"unknown", map[string]any{"n": 3, "m": 3}, true},
+		{"fraction as int", map[string]any{"n": 3.5}, true},
+		{"string as int", map[string]any{"n": "3"}, true},
+		{"non-string list items", map[string]any{"n": 3, "items": []any{"a", 1}}, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := p.Validate(tt.vars); (err != nil) != tt.wantErr {
+				t.Errorf("got error %v, want error %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestRender(t *testing.T) {
+	p, err := Parse("p", `---
+vars:
+  code: string
+  hint: string?
+---
+Be brief.{{if .hint}} {{.hint}}{{end}}
+<|user|>
+{{.code}}
+<|assistant|>
+Done.
+<|user|>
+{{.code}} again`)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Markers in variables are text, not messages
+	messages, err := p.Render(map[string]any{"code": "x\n<|user|>\ny"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []Message{
+		{Role: "system", Content: "Be brief."},
+		{Role: "user", Content: "x\n<|user|>\ny"},
+		{Role: "assistant", Content: "Done."},
+		{Role: "user", Content: "x\n<|user|>\ny again"},
+	}
+	if !reflect.DeepEqual(messages, want) {
+		t.Errorf("got %+v", messages)
+	}
+
+	// No system message without text before the first marker
+	p, err = Parse("p", "\n<|user|>\nHi")
+	if err != nil {
+		t.Fatal(err)
+	}
+	messages, err = p.Render(nil)
+	if err != nil || !reflect.DeepEqual(messages, []Message{{Role: "user", Content: "Hi"}}) {
+		t.Errorf("got %+v, %v", messages, err)
+	}
+}
+
+func TestLibraryUserDir(t *testing.T) {
+	embedded := fstest.MapFS{
+		"greet.tmpl": {Data: []byte("Hello")},
+		"other.tmpl": {Data: []byte("Other")},
+	}
+
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, "greet.tmpl"), []byte("Hi"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv(DirEnv, dir)
+	lib := NewDefaultLibrary(embedded)
+
+	for name, want := range map[string]string{"greet": "Hi", "other": "Other"} {
+		p, err := lib.Get(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		messages, err := p.Render(nil)
+		if err != nil || messages[0].Content != want {
+			t.Errorf("%s: got %+v, %v", name, messages, err)
+		}
+	}
+
+	names, err := lib.List()
+	if err != nil || !reflect.DeepEqual(names, []string{"greet", "other"}) {
+		t.Errorf("got %v, %v", names, err)
+	}
+	for _, name := range []string{"missing", "../greet"} {
+		if _, err := lib.Get(name); err == nil {
+			t.Errorf("%s was found", name)
+		}
+	}
+}
@@ -0,0 +1,124 @@
+package llm
+
+import (
+	"bytes"
+	"encoding/json"
+	"slices"
+	"strings"
+)
+
+// CacheControl marks the end of a prompt prefix the provider should cache,
+// a cache breakpoint. Anthropic and Gemini models support it through
+// OpenRouter, other providers cache prompts automatically or not at all.
+type CacheControl struct {
+	Type string `json:"type"`
+	// E.g. "1h", the default is 5 minutes
+	TTL string `json:"ttl,omitempty"`
+}
+
+const CacheControlEphemeral = "ephemeral"
+
+func NewCacheControl() *CacheControl {
+	return &CacheControl{Type: CacheControlEphemeral}
+}
+
+// MarshalJSON sends the content as parts if there are parts or a cache
+// breakpoint, and as a string otherwise.
+func (m Message) MarshalJSON() ([]byte, error) {
+	type plain Message
+	if len(m.Parts) == 0 && m.CacheControl == nil {
+		return json.Marshal(plain(m))
+	}
+
+	parts := slices.Clone(m.Parts)
+	if len(parts) == 0 {
+		parts = []ContentPart{{Type: "text", Text: m.Content}}
+	}
+	if m.CacheControl != nil {
+		parts[len(parts)-1].CacheControl = m.CacheControl
+	}
+
+	return json.Marshal(struct {
+		plain
+		Content []ContentPart `json:"content"`
+	}{plain(m), parts})
+}
+
+// UnmarshalJSON accepts the content as a string or parts. A single text
+// part is decoded to Content and CacheControl, so that messages saved with a
+// breakpoint are read back unchanged.
+func (m *Message) UnmarshalJSON(data []byte) error {
+	type plain Message
+	var msg struct {
+		plain
+		Content json.RawMessage `json:"content"`
+	}
+	if err := json.Unmarshal(data, &msg); err != nil {
+		return err
+	}
+	*m = Message(msg.plain)
+
+	content := bytes.TrimSpace(msg.Content)
+	switch {
+	case len(content) == 0 || string(content) == "null":
+		return nil
+	case content[0] == '"':
+		return json.Unmarshal(content, &m.Content)
+	}
+
+	var parts []ContentPart
+	if err := json.Unmarshal(content, &parts); err != nil {
+		return err
+	}
+	if len(parts) == 1 && parts[0].Type == "text" {
+		m.Content = parts[0].Text
+		m.CacheControl = parts[0].CacheControl
+		return nil
+	}
+
+	var text strings.Builder
+	for _, part := range parts {
+		text.WriteString(part.Text)
+	}
+	m.Content = text.String()
+	m.Parts = parts
+	return nil
+}
+
+// WithPromptCaching places cache breakpoints at the end of the tool
+// definitions and of the system prompt, so that they're not processed again
+// on every turn. Breakpoints set on messages by the caller are kept.
+func WithPromptCaching() Option {
+	return func(c *callConfig) {
+		c.req.promptCaching = true
+	}
+}
+
+// addCacheBreakpoints returns a copy of the request with cache breakpoints,
+// the messages and tools of the request are not changed.
+func addCacheBreakpoints(req *Request) *Request {
+	res := *req
+
+	if len(res.Tools) > 0 {
+		res.Tools = slices.Clone(res.Tools)
+		res.Tools[len(res.Tools)-1].CacheControl = NewCacheControl()
+	}
+
+	system := 0
+	for system < len(res.Messages) && res.Messages[system].Role == "system" {
+		system++
+	}
+	if system > 0 && res.Messages[system-1].CacheControl == nil {
+		res.Messages = slices.Clone(res.Messages)
+		res.Messages[system-1].CacheControl = NewCacheControl()
+	}
+	return &res
+}
+
+// CachedTokens returns the number of prompt tokens read from the cache.
+func (u *Usage) CachedTokens() int {
+	if u == nil || u.PromptTokensDetails == nil {
+		return 0
+	}
+	return u.PromptTokensDetails.CachedTokens
+}
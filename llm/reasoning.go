@@ -0,0 +1,103 @@
+package llm
+
+const (
+	ReasoningEffortLow    = "low"
+	ReasoningEffortMedium = "medium"
+	ReasoningEffortHigh   = "high"
+)
+
+const (
+	ReasoningDetailText      = "reasoning.text"
+	ReasoningDetailSummary   = "reasoning.summary"
+	ReasoningDetailEncrypted = "reasoning.encrypted"
+)
+
+// ReasoningDetail is a block of the reasoning of a model. Some providers
+// require the blocks, including the encrypted and signed ones, to be sent
+// back unchanged with the tool results, so they're kept in the history.
+type ReasoningDetail struct {
+	Type   string `json:"type"`
+	ID     string `json:"id,omitempty"`
+	Format string `json:"format,omitempty"`
+	Index  int    `json:"index"`
+
+	Text      string `json:"text,omitempty"`
+	Summary   string `json:"summary,omitempty"`
+	Signature string `json:"signature,omitempty"`
+	// Encrypted reasoning
+	Data string `json:"data,omitempty"`
+}
+
+// WithReasoningEffort sets the reasoning effort, one of the ReasoningEffort
+// constants.
+func WithReasoningEffort(effort string) Option {
+	return func(c *callConfig) {
+		c.reasoning().Effort = effort
+	}
+}
+
+// WithReasoningTokens limits the number of reasoning tokens.
+func WithReasoningTokens(maxTokens int) Option {
+	return func(c *callConfig) {
+		c.reasoning().MaxTokens = maxTokens
+	}
+}
+
+// WithReasoningStream calls fn with the reasoning deltas as they're streamed,
+// separately from the text of the response sent to the chunk channel.
+func WithReasoningStream(fn func(chunk string)) Option {
+	return func(c *callConfig) {
+		c.onReasoning = fn
+	}
+}
+
+// reasoning returns a copy of the reasoning settings of the request to change.
+func (c *callConfig) reasoning() *Reasoning {
+	reasoning := &Reasoning{}
+	if c.req.Reasoning != nil {
+		*reasoning = *c.req.Reasoning
+	}
+	c.req.Reasoning = reasoning
+	return reasoning
+}
+
+// mergeReasoningDetails merges streamed reasoning blocks by index.
+func mergeReasoningDetails(base, update []ReasoningDetail) []ReasoningDetail {
+	for _, detail := range update {
+		var target *ReasoningDetail
+		for i := range base {
+			if base[i].Index == detail.Index {
+				target = &base[i]
+				break
+			}
+		}
+
+		if target == nil {
+			base = append(base, detail)
+			continue
+		}
+
+		if target.Type == "" {
+			target.Type = detail.Type
+		}
+		if target.ID == "" {
+			target.ID = detail.ID
+		}
+		if target.Format == "" {
+			target.Format = detail.Format
+		}
+		target.Text += detail.Text
+		target.Summary += detail.Summary
+		target.Signature += detail.Signature
+		target.Data += detail.Data
+	}
+	return base
+}
+
+// ReasoningTokens returns the number of completion tokens spent on reasoning.
+func (u *Usage) ReasoningTokens() int {
+	if u == nil || u.CompletionTokensDetails == nil {
+		return 0
+	}
+	return u.CompletionTokensDetails.ReasoningTokens
+}
@@ -0,0 +1,199 @@
+package llm
+
+import (
+	"fmt"
+	"reflect"
+	"slices"
+)
+
+// ResponseMode is the way a ChatClient with a non-string type gets
+// structured responses from the model.
+type ResponseMode string
+
+const (
+	// ResponseModeSchema uses response_format json_schema, the default for
+	// structs
+	ResponseModeSchema ResponseMode = "json_schema"
+	// ResponseModeObject uses response_format json_object, the schema is not
+	// enforced and has to be described in the prompt
+	ResponseModeObject ResponseMode = "json_object"
+	// ResponseModeTool forces the model to call a "respond" tool whose
+	// parameters are the response, for models that are better at function
+	// calling than at structured outputs
+	ResponseModeTool ResponseMode = "tool"
+	// ResponseModeAuto picks one of the modes by the parameters the model
+	// supports according to the model catalog
+	ResponseModeAuto ResponseMode = "auto"
+)
+
+const (
+	respondToolName        = "respond"
+	respondToolDescription = "Sends the final response to the user. Call it when you are ready to respond, the arguments are the response."
+)
+
+// SetResponseMode sets the response mode for all calls, see WithResponseMode
+// to set it for a single call. It has no effect for string responses.
+func (c *ChatClient[T]) SetResponseMode(mode ResponseMode) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.config().responseMode = mode
+}
+
+func WithResponseMode(mode ResponseMode) Option {
+	return func(c *callConfig) {
+		c.responseMode = mode
+	}
+}
+
+// applyResponseMode changes the request according to the response mode.
+func (c *ChatClient[T]) applyResponseMode(cfg *callConfig) error {
+	req := cfg.req
+
+	ty := reflect.TypeOf((*T)(nil)).Elem()
+	if ty.Kind() == reflect.String || cfg.responseMode == "" {
+		// The schema of the client is shared, so it's renamed in a copy
+		if schema := req.ResponseFormat.JSONSchema; schema != nil && cfg.schemaName != "" {
+			schema := *schema
+			schema.Name = cfg.schemaName
+			req.ResponseFormat.JSONSchema = &schema
+		}
+		return nil
+	}
+
+	schema := getParamDef(ty)
+
+	mode := cfg.responseMode
+	if mode == ResponseModeAuto {
+		mode = c.selectResponseMode(req.Model, schema)
+	}
+
+	switch mode {
+	case ResponseModeSchema:
+		if !strictSchema(schema) {
+			return fmt.Errorf("response type %s can't be described with a strict JSON schema", ty)
+		}
+		name := cfg.schemaName
+		if name == "" {
+			name = "response"
+		}
+		req.ResponseFormat = ResponseFormat{
+			Type: "json_schema",
+			JSONSchema: &JSONSchema{
+				Name:   name,
+				Strict: true,
+				Schema: schema,
+			},
+		}
+	case ResponseModeObject:
+		req.ResponseFormat = ResponseFormat{Type: "json_object"}
+	case ResponseModeTool:
+		if schema.Type != "object" {
+			return fmt.Errorf("response type %s is not an object, it can't be sent with a tool", ty)
+		}
+
+		req.ResponseFormat = ResponseFormat{Type: "text"}
+		req.Tools = append(slices.Clip(req.Tools), Tool{
+			Type: "function",
+			Function: FunctionDescription{
+				Name:        respondToolName,
+				Description: respondToolDescription,
+				Parameters:  schema,
+			},
+		})
+		cfg.respond = true
+		if _, forced := req.ToolChoice.(ToolChoice); !forced {
+			cfg.relaxToolChoice()
+		}
+	default:
+		return fmt.Errorf("unknown response mode %q", mode)
+	}
+	return nil
+}
+
+// selectResponseMode picks the mode by the model catalog. If the model is
+// not there, e.g. with providers other than OpenRouter, the schema is used if
+// possible.
+func (c *ChatClient[T]) selectResponseMode(model string, schema *ParamDef) ResponseMode {
+	info, err := c.cfg.client.GetModel(model)
+	if err != nil && c.cfg.client.logger != nil {
+		c.cfg.client.logger.Log("Failed to get model catalog: ", err.Error())
+	}
+
+	strict := strictSchema(schema)
+	canUseTool := schema.Type == "object"
+
+	switch {
+	case info == nil:
+		if strict {
+			return ResponseModeSchema
+		}
+		return ResponseModeObject
+	case strict && info.Supports("structured_outputs"):
+		return ResponseModeSchema
+	case canUseTool && info.Supports("tools") && info.Supports("tool_choice"):
+		return ResponseModeTool
+	case strict && info.Supports("response_format"):
+		// Not guaranteed to be followed, but better than nothing
+		return ResponseModeSchema
+	default:
+		return ResponseModeObject
+	}
+}
+
+// strictSchema reports whether the schema can be used in strict mode, which
+// requires all properties of every object to be known.
+func strictSchema(def *ParamDef) bool {
+	if def.Type == "object" && (def.AdditionProperties || def.Properties == nil) {
+		return false
+	}
+
+	for _, prop := range def.Properties {
+		if p, ok := prop.(*ParamDef); ok && !strictSchema(p) {
+			return false
+		}
+	}
+	if items, ok := def.Items.(*ParamDef); ok {
+		return strictSchema(items)
+	}
+	return true
+}
+
+// relaxToolChoice lets the model decide after a forced round, otherwise it
+// would have to call tools forever. In the tool response mode the model
+// still has to call a tool, the respond one at the latest.
+func (c *callConfig) relaxToolChoice() {
+	req := c.req
+	switch {
+	case len(req.Tools) == 0:
+	case c.respond && len(req.Tools) == 1:
+		req.ToolChoice = NewToolChoice(respondToolName)
+	case c.respond:
+		req.ToolChoice = ToolChoiceRequired
+	case req.ToolChoice != ToolChoiceAuto && req.ToolChoice != ToolChoiceNone:
+		req.ToolChoice = ToolChoiceAuto
+	}
+}
+
+// forceAnswer makes the model answer without calling more tools.
+func (c *callConfig) forceAnswer() {
+	if c.respond {
+		c.req.ToolChoice = NewToolChoice(respondToolName)
+	} else {
+		c.req.ToolChoice = ToolChoiceNone
+	}
+}
+
+// respondCall returns the call of the respond tool in the message, if any.
+func (c *callConfig) respondCall(msg *Message) *ToolCall {
+	if !c.respond || msg == nil {
+		return nil
+	}
+
+	for i := range msg.ToolCalls {
+		if msg.ToolCalls[i].Function.Name == respondToolName {
+			return &msg.ToolCalls[i]
+		}
+	}
+	return nil
+}
@@ -0,0 +1,49 @@
+package llm
+
+import (
+	"encoding/json"
+	"net/http"
+	"testing"
+)
+
+func TestSchemaName(t *testing.T) {
+	var name string
+	c := newTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+		var req Request
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			t.Error(err)
+		}
+		name = ""
+		if schema := req.ResponseFormat.JSONSchema; schema != nil {
+			name = schema.Name
+		}
+		writeMessage(w, Message{Role: "assistant", Content: `{"answer": "yes"}`}, FinishReasonStop)
+	})
+
+	chat := NewChatClientWithClient[confidenceResult](c, nil)
+	chat.SetModel("m")
+	chat.AddMessage("user", "Is it?")
+
+	tests := []struct {
+		name string
+		mode ResponseMode
+		opts []Option
+		want string
+	}{
+		{"default", "", nil, "response"},
+		{"named", "", []Option{WithSchemaName("verdict")}, "verdict"},
+		{"schema mode", ResponseModeSchema, []Option{WithSchemaName("verdict")}, "verdict"},
+		// The schema of the client is not renamed by the calls
+		{"default again", "", nil, "response"},
+	}
+
+	for _, tt := range tests {
+		chat.SetResponseMode(tt.mode)
+		if _, err := chat.GetResponse(nil, tt.opts...); err != nil {
+			t.Fatal(err)
+		}
+		if name != tt.want {
+			t.Errorf("%s: got schema name %q, want %q", tt.name, name, tt.want)
+		}
+	}
+}
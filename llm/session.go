@@ -0,0 +1,211 @@
+package llm
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"reflect"
+	"sync"
+)
+
+// Session records conversations as a tree of messages. Chat clients attached
+// to a session with SetSession add their messages to it, so branches made
+// with Fork, Rewind and EditTurn are kept and a UI can navigate them.
+//
+// The tree is saved as a flat list of nodes with parent references:
+//
+//	{"nodes": [{"id": 0, "parent": -1, "message": {...}}, ...]}
+type Session struct {
+	mu    sync.Mutex
+	nodes []*SessionNode
+}
+
+type SessionNode struct {
+	ID int `json:"id"`
+	// -1 for the first messages of conversations
+	Parent  int     `json:"parent"`
+	Message Message `json:"message"`
+
+	children []int
+}
+
+const noNode = -1
+
+func NewSession() *Session {
+	return &Session{}
+}
+
+// LoadSession reads a session saved with Save.
+func LoadSession(r io.Reader) (*Session, error) {
+	var data struct {
+		Nodes []*SessionNode `json:"nodes"`
+	}
+	if err := json.NewDecoder(r).Decode(&data); err != nil {
+		return nil, err
+	}
+
+	s := NewSession()
+	for i, node := range data.Nodes {
+		if node == nil || node.ID != i || node.Parent < noNode || node.Parent >= i {
+			return nil, fmt.Errorf("invalid session node %d", i)
+		}
+		s.nodes = append(s.nodes, node)
+		if node.Parent != noNode {
+			parent := s.nodes[node.Parent]
+			parent.children = append(parent.children, node.ID)
+		}
+	}
+	return s, nil
+}
+
+func (s *Session) Save(w io.Writer) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(map[string]any{"nodes": s.nodes})
+}
+
+// Node returns a copy of the node, or nil if there's no such node.
+func (s *Session) Node(id int) *SessionNode {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if id < 0 || id >= len(s.nodes) {
+		return nil
+	}
+	node := *s.nodes[id]
+	node.children = nil
+	return &node
+}
+
+// Children returns IDs of the replies to the node, or of the first messages
+// of all conversations if id is -1. More than one child is a branch point.
+func (s *Session) Children(id int) []int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if id == noNode {
+		var roots []int
+		for _, node := range s.nodes {
+			if node.Parent == noNode {
+				roots = append(roots, node.ID)
+			}
+		}
+		return roots
+	}
+
+	if id < 0 || id >= len(s.nodes) {
+		return nil
+	}
+	return append([]int(nil), s.nodes[id].children...)
+}
+
+// Leaves returns IDs of the last messages of all branches.
+func (s *Session) Leaves() []int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var leaves []int
+	for _, node := range s.nodes {
+		if len(node.children) == 0 {
+			leaves = append(leaves, node.ID)
+		}
+	}
+	return leaves
+}
+
+// Path returns the messages from the start of the conversation to the node.
+func (s *Session) Path(id int) []Message {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	ids := s.pathIDs(id)
+	messages := make([]Message, 0, len(ids))
+	for _, id := range ids {
+		messages = append(messages, cloneMessage(s.nodes[id].Message))
+	}
+	return messages
+}
+
+func (s *Session) pathIDs(id int) []int {
+	var ids []int
+	for id >= 0 && id < len(s.nodes) {
+		ids = append(ids, id)
+		id = s.nodes[id].Parent
+	}
+
+	for i, j := 0, len(ids)-1; i < j; i, j = i+1, j-1 {
+		ids[i], ids[j] = ids[j], ids[i]
+	}
+	return ids
+}
+
+// record makes the tree contain the messages as a path and returns the ID
+// of the last one. head is the last recorded message of the client, the
+// first keep messages are known to be the same as on its path.
+func (s *Session) record(head int, messages []Message, keep int) int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	path := s.pathIDs(head)
+
+	common := min(keep, len(path), len(messages))
+	for common < len(path) && common < len(messages) &&
+		reflect.DeepEqual(s.nodes[path[common]].Message, messages[common]) {
+		common++
+	}
+
+	current := noNode
+	if common > 0 {
+		current = path[common-1]
+	}
+
+	for _, msg := range messages[common:] {
+		current = s.child(current, msg)
+	}
+	return current
+}
+
+// extend adds the messages after the node and returns the ID of the last one.
+func (s *Session) extend(head int, messages []Message) int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, msg := range messages {
+		head = s.child(head, msg)
+	}
+	return head
+}
+
+// child returns the child of the node with the message, adding it if needed.
+func (s *Session) child(parent int, msg Message) int {
+	var siblings []int
+	if parent == noNode {
+		for _, node := range s.nodes {
+			if node.Parent == noNode {
+				siblings = append(siblings, node.ID)
+			}
+		}
+	} else {
+		siblings = s.nodes[parent].children
+	}
+
+	for _, id := range siblings {
+		if reflect.DeepEqual(s.nodes[id].Message, msg) {
+			return id
+		}
+	}
+
+	node := &SessionNode{
+		ID:      len(s.nodes),
+		Parent:  parent,
+		Message: cloneMessage(msg),
+	}
+	s.nodes = append(s.nodes, node)
+	if parent != noNode {
+		s.nodes[parent].children = append(s.nodes[parent].children, node.ID)
+	}
+	return node.ID
+}
@@ -2,79 +2,189 @@ package llm
 
 import (
 	"bufio"
+	"bytes"
+	"errors"
 	"io"
 	"strconv"
 	"strings"
+	"time"
 )
 
+// DefaultMaxEventSize limits the size of an event, so that a broken stream
+// without blank lines can't use all memory.
+const DefaultMaxEventSize = 16 << 20
+
+var ErrEventTooLarge = errors.New("SSE event is too large")
+
 type SSEEvent struct {
 	Event string
 	Data  string
-	ID    string
+	// Last event ID of the stream, it's kept for the following events
+	ID string
+	// Reconnection time in milliseconds, if the event sets it
 	Retry int
 }
 
+// SSEReader parses server-sent events as specified by the HTML standard:
+// lines end with CRLF, LF or CR, field values are kept as is except for a
+// single space after the colon, and an event ends with a blank line. An
+// event cut off by the end of the stream is discarded.
+//
+// Unlike in browsers, events without data are returned too, so that changes
+// of the ID and retry are seen.
 type SSEReader struct {
 	reader *bufio.Reader
 
-	lastEventID   string
-	reconnectTime int
+	maxEventSize int
+	// The previous line ended with CR, so LF is skipped
+	skipLF  bool
+	started bool
+
+	// The ID field is kept for the next events until it's changed
+	idBuffer    string
+	lastEventID string
+	retry       time.Duration
 }
 
 func NewSSEReader(reader io.Reader) *SSEReader {
 	return &SSEReader{
-		reader: bufio.NewReader(reader),
+		reader:       bufio.NewReader(reader),
+		maxEventSize: DefaultMaxEventSize,
 	}
 }
 
+// SetMaxEventSize sets the limit of the size of events in bytes, see
+// DefaultMaxEventSize. ReadEvent returns ErrEventTooLarge if it's exceeded.
+func (r *SSEReader) SetMaxEventSize(size int) {
+	r.maxEventSize = size
+}
+
+// LastEventID returns ID of the last returned event, to be sent in the
+// Last-Event-ID header when reconnecting.
+func (r *SSEReader) LastEventID() string {
+	return r.lastEventID
+}
+
+// Retry returns the reconnection time set by the server, or 0 if it's not set.
+func (r *SSEReader) Retry() time.Duration {
+	return r.retry
+}
+
+// ReadEvent returns the next event, or io.EOF at the end of the stream.
 func (r *SSEReader) ReadEvent() (*SSEEvent, error) {
 	event := &SSEEvent{}
+	var data strings.Builder
+	hasFields := false
+	size := 0
+
 	for {
-		line, err := r.reader.ReadBytes('\n')
+		line, err := r.readLine(r.maxEventSize - size)
 		if err != nil {
 			return nil, err
 		}
 
-		lineStr := strings.TrimSpace(string(line))
-
-		if len(lineStr) == 0 {
-			if event.Event != "" || event.Data != "" {
-				event.ID = r.lastEventID
-
-				event.Data, _ = strings.CutSuffix(event.Data, "\n")
-
-				return event, nil
+		if len(line) == 0 {
+			if !hasFields {
+				// Comments and unknown fields before the event don't count
+				size = 0
+				continue
 			}
 
-			continue
+			r.lastEventID = r.idBuffer
+			event.ID = r.lastEventID
+			event.Data, _ = strings.CutSuffix(data.String(), "\n")
+			return event, nil
 		}
 
-		if lineStr[0] == ':' {
+		// Comments are often sent as keep-alives and don't count either
+		if line[0] == ':' {
 			continue
 		}
+		size += len(line)
 
-		var field, value string
-		if strings.Contains(lineStr, ":") {
-			field, value, _ = strings.Cut(lineStr, ":")
-			value, _ = strings.CutPrefix(value, " ")
-		} else {
-			field = lineStr
-			value = ""
+		field, value, found := bytes.Cut(line, []byte(":"))
+		if found {
+			value, _ = bytes.CutPrefix(value, []byte(" "))
 		}
 
-		switch field {
+		switch string(field) {
 		case "event":
-			event.Event = value
+			event.Event = string(value)
 		case "data":
-			event.Data += value + "\n"
+			data.Write(value)
+			data.WriteByte('\n')
 		case "id":
-			if !strings.Contains(value, "\x00") {
-				r.lastEventID = value
+			if bytes.IndexByte(value, 0) >= 0 {
+				continue
 			}
+			r.idBuffer = string(value)
 		case "retry":
-			if retry, err := strconv.Atoi(value); err == nil {
-				r.reconnectTime = retry
+			retry, ok := parseRetry(value)
+			if !ok {
+				continue
+			}
+			event.Retry = retry
+			r.retry = time.Duration(retry) * time.Millisecond
+		default:
+			continue
+		}
+		hasFields = true
+	}
+}
+
+// readLine returns the next line without the line ending. A line cut off by
+// the end of the stream is returned as io.EOF.
+func (r *SSEReader) readLine(limit int) ([]byte, error) {
+	if !r.started {
+		r.started = true
+		// Byte order mark is ignored at the start of the stream
+		if first, err := r.reader.Peek(1); err == nil && first[0] == 0xef {
+			if bom, err := r.reader.Peek(3); err == nil && string(bom) == "\ufeff" {
+				_, _ = r.reader.Discard(3)
 			}
 		}
 	}
+
+	var line []byte
+	for {
+		b, err := r.reader.ReadByte()
+		if err != nil {
+			return nil, err
+		}
+
+		if r.skipLF {
+			r.skipLF = false
+			if b == '\n' {
+				continue
+			}
+		}
+
+		switch b {
+		case '\r':
+			r.skipLF = true
+			return line, nil
+		case '\n':
+			return line, nil
+		}
+
+		if len(line) >= limit {
+			return nil, ErrEventTooLarge
+		}
+		line = append(line, b)
+	}
+}
+
+// parseRetry parses the retry field, which must consist of ASCII digits only.
+func parseRetry(value []byte) (int, bool) {
+	if len(value) == 0 {
+		return 0, false
+	}
+	for _, b := range value {
+		if b < '0' || b > '9' {
+			return 0, false
+		}
+	}
+
+	retry, err := strconv.Atoi(string(value))
+	return retry, err == nil
 }
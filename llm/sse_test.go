@@ -0,0 +1,167 @@
+package llm
+
+import (
+	"errors"
+	"io"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func readEvents(r *SSEReader) ([]SSEEvent, error) {
+	var events []SSEEvent
+	for {
+		event, err := r.ReadEvent()
+		if err != nil {
+			return events, err
+		}
+		events = append(events, *event)
+	}
+}
+
+func TestSSEReader(t *testing.T) {
+	tests := []struct {
+		name  string
+		input string
+		want  []SSEEvent
+	}{
+		{
+			name:  "LF",
+			input: "event: a\ndata: 1\ndata: 2\n\ndata:3\n\n",
+			want:  []SSEEvent{{Event: "a", Data: "1\n2"}, {Data: "3"}},
+		},
+		{
+			name:  "CRLF",
+			input: "event: a\r\ndata: 1\r\ndata: 2\r\n\r\ndata: 3\r\n\r\n",
+			want:  []SSEEvent{{Event: "a", Data: "1\n2"}, {Data: "3"}},
+		},
+		{
+			name:  "bare CR",
+			input: "event: a\rdata: 1\rdata: 2\r\rdata: 3\r\r",
+			want:  []SSEEvent{{Event: "a", Data: "1\n2"}, {Data: "3"}},
+		},
+		{
+			name:  "mixed line endings",
+			input: "data: 1\r\ndata: 2\rdata: 3\n\r\n",
+			want:  []SSEEvent{{Data: "1\n2\n3"}},
+		},
+		{
+			name:  "id-only events",
+			input: "id: 1\n\ndata: a\n\nid\n\n",
+			want:  []SSEEvent{{ID: "1"}, {ID: "1", Data: "a"}, {}},
+		},
+		{
+			name:  "id with NUL is ignored",
+			input: "id: 1\ndata: a\n\nid: 2\x00\ndata: b\n\n",
+			want:  []SSEEvent{{ID: "1", Data: "a"}, {ID: "1", Data: "b"}},
+		},
+		{
+			name:  "retry",
+			input: "retry: 1000\n\nretry: 1s\ndata: a\n\n",
+			want:  []SSEEvent{{Retry: 1000}, {Data: "a"}},
+		},
+		{
+			name:  "trailing event without blank line",
+			input: "data: 1\n\ndata: 2\n",
+			want:  []SSEEvent{{Data: "1"}},
+		},
+		{
+			name:  "trailing line without line ending",
+			input: "data: 1\n\ndata: 2",
+			want:  []SSEEvent{{Data: "1"}},
+		},
+		{
+			name:  "leading BOM",
+			input: "\ufeffdata: 1\n\n",
+			want:  []SSEEvent{{Data: "1"}},
+		},
+		{
+			name:  "BOM after the start",
+			input: "data: 1\n\n\ufeffdata: 2\n\n",
+			want:  []SSEEvent{{Data: "1"}},
+		},
+		{
+			name:  "comments and blank lines",
+			input: ": keep-alive\n\n\n: x\ndata: 1\n: y\n\n",
+			want:  []SSEEvent{{Data: "1"}},
+		},
+		{
+			name:  "value without space",
+			input: "data:  1\ndata\n\n",
+			want:  []SSEEvent{{Data: " 1\n"}},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			events, err := readEvents(NewSSEReader(strings.NewReader(tt.input)))
+			if err != io.EOF {
+				t.Fatalf("got error %v", err)
+			}
+			if !reflect.DeepEqual(events, tt.want) {
+				t.Errorf("got %+v, want %+v", events, tt.want)
+			}
+		})
+	}
+}
+
+func TestSSEReaderMaxEventSize(t *testing.T) {
+	// Keep-alives don't add up to the size of the next event
+	input := strings.Repeat(": keep-alive\n\n", 100) + strings.Repeat("\n", 100) + "data: 1\n\n"
+	r := NewSSEReader(strings.NewReader(input))
+	r.SetMaxEventSize(32)
+	events, err := readEvents(r)
+	if err != io.EOF || len(events) != 1 {
+		t.Errorf("got %+v, %v", events, err)
+	}
+
+	r = NewSSEReader(strings.NewReader("data: 1234\ndata: 5678\n\n"))
+	r.SetMaxEventSize(16)
+	if _, err := r.ReadEvent(); !errors.Is(err, ErrEventTooLarge) {
+		t.Errorf("got error %v, want ErrEventTooLarge", err)
+	}
+
+	r = NewSSEReader(strings.NewReader("data: " + strings.Repeat("x", 100)))
+	r.SetMaxEventSize(16)
+	if _, err := r.ReadEvent(); !errors.Is(err, ErrEventTooLarge) {
+		t.Errorf("got error %v, want ErrEventTooLarge", err)
+	}
+}
+
+func FuzzSSEReader(f *testing.F) {
+	f.Add("event: a\ndata: 1\ndata: 2\n\n")
+	f.Add("\ufeffid: 1\r\n\r\n: comment\rdata\r\r")
+	f.Add("retry: 10\nid: a\x00\n\ndata: x")
+
+	f.Fuzz(func(t *testing.T, input string) {
+		const maxEventSize = 64
+		r := NewSSEReader(strings.NewReader(input))
+		r.SetMaxEventSize(maxEventSize)
+
+		events, err := readEvents(r)
+		if err != io.EOF && !errors.Is(err, ErrEventTooLarge) {
+			t.Fatalf("unexpected error %v", err)
+		}
+		for _, event := range events {
+			if len(event.Event)+len(event.Data)+len(event.ID) > 3*maxEventSize {
+				t.Errorf("event %+v is larger than the limit", event)
+			}
+			if strings.ContainsAny(event.Event+event.Data+event.ID, "\r") {
+				t.Errorf("event %+v contains CR", event)
+			}
+		}
+
+		// Line endings don't matter
+		if strings.Contains(input, "\r") {
+			return
+		}
+		for _, ending := range []string{"\r\n", "\r"} {
+			r := NewSSEReader(strings.NewReader(strings.ReplaceAll(input, "\n", ending)))
+			r.SetMaxEventSize(maxEventSize)
+			got, gotErr := readEvents(r)
+			if !reflect.DeepEqual(got, events) || gotErr != err {
+				t.Errorf("with %q line endings got %+v, %v, want %+v, %v", ending, got, gotErr, events, err)
+			}
+		}
+	})
+}
@@ -0,0 +1,399 @@
+package llm
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"sync/atomic"
+	"time"
+)
+
+// DefaultStreamIdleTimeout is used if RetryPolicy.StreamIdleTimeout is not
+// set. OpenRouter sends keep-alive comments while the model is busy, so a
+// long silence means the connection is lost.
+const DefaultStreamIdleTimeout = 2 * time.Minute
+
+var ErrStreamIdle = errors.New("no data in the stream for too long")
+
+var errStreamClosed = errors.New("stream is closed")
+
+// errStreamDone is returned by read at [DONE]
+var errStreamDone = errors.New("stream is done")
+
+// StreamError is returned when a stream fails after it started: the provider
+// reports an error in the stream, or the stream breaks and can't be resumed.
+type StreamError struct {
+	// Error reported by the provider, empty if the stream broke
+	Message string
+	// Response received before the error, may be nil
+	Partial *Response
+	// Cause of a broken stream, e.g. ErrStreamIdle or io.ErrUnexpectedEOF
+	Err error
+}
+
+func (e *StreamError) Error() string {
+	if e.Message != "" {
+		return "stream error: " + e.Message
+	}
+	return fmt.Sprintf("stream broken: %v", e.Err)
+}
+
+func (e *StreamError) Unwrap() error {
+	return e.Err
+}
+
+// ResponseStream reads a streamed response chunk by chunk. It doesn't start
+// goroutines, so a reader that stops early only has to call Close.
+//
+// A stream broken by the network or the idle timeout is resumed up to
+// RetryPolicy.MaxStreamResumes times: with the last event ID if the provider
+// sent one, or by sending the request again with the partial response as
+// prefill.
+type ResponseStream struct {
+	client *Client
+	ctx    context.Context
+	req    *Request
+	// Request of the current connection
+	current  *Request
+	cacheKey string
+
+	body   io.ReadCloser
+	reader *SSEReader
+	// Chunks of a cached response
+	replay []*Response
+
+	response    *Response
+	lastEventID string
+	resumes     int
+	// io.EOF after the end of the response
+	err error
+}
+
+// Stream sends the request and returns the stream of the response. The
+// stream must be closed.
+func (c *Client) Stream(ctx context.Context, req *Request) (*ResponseStream, error) {
+	// The request may be shared, e.g. by concurrent calls of chat clients
+	streamReq := *req
+	streamReq.Stream = true
+
+	s := &ResponseStream{
+		client:  c,
+		ctx:     ctx,
+		req:     &streamReq,
+		current: &streamReq,
+	}
+
+	var cached *Response
+	s.cacheKey, cached = c.cachedResponse(req)
+	if cached != nil {
+		s.replay = replayChunks(cached)
+		s.response = cached
+		s.err = io.EOF
+		s.cacheKey = ""
+		return s, nil
+	}
+
+	if err := s.open(); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// Recv returns the next chunk, or io.EOF after the last one. Errors in the
+// stream are returned as StreamError.
+func (s *ResponseStream) Recv() (*Response, error) {
+	if len(s.replay) > 0 {
+		chunk := s.replay[0]
+		s.replay = s.replay[1:]
+		return chunk, nil
+	}
+
+	for s.err == nil {
+		if s.reader == nil {
+			if err := s.open(); err != nil {
+				s.end(err)
+				continue
+			}
+		}
+
+		chunk, err := s.read()
+		if err != nil {
+			s.end(err)
+			continue
+		}
+		if chunk != nil {
+			return chunk, nil
+		}
+	}
+
+	if s.err == io.EOF && s.cacheKey != "" {
+		s.client.cacheResponse(s.cacheKey, s.response)
+		s.cacheKey = ""
+	}
+	return nil, s.err
+}
+
+// Response returns the chunks merged so far, the whole response after Recv
+// returns io.EOF.
+func (s *ResponseStream) Response() *Response {
+	return s.response
+}
+
+// Close stops reading the stream.
+func (s *ResponseStream) Close() error {
+	s.closeBody()
+	if s.err == nil {
+		s.err = errStreamClosed
+	}
+	s.replay = nil
+	return nil
+}
+
+func (s *ResponseStream) open() error {
+	var headers map[string]string
+	if s.lastEventID != "" {
+		headers = map[string]string{"Last-Event-ID": s.lastEventID}
+	}
+
+	httpResp, err := s.client.sendRequest(s.ctx, s.current, s.client.baseURL+"chat/completions", headers)
+	if err != nil {
+		return err
+	}
+
+	if httpResp.StatusCode >= 400 || !hasContentType(httpResp, "text/event-stream") {
+		defer httpResp.Body.Close()
+		return decodeAPIError(httpResp)
+	}
+
+	timeout := time.Duration(s.client.retry.StreamIdleTimeout)
+	if timeout <= 0 {
+		timeout = DefaultStreamIdleTimeout
+	}
+	s.body = newIdleReader(httpResp.Body, timeout)
+	s.reader = NewSSEReader(s.body)
+	return nil
+}
+
+func (s *ResponseStream) closeBody() {
+	if s.body != nil {
+		_ = s.body.Close()
+		s.body = nil
+		s.reader = nil
+	}
+}
+
+// read reads the next event and returns the chunk, if the event has one.
+func (s *ResponseStream) read() (*Response, error) {
+	event, err := s.reader.ReadEvent()
+	if err != nil {
+		return nil, err
+	}
+	if event.ID != "" {
+		s.lastEventID = event.ID
+	}
+
+	if logger := s.client.logger; logger != nil && event.Data != "" {
+		logger.Log("Chunk: ", event.Data)
+	}
+
+	switch {
+	case event.Event == "error":
+		return nil, parseStreamError(event.Data)
+	case event.Event != "" || event.Data == "":
+		return nil, nil
+	case event.Data == "[DONE]":
+		return nil, errStreamDone
+	}
+
+	var chunk Response
+	if err := json.Unmarshal([]byte(event.Data), &chunk); err != nil {
+		// A corrupt chunk is not fixed by sending the request again
+		return nil, &StreamError{Err: fmt.Errorf("invalid chunk: %w", err)}
+	}
+	if chunk.Error.Message != "" {
+		return nil, &StreamError{Message: chunk.Error.Message}
+	}
+
+	s.response = mergeResponse(s.response, &chunk)
+	return &chunk, nil
+}
+
+// end handles the end of the connection: the response is complete, failed,
+// or the stream has to be resumed.
+func (s *ResponseStream) end(err error) {
+	s.closeBody()
+
+	var streamErr *StreamError
+	var apiErr *APIError
+	switch {
+	case errors.As(err, &streamErr):
+		streamErr.Partial = s.response
+		s.err = err
+		return
+	case err == errStreamDone || s.response.finished():
+		// Some providers close the stream without [DONE]
+		s.err = io.EOF
+		return
+	case s.ctx.Err() != nil:
+		s.err = s.ctx.Err()
+		return
+	case errors.As(err, &apiErr):
+		// The request of a resumed stream was rejected
+		s.err = err
+		return
+	case err == io.EOF:
+		err = io.ErrUnexpectedEOF
+	case !resumable(err):
+		s.err = &StreamError{Partial: s.response, Err: err}
+		return
+	}
+
+	if s.lastEventID == "" {
+		s.current = prefillRequest(s.req, s.response)
+	}
+	if s.resumes >= s.client.retry.MaxStreamResumes || s.current == nil {
+		s.err = &StreamError{Partial: s.response, Err: err}
+		return
+	}
+	s.resumes++
+
+	if logger := s.client.logger; logger != nil {
+		logger.Log("Resuming stream after ", err.Error())
+	}
+}
+
+// resumable reports whether the error is a broken connection, after which
+// the stream can be resumed.
+func resumable(err error) bool {
+	var netErr net.Error
+	return errors.Is(err, io.ErrUnexpectedEOF) || errors.Is(err, ErrStreamIdle) || errors.As(err, &netErr)
+}
+
+// SendStreamRequest streams the response and returns the merged response.
+// It sends the chunks to chunkChan, which must be read until it returns;
+// chunkChan belongs to the caller and is not closed. Use Stream to read the
+// chunks without a channel. See ResponseStream for errors and resumption of
+// broken streams.
+func (c *Client) SendStreamRequest(req *Request, chunkChan chan<- *Response) (*Response, error) {
+	return c.SendStreamRequestContext(context.Background(), req, chunkChan)
+}
+
+// SendStreamRequestContext works like SendStreamRequest. If the context is
+// canceled, it returns without waiting for the reader of chunkChan.
+func (c *Client) SendStreamRequestContext(ctx context.Context, req *Request, chunkChan chan<- *Response) (*Response, error) {
+	stream, err := c.Stream(ctx, req)
+	if err != nil {
+		return nil, err
+	}
+	defer stream.Close()
+
+	for {
+		chunk, err := stream.Recv()
+		if err == io.EOF {
+			return stream.Response(), nil
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		select {
+		case chunkChan <- chunk:
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+	}
+}
+
+func parseStreamError(data string) error {
+	var payload struct {
+		Error   ErrorDef `json:"error"`
+		Message string   `json:"message"`
+	}
+	if err := json.Unmarshal([]byte(data), &payload); err != nil || (payload.Error.Message == "" && payload.Message == "") {
+		return &StreamError{Message: data}
+	}
+	if payload.Error.Message != "" {
+		return &StreamError{Message: payload.Error.Message}
+	}
+	return &StreamError{Message: payload.Message}
+}
+
+// finished reports whether all choices have a finish reason.
+func (r *Response) finished() bool {
+	if r == nil {
+		return false
+	}
+	for _, choice := range r.Choices {
+		if choice.FinishReason == "" {
+			return false
+		}
+	}
+	return len(r.Choices) > 0
+}
+
+// prefillRequest returns the request with the partial response as an
+// assistant message to continue, or nil if it can't be continued: there are
+// several choices or tool calls, which can't be prefilled.
+func prefillRequest(req *Request, partial *Response) *Request {
+	if partial == nil {
+		return req
+	}
+	if len(partial.Choices) != 1 || req.N > 1 {
+		return nil
+	}
+
+	msg := partial.Choices[0].Message
+	if msg == nil || len(msg.ToolCalls) > 0 {
+		return nil
+	}
+	if msg.Content == "" {
+		return req
+	}
+
+	res := *req
+	res.Messages = append(cloneMessages(req.Messages), Message{
+		Role:    "assistant",
+		Content: msg.Content,
+	})
+	return &res
+}
+
+// idleReader closes the body if a read from it waits for the timeout. Only
+// reads count, so a slow reader of the stream doesn't make it idle.
+type idleReader struct {
+	body    io.ReadCloser
+	timeout time.Duration
+	timer   *time.Timer
+	expired atomic.Bool
+}
+
+func newIdleReader(body io.ReadCloser, timeout time.Duration) *idleReader {
+	r := &idleReader{
+		body:    body,
+		timeout: timeout,
+	}
+	r.timer = time.AfterFunc(timeout, func() {
+		r.expired.Store(true)
+		_ = body.Close()
+	})
+	r.timer.Stop()
+	return r
+}
+
+func (r *idleReader) Read(p []byte) (int, error) {
+	r.timer.Reset(r.timeout)
+	n, err := r.body.Read(p)
+	r.timer.Stop()
+	if err != nil && r.expired.Load() {
+		err = ErrStreamIdle
+	}
+	return n, err
+}
+
+func (r *idleReader) Close() error {
+	r.timer.Stop()
+	return r.body.Close()
+}
@@ -0,0 +1,423 @@
+package llm
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"runtime"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// checkGoroutines fails the test if goroutines started by it are still
+// running when it ends. It must be called before the test server is started,
+// so that the server is closed first.
+func checkGoroutines(t *testing.T) {
+	t.Helper()
+	before := runtime.NumGoroutine()
+	t.Cleanup(func() {
+		deadline := time.Now().Add(2 * time.Second)
+		for runtime.NumGoroutine() > before && time.Now().Before(deadline) {
+			time.Sleep(10 * time.Millisecond)
+		}
+		if n := runtime.NumGoroutine(); n > before {
+			buf := make([]byte, 1<<16)
+			buf = buf[:runtime.Stack(buf, true)]
+			t.Errorf("%d goroutines leaked:\n%s", n-before, buf)
+		}
+	})
+}
+
+func newTestClient(t *testing.T, handler http.HandlerFunc) *Client {
+	t.Helper()
+	srv := httptest.NewServer(handler)
+	t.Cleanup(srv.Close)
+
+	c := NewClient("token")
+	c.SetBaseURL(srv.URL)
+	c.client = srv.Client()
+	return c
+}
+
+func writeEvents(w http.ResponseWriter, events ...string) {
+	for _, event := range events {
+		fmt.Fprintf(w, "%s\n\n", event)
+	}
+	w.(http.Flusher).Flush()
+}
+
+func startStream(w http.ResponseWriter) {
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.WriteHeader(http.StatusOK)
+}
+
+func contentChunk(content string, finish FinishReason) string {
+	chunk := Response{Choices: []Choice{{Delta: &Message{Content: content}, FinishReason: finish}}}
+	data, _ := json.Marshal(chunk)
+	return "data: " + string(data)
+}
+
+func streamContent(t *testing.T, s *ResponseStream) (string, error) {
+	t.Helper()
+	var content strings.Builder
+	for {
+		chunk, err := s.Recv()
+		if err != nil {
+			return content.String(), err
+		}
+		content.WriteString(chunk.Choices[0].Delta.Content)
+	}
+}
+
+func TestStream(t *testing.T) {
+	checkGoroutines(t)
+	c := newTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+		startStream(w)
+		writeEvents(w, ": keep-alive", contentChunk("Hel", ""), contentChunk("lo", FinishReasonStop), "data: [DONE]")
+	})
+
+	s, err := c.Stream(context.Background(), &Request{Model: "m"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+
+	content, err := streamContent(t, s)
+	if err != io.EOF {
+		t.Fatalf("got error %v, want io.EOF", err)
+	}
+	if content != "Hello" {
+		t.Errorf("got content %q", content)
+	}
+	if got := s.Response().Choices[0].Message.Content; got != "Hello" {
+		t.Errorf("got merged content %q", got)
+	}
+}
+
+func TestStreamAPIError(t *testing.T) {
+	tests := []struct {
+		name        string
+		status      int
+		contentType string
+		body        string
+		wantMessage string
+		wantCode    int
+	}{
+		{"json error", http.StatusBadRequest, "application/json", `{"error":{"message":"bad model","code":400}}`, "bad model", 400},
+		{"html page", http.StatusOK, "text/html", "<html>proxy</html>", "<html>proxy</html>", 0},
+		{"plain text", http.StatusBadGateway, "text/plain", "upstream failed", "upstream failed", 0},
+		{"empty body", http.StatusOK, "application/json", "", "unexpected response of type application/json", 0},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			checkGoroutines(t)
+			c := newTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+				w.Header().Set("Content-Type", tt.contentType)
+				w.WriteHeader(tt.status)
+				io.WriteString(w, tt.body)
+			})
+
+			_, err := c.Stream(context.Background(), &Request{Model: "m"})
+			var apiErr *APIError
+			if !errors.As(err, &apiErr) {
+				t.Fatalf("got error %v, want APIError", err)
+			}
+			if apiErr.StatusCode != tt.status || apiErr.Message != tt.wantMessage || apiErr.Code != tt.wantCode {
+				t.Errorf("got %+v", apiErr)
+			}
+		})
+	}
+}
+
+func TestStreamErrorEvent(t *testing.T) {
+	checkGoroutines(t)
+	c := newTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+		startStream(w)
+		writeEvents(w, contentChunk("Hel", ""), "event: error\ndata: {\"error\":{\"message\":\"overloaded\"}}")
+	})
+
+	s, err := c.Stream(context.Background(), &Request{Model: "m"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+
+	content, err := streamContent(t, s)
+	var streamErr *StreamError
+	if !errors.As(err, &streamErr) {
+		t.Fatalf("got error %v, want StreamError", err)
+	}
+	if streamErr.Message != "overloaded" {
+		t.Errorf("got message %q", streamErr.Message)
+	}
+	if content != "Hel" || streamErr.Partial.Choices[0].Message.Content != "Hel" {
+		t.Errorf("got content %q, partial %+v", content, streamErr.Partial)
+	}
+}
+
+func TestStreamIdleTimeout(t *testing.T) {
+	checkGoroutines(t)
+	c := newTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+		startStream(w)
+		writeEvents(w, contentChunk("Hel", ""))
+		<-r.Context().Done()
+	})
+	c.SetRetryPolicy(RetryPolicy{StreamIdleTimeout: Duration(50 * time.Millisecond)})
+
+	s, err := c.Stream(context.Background(), &Request{Model: "m"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+
+	_, err = streamContent(t, s)
+	if !errors.Is(err, ErrStreamIdle) {
+		t.Fatalf("got error %v, want ErrStreamIdle", err)
+	}
+}
+
+func TestStreamIdleBeforeFirstChunk(t *testing.T) {
+	for _, resumes := range []int{0, 1} {
+		t.Run(fmt.Sprint(resumes, " resumes"), func(t *testing.T) {
+			checkGoroutines(t)
+			var requests atomic.Int32
+			c := newTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+				startStream(w)
+				if requests.Add(1) == 1 {
+					// The model is stuck before it sends anything
+					writeEvents(w)
+					<-r.Context().Done()
+					return
+				}
+				writeEvents(w, contentChunk("Hello", FinishReasonStop), "data: [DONE]")
+			})
+			c.SetRetryPolicy(RetryPolicy{
+				StreamIdleTimeout: Duration(50 * time.Millisecond),
+				MaxStreamResumes:  resumes,
+			})
+
+			s, err := c.Stream(context.Background(), &Request{Model: "m"})
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer s.Close()
+
+			content, err := streamContent(t, s)
+			if resumes == 0 {
+				var streamErr *StreamError
+				if !errors.As(err, &streamErr) || !errors.Is(err, ErrStreamIdle) || streamErr.Partial != nil {
+					t.Errorf("got error %v, want StreamError of ErrStreamIdle", err)
+				}
+				return
+			}
+			if err != io.EOF || content != "Hello" || requests.Load() != 2 {
+				t.Errorf("got %q, %v after %d requests", content, err, requests.Load())
+			}
+		})
+	}
+}
+
+func TestStreamSlowReader(t *testing.T) {
+	checkGoroutines(t)
+	var requests atomic.Int32
+	c := newTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+		requests.Add(1)
+		startStream(w)
+		for _, content := range []string{"a", "b", "c"} {
+			writeEvents(w, contentChunk(content, ""))
+			time.Sleep(20 * time.Millisecond)
+		}
+		writeEvents(w, contentChunk("", FinishReasonStop), "data: [DONE]")
+	})
+	c.SetRetryPolicy(RetryPolicy{StreamIdleTimeout: Duration(50 * time.Millisecond)})
+
+	s, err := c.Stream(context.Background(), &Request{Model: "m"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+
+	// The stream is not idle while its reader is busy
+	var content strings.Builder
+	for {
+		chunk, err := s.Recv()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		content.WriteString(chunk.Choices[0].Delta.Content)
+		time.Sleep(100 * time.Millisecond)
+	}
+	if content.String() != "abc" || requests.Load() != 1 {
+		t.Errorf("got %q after %d requests", content.String(), requests.Load())
+	}
+}
+
+func TestStreamResume(t *testing.T) {
+	checkGoroutines(t)
+	requests := 0
+	c := newTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		var req Request
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			t.Error(err)
+		}
+
+		startStream(w)
+		if requests == 1 {
+			// The connection breaks without [DONE] or a finish reason
+			writeEvents(w, contentChunk("Hel", ""))
+			return
+		}
+
+		last := req.Messages[len(req.Messages)-1]
+		if last.Role != "assistant" || last.Content != "Hel" {
+			t.Errorf("resumed without prefill: %+v", last)
+		}
+		writeEvents(w, contentChunk("lo", FinishReasonStop), "data: [DONE]")
+	})
+	c.SetRetryPolicy(RetryPolicy{MaxStreamResumes: 1})
+
+	s, err := c.Stream(context.Background(), &Request{Model: "m", Messages: []Message{{Role: "user", Content: "Hi"}}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+
+	content, err := streamContent(t, s)
+	if err != io.EOF || content != "Hello" || requests != 2 {
+		t.Errorf("got %q, %v after %d requests", content, err, requests)
+	}
+}
+
+func TestStreamCorruptChunk(t *testing.T) {
+	checkGoroutines(t)
+	requests := 0
+	c := newTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		startStream(w)
+		writeEvents(w, contentChunk("Hel", ""), "data: {\"choices\": [")
+	})
+	c.SetRetryPolicy(RetryPolicy{MaxStreamResumes: 2})
+
+	s, err := c.Stream(context.Background(), &Request{Model: "m"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+
+	_, err = streamContent(t, s)
+	var streamErr *StreamError
+	if !errors.As(err, &streamErr) || streamErr.Partial == nil {
+		t.Fatalf("got error %v, want StreamError with partial response", err)
+	}
+	if requests != 1 {
+		t.Errorf("corrupt stream was resumed, %d requests", requests)
+	}
+}
+
+func TestStreamEarlyClose(t *testing.T) {
+	checkGoroutines(t)
+	c := newTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+		startStream(w)
+		for i := 0; ; i++ {
+			writeEvents(w, contentChunk(fmt.Sprint(i), ""))
+			select {
+			case <-r.Context().Done():
+				return
+			case <-time.After(10 * time.Millisecond):
+			}
+		}
+	})
+
+	s, err := c.Stream(context.Background(), &Request{Model: "m"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.Recv(); err != nil {
+		t.Fatal(err)
+	}
+	s.Close()
+
+	if _, err := s.Recv(); err == nil {
+		t.Error("Recv after Close succeeded")
+	}
+}
+
+func TestSendStreamRequestCanceled(t *testing.T) {
+	checkGoroutines(t)
+	c := newTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+		startStream(w)
+		writeEvents(w, contentChunk("Hel", ""), contentChunk("lo", FinishReasonStop), "data: [DONE]")
+	})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	chunkChan := make(chan *Response)
+	done := make(chan error)
+	go func() {
+		_, err := c.SendStreamRequestContext(ctx, &Request{Model: "m"}, chunkChan)
+		done <- err
+	}()
+
+	// The consumer reads one chunk and goes away
+	<-chunkChan
+	cancel()
+
+	select {
+	case err := <-done:
+		if !errors.Is(err, context.Canceled) {
+			t.Errorf("got error %v, want context.Canceled", err)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("SendStreamRequestContext is blocked by the consumer")
+	}
+
+	// The channel belongs to the caller and stays open
+	select {
+	case _, ok := <-chunkChan:
+		if !ok {
+			t.Error("chunkChan is closed")
+		}
+	default:
+	}
+}
+
+func TestChatClientStream(t *testing.T) {
+	checkGoroutines(t)
+	c := newTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+		startStream(w)
+		writeEvents(w, contentChunk("Hel", ""), contentChunk("lo", FinishReasonStop), "data: [DONE]")
+	})
+
+	chat := NewChatClientWithClient[string](c, nil)
+	chat.SetModel("m")
+	chat.AddMessage("user", "Hi")
+
+	chunkChan := make(chan string)
+	var streamed strings.Builder
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for chunk := range chunkChan {
+			streamed.WriteString(chunk)
+		}
+	}()
+
+	result, err := chat.GetResponse(chunkChan)
+	<-done
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result != "Hello" || streamed.String() != "Hello" {
+		t.Errorf("got %q, streamed %q", result, streamed.String())
+	}
+}
@@ -0,0 +1,121 @@
+package tools
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"os/exec"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/xe0r/llm-stuff/llm"
+)
+
+type RunCommandRequest struct {
+	Command []string `json:"command" desc:"Executable and its arguments, e.g. [\"go\", \"test\", \"./...\"]. It's not run through a shell."`
+	Dir     string   `json:"dir,omitempty" desc:"Working directory relative to the root directory."`
+	Stdin   string   `json:"stdin,omitempty" desc:"Input for the command."`
+}
+
+type RunCommandResponse struct {
+	ExitCode  int    `json:"exit_code"`
+	Stdout    string `json:"stdout,omitempty"`
+	Stderr    string `json:"stderr,omitempty"`
+	TimedOut  bool   `json:"timed_out,omitempty"`
+	Truncated bool   `json:"truncated,omitempty"`
+	DryRun    bool   `json:"dry_run,omitempty"`
+	Error     string `json:"error,omitempty"`
+}
+
+// commandWaitDelay is how long run_command waits for the output after the
+// command exits or times out.
+const commandWaitDelay = time.Second
+
+// cappedBuffer keeps the first limit bytes written to it and discards the
+// rest, so that the command doesn't fail on a closed pipe.
+type cappedBuffer struct {
+	buf       bytes.Buffer
+	limit     int
+	truncated bool
+}
+
+func (b *cappedBuffer) Write(p []byte) (int, error) {
+	if n := b.limit - b.buf.Len(); len(p) > n {
+		b.buf.Write(p[:max(n, 0)])
+		b.truncated = true
+		return len(p), nil
+	}
+	return b.buf.Write(p)
+}
+
+// RunCommand returns the run_command tool. Note that only the working
+// directory is confined to the root, the command itself can access anything
+// the current user can, so it runs only commands listed in AllowCommands.
+func (t *Toolbox) RunCommand() llm.CallableFunction {
+	return llm.NewCallableFunction("run_command", "Runs a command in the root directory and returns its output.", t.runCommand)
+}
+
+func (t *Toolbox) commandAllowed(name string) bool {
+	name = filepath.Base(name)
+	if slices.Contains(t.cfg.DenyCommands, name) {
+		return false
+	}
+	return slices.Contains(t.cfg.AllowCommands, name)
+}
+
+func (t *Toolbox) runCommand(req *RunCommandRequest) *RunCommandResponse {
+	if len(req.Command) == 0 {
+		return &RunCommandResponse{Error: "command is empty"}
+	}
+
+	// Paths would allow to bypass the lists with a binary of the same name
+	if strings.ContainsAny(req.Command[0], `/\`) || !t.commandAllowed(req.Command[0]) {
+		return &RunCommandResponse{Error: "command " + req.Command[0] + " is not allowed"}
+	}
+
+	dir, _, err := t.resolve(req.Dir)
+	if err != nil {
+		return &RunCommandResponse{Error: err.Error()}
+	}
+
+	if t.cfg.DryRun {
+		return &RunCommandResponse{DryRun: true}
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), t.cfg.Timeout)
+	defer cancel()
+
+	stdout := &cappedBuffer{limit: t.cfg.MaxOutput}
+	stderr := &cappedBuffer{limit: t.cfg.MaxOutput}
+	cmd := exec.CommandContext(ctx, req.Command[0], req.Command[1:]...)
+	cmd.Dir = dir
+	cmd.Stdin = strings.NewReader(req.Stdin)
+	cmd.Stdout = stdout
+	cmd.Stderr = stderr
+	// Background processes started by the command may keep the output open
+	cmd.WaitDelay = commandWaitDelay
+
+	err = cmd.Run()
+
+	resp := &RunCommandResponse{
+		Stdout:    stdout.buf.String(),
+		Stderr:    stderr.buf.String(),
+		Truncated: stdout.truncated || stderr.truncated,
+	}
+
+	var exitErr *exec.ExitError
+	switch {
+	case ctx.Err() == context.DeadlineExceeded:
+		resp.TimedOut = true
+		resp.ExitCode = -1
+	case errors.As(err, &exitErr):
+		resp.ExitCode = exitErr.ExitCode()
+	case errors.Is(err, exec.ErrWaitDelay):
+		resp.ExitCode = cmd.ProcessState.ExitCode()
+	case err != nil:
+		resp.Error = err.Error()
+	}
+	return resp
+}
@@ -0,0 +1,75 @@
+package tools
+
+import (
+	"bytes"
+	"os/exec"
+	"testing"
+	"time"
+)
+
+func TestCappedBuffer(t *testing.T) {
+	b := &cappedBuffer{limit: 10}
+	chunk := bytes.Repeat([]byte("x"), 4)
+	for i := 0; i < 1000; i++ {
+		if n, err := b.Write(chunk); n != len(chunk) || err != nil {
+			t.Fatalf("Write returned %d, %v", n, err)
+		}
+	}
+	if b.buf.Len() != 10 || !b.truncated {
+		t.Errorf("got %d bytes, truncated %v", b.buf.Len(), b.truncated)
+	}
+}
+
+func TestRunCommandOutputLimit(t *testing.T) {
+	if _, err := exec.LookPath("sh"); err != nil {
+		t.Skip("sh is not available")
+	}
+	tb := newTestToolbox(t, nil)
+	tb.cfg.MaxOutput = 100
+	tb.cfg.AllowCommands = []string{"sh"}
+
+	resp := tb.runCommand(&RunCommandRequest{Command: []string{"sh", "-c", "yes | head -c 1000000; echo err >&2"}})
+	if resp.Error != "" || resp.ExitCode != 0 {
+		t.Fatalf("got %+v", resp)
+	}
+	if len(resp.Stdout) != 100 || resp.Stderr != "err\n" || !resp.Truncated {
+		t.Errorf("got %d bytes of stdout, stderr %q, truncated %v", len(resp.Stdout), resp.Stderr, resp.Truncated)
+	}
+}
+
+func TestRunCommandBackgroundProcess(t *testing.T) {
+	if _, err := exec.LookPath("sh"); err != nil {
+		t.Skip("sh is not available")
+	}
+	tb := newTestToolbox(t, nil)
+	tb.cfg.AllowCommands = []string{"sh"}
+
+	// The sleep keeps stdout open after sh exits
+	start := time.Now()
+	resp := tb.runCommand(&RunCommandRequest{Command: []string{"sh", "-c", "sleep 10 & echo started"}})
+	if elapsed := time.Since(start); elapsed > 5*time.Second {
+		t.Errorf("run_command waited %v for the background process", elapsed)
+	}
+	if resp.Error != "" || resp.ExitCode != 0 || resp.Stdout != "started\n" {
+		t.Errorf("got %+v", resp)
+	}
+}
+
+func TestRunCommandNotAllowed(t *testing.T) {
+	tb := newTestToolbox(t, nil)
+
+	// Nothing can be run by default
+	for _, command := range [][]string{{"sh", "-c", "true"}, {"go", "version"}, {"rm", "-rf", "."}} {
+		if resp := tb.runCommand(&RunCommandRequest{Command: command}); resp.Error == "" {
+			t.Errorf("%v was run: %+v", command, resp)
+		}
+	}
+
+	tb.cfg.AllowCommands = []string{"go", "sh"}
+	tb.cfg.DenyCommands = []string{"sh"}
+	for _, command := range [][]string{{"sh", "-c", "true"}, {"rm", "x"}, {"/bin/go", "version"}} {
+		if resp := tb.runCommand(&RunCommandRequest{Command: command}); resp.Error == "" {
+			t.Errorf("%v was run: %+v", command, resp)
+		}
+	}
+}
@@ -0,0 +1,362 @@
+package tools
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	"github.com/xe0r/llm-stuff/llm"
+)
+
+type ReadFileRequest struct {
+	Path   string `json:"path" desc:"Path of the file relative to the root directory."`
+	Offset int    `json:"offset,omitempty" desc:"Line to start reading from, 1-based."`
+	Limit  int    `json:"limit,omitempty" desc:"Maximum number of lines to read."`
+}
+
+type ReadFileResponse struct {
+	Content    string `json:"content,omitempty"`
+	TotalLines int    `json:"total_lines,omitempty"`
+	Truncated  bool   `json:"truncated,omitempty"`
+	Error      string `json:"error,omitempty"`
+}
+
+func (t *Toolbox) ReadFile() llm.CallableFunction {
+	return llm.NewCallableFunction("read_file", "Reads a text file. Use offset and limit to read a part of a large file.", t.readFile)
+}
+
+func (t *Toolbox) readFile(req *ReadFileRequest) *ReadFileResponse {
+	abs, _, err := t.resolve(req.Path)
+	if err != nil {
+		return &ReadFileResponse{Error: err.Error()}
+	}
+
+	info, err := os.Stat(abs)
+	if err != nil {
+		return &ReadFileResponse{Error: err.Error()}
+	}
+	if info.IsDir() {
+		return &ReadFileResponse{Error: "path is a directory, use list_dir"}
+	}
+	if info.Size() > t.cfg.MaxFileSize && req.Limit == 0 {
+		return &ReadFileResponse{Error: fmt.Sprintf("file is too large (%d bytes), use offset and limit", info.Size())}
+	}
+
+	file, err := os.Open(abs)
+	if err != nil {
+		return &ReadFileResponse{Error: err.Error()}
+	}
+	defer file.Close()
+
+	var sb strings.Builder
+	resp := &ReadFileResponse{}
+
+	scanner := bufio.NewScanner(file)
+	scanner.Buffer(make([]byte, 0, 64*1024), int(t.cfg.MaxFileSize))
+	for scanner.Scan() {
+		resp.TotalLines++
+		if resp.TotalLines < req.Offset || resp.Truncated {
+			continue
+		}
+		if req.Limit > 0 && resp.TotalLines >= max(req.Offset, 1)+req.Limit {
+			resp.Truncated = true
+			continue
+		}
+		if sb.Len()+len(scanner.Bytes()) > t.cfg.MaxOutput {
+			resp.Truncated = true
+			continue
+		}
+
+		sb.Write(scanner.Bytes())
+		sb.WriteByte('\n')
+	}
+	if err := scanner.Err(); err != nil {
+		return &ReadFileResponse{Error: err.Error()}
+	}
+
+	resp.Content = sb.String()
+	return resp
+}
+
+type ListDirRequest struct {
+	Path string `json:"path" desc:"Path of the directory relative to the root directory, \".\" for the root."`
+}
+
+type DirEntry struct {
+	Name string `json:"name"`
+	Type string `json:"type" desc:"file, dir or symlink"`
+	Size int64  `json:"size,omitempty"`
+}
+
+type ListDirResponse struct {
+	Entries   []DirEntry `json:"entries,omitempty"`
+	Truncated bool       `json:"truncated,omitempty"`
+	Error     string     `json:"error,omitempty"`
+}
+
+func (t *Toolbox) ListDir() llm.CallableFunction {
+	return llm.NewCallableFunction("list_dir", "Lists entries of a directory.", t.listDir)
+}
+
+func (t *Toolbox) listDir(req *ListDirRequest) *ListDirResponse {
+	abs, rel, err := t.resolve(req.Path)
+	if err != nil {
+		return &ListDirResponse{Error: err.Error()}
+	}
+
+	entries, err := os.ReadDir(abs)
+	if err != nil {
+		return &ListDirResponse{Error: err.Error()}
+	}
+
+	resp := &ListDirResponse{
+		Entries: make([]DirEntry, 0, len(entries)),
+	}
+	for _, entry := range entries {
+		if !t.allowed(joinRel(rel, entry.Name())) {
+			continue
+		}
+		if len(resp.Entries) >= t.cfg.MaxResults {
+			resp.Truncated = true
+			break
+		}
+
+		e := DirEntry{
+			Name: entry.Name(),
+			Type: "file",
+		}
+		switch {
+		case entry.Type()&fs.ModeSymlink != 0:
+			e.Type = "symlink"
+		case entry.IsDir():
+			e.Type = "dir"
+		default:
+			if info, err := entry.Info(); err == nil {
+				e.Size = info.Size()
+			}
+		}
+		resp.Entries = append(resp.Entries, e)
+	}
+	return resp
+}
+
+func joinRel(dir, name string) string {
+	if dir == "." {
+		return name
+	}
+	return dir + "/" + name
+}
+
+type GlobRequest struct {
+	Pattern string `json:"pattern" desc:"Glob pattern relative to the root directory, \"**\" matches any number of directories, e.g. \"src/**/*.go\"."`
+}
+
+type GlobResponse struct {
+	Matches   []string `json:"matches,omitempty"`
+	Truncated bool     `json:"truncated,omitempty"`
+	Error     string   `json:"error,omitempty"`
+}
+
+func (t *Toolbox) Glob() llm.CallableFunction {
+	return llm.NewCallableFunction("glob", "Finds files matching a glob pattern.", t.glob)
+}
+
+var errStopWalk = errors.New("stop")
+
+// walk visits allowed files under the root, skipping denied directories.
+func (t *Toolbox) walk(dir string, fn func(abs, rel string, entry fs.DirEntry) error) error {
+	abs, _, err := t.resolve(dir)
+	if err != nil {
+		return err
+	}
+
+	err = filepath.WalkDir(abs, func(p string, entry fs.DirEntry, err error) error {
+		if err != nil {
+			return nil
+		}
+
+		rel, err := filepath.Rel(t.root, p)
+		if err != nil {
+			return err
+		}
+		rel = filepath.ToSlash(rel)
+
+		if !t.allowed(rel) {
+			if entry.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if entry.IsDir() {
+			return nil
+		}
+		// A symlink may point to a denied file
+		if entry.Type()&fs.ModeSymlink != 0 {
+			if _, _, err := t.resolve(rel); err != nil {
+				return nil
+			}
+		}
+		return fn(p, rel, entry)
+	})
+	if err == errStopWalk {
+		return nil
+	}
+	return err
+}
+
+func (t *Toolbox) glob(req *GlobRequest) *GlobResponse {
+	if _, err := filepath.Match(strings.ReplaceAll(req.Pattern, "**", "*"), ""); err != nil {
+		return &GlobResponse{Error: err.Error()}
+	}
+
+	resp := &GlobResponse{}
+	err := t.walk(".", func(abs, rel string, entry fs.DirEntry) error {
+		if !matchGlob(req.Pattern, rel) {
+			return nil
+		}
+		if len(resp.Matches) >= t.cfg.MaxResults {
+			resp.Truncated = true
+			return errStopWalk
+		}
+		resp.Matches = append(resp.Matches, rel)
+		return nil
+	})
+	if err != nil {
+		return &GlobResponse{Error: err.Error()}
+	}
+	return resp
+}
+
+type GrepRequest struct {
+	Pattern string `json:"pattern" desc:"Regular expression in Go syntax."`
+	Path    string `json:"path,omitempty" desc:"Directory to search in, the root directory by default."`
+	Glob    string `json:"glob,omitempty" desc:"Search only files matching this glob pattern, e.g. \"**/*.go\"."`
+}
+
+type GrepMatch struct {
+	Path string `json:"path"`
+	Line int    `json:"line"`
+	Text string `json:"text"`
+}
+
+type GrepResponse struct {
+	Matches   []GrepMatch `json:"matches,omitempty"`
+	Truncated bool        `json:"truncated,omitempty"`
+	Error     string      `json:"error,omitempty"`
+}
+
+func (t *Toolbox) Grep() llm.CallableFunction {
+	return llm.NewCallableFunction("grep", "Searches text files for lines matching a regular expression.", t.grep)
+}
+
+const maxGrepLineLength = 500
+
+func (t *Toolbox) grep(req *GrepRequest) *GrepResponse {
+	re, err := regexp.Compile(req.Pattern)
+	if err != nil {
+		return &GrepResponse{Error: err.Error()}
+	}
+
+	resp := &GrepResponse{}
+	err = t.walk(req.Path, func(abs, rel string, entry fs.DirEntry) error {
+		if req.Glob != "" && !matchGlob(req.Glob, rel) {
+			return nil
+		}
+
+		info, err := entry.Info()
+		if err != nil || info.Size() > t.cfg.MaxFileSize || !entry.Type().IsRegular() {
+			return nil
+		}
+
+		content, err := os.ReadFile(abs)
+		if err != nil || isBinary(content) {
+			return nil
+		}
+
+		for i, line := range strings.Split(string(content), "\n") {
+			if !re.MatchString(line) {
+				continue
+			}
+			if len(resp.Matches) >= t.cfg.MaxResults {
+				resp.Truncated = true
+				return errStopWalk
+			}
+
+			line, _ = truncate(line, maxGrepLineLength)
+			resp.Matches = append(resp.Matches, GrepMatch{
+				Path: rel,
+				Line: i + 1,
+				Text: line,
+			})
+		}
+		return nil
+	})
+	if err != nil {
+		return &GrepResponse{Error: err.Error()}
+	}
+	return resp
+}
+
+func isBinary(content []byte) bool {
+	return bytes.IndexByte(content[:min(len(content), 8000)], 0) >= 0
+}
+
+type WriteFileRequest struct {
+	Path    string `json:"path" desc:"Path of the file relative to the root directory."`
+	Content string `json:"content" desc:"New content of the file."`
+}
+
+type WriteFileResponse struct {
+	Written int    `json:"written,omitempty"`
+	Created bool   `json:"created,omitempty"`
+	DryRun  bool   `json:"dry_run,omitempty"`
+	Error   string `json:"error,omitempty"`
+}
+
+func (t *Toolbox) WriteFile() llm.CallableFunction {
+	return llm.NewCallableFunction("write_file", "Creates or overwrites a file, creating parent directories as needed.", t.writeFile)
+}
+
+func (t *Toolbox) writeFile(req *WriteFileRequest) *WriteFileResponse {
+	abs, _, err := t.resolve(req.Path)
+	if err != nil {
+		return &WriteFileResponse{Error: err.Error()}
+	}
+
+	if int64(len(req.Content)) > t.cfg.MaxFileSize {
+		return &WriteFileResponse{Error: fmt.Sprintf("content is too large, limit is %d bytes", t.cfg.MaxFileSize)}
+	}
+
+	resp := &WriteFileResponse{
+		Written: len(req.Content),
+		DryRun:  t.cfg.DryRun,
+	}
+
+	info, err := os.Stat(abs)
+	switch {
+	case errors.Is(err, fs.ErrNotExist):
+		resp.Created = true
+	case err != nil:
+		return &WriteFileResponse{Error: err.Error()}
+	case info.IsDir():
+		return &WriteFileResponse{Error: "path is a directory"}
+	}
+
+	if t.cfg.DryRun {
+		return resp
+	}
+
+	if err := os.MkdirAll(filepath.Dir(abs), 0755); err != nil {
+		return &WriteFileResponse{Error: err.Error()}
+	}
+	if err := os.WriteFile(abs, []byte(req.Content), 0644); err != nil {
+		return &WriteFileResponse{Error: err.Error()}
+	}
+	return resp
+}
@@ -0,0 +1,413 @@
+package tools
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/xe0r/llm-stuff/llm"
+)
+
+type ApplyPatchRequest struct {
+	Patch string `json:"patch" desc:"Patch in unified diff format with --- and +++ file headers and @@ hunks. Paths are relative to the root directory, a/ and b/ prefixes are allowed."`
+}
+
+type PatchedFile struct {
+	Path   string `json:"path"`
+	Action string `json:"action" desc:"created, modified or deleted"`
+}
+
+type ApplyPatchResponse struct {
+	Files  []PatchedFile `json:"files,omitempty"`
+	DryRun bool          `json:"dry_run,omitempty"`
+	Error  string        `json:"error,omitempty"`
+}
+
+func (t *Toolbox) ApplyPatch() llm.CallableFunction {
+	return llm.NewCallableFunction("apply_patch", "Applies a unified diff to one or more files. Either all files are changed or none.", t.applyPatch)
+}
+
+type filePatch struct {
+	oldPath string
+	newPath string
+	hunks   []hunk
+}
+
+type hunk struct {
+	oldStart int
+	oldLines []string
+	newLines []string
+}
+
+const devNull = "/dev/null"
+
+func (t *Toolbox) applyPatch(req *ApplyPatchRequest) *ApplyPatchResponse {
+	patches, err := parsePatch(req.Patch)
+	if err != nil {
+		return &ApplyPatchResponse{Error: err.Error()}
+	}
+
+	// Patches of the same file are applied one after another
+	var changes []*fileChange
+	byPath := make(map[string]*fileChange)
+
+	// All patches are checked before anything is written
+	for _, patch := range patches {
+		path := patch.newPath
+		action := "modified"
+		switch {
+		case patch.oldPath == devNull:
+			action = "created"
+		case patch.newPath == devNull:
+			path = patch.oldPath
+			action = "deleted"
+		}
+
+		abs, rel, err := t.resolve(path)
+		if err != nil {
+			return &ApplyPatchResponse{Error: fmt.Sprintf("%s: %v", path, err)}
+		}
+
+		c := byPath[abs]
+		if c == nil {
+			c, err = t.readChange(abs, rel)
+			if err != nil {
+				return &ApplyPatchResponse{Error: err.Error()}
+			}
+			changes = append(changes, c)
+			byPath[abs] = c
+		}
+
+		switch action {
+		case "created":
+			if c.content != nil {
+				return &ApplyPatchResponse{Error: fmt.Sprintf("%s: file already exists", rel)}
+			}
+			newContent, err := applyHunks(nil, patch.hunks)
+			if err != nil {
+				return &ApplyPatchResponse{Error: fmt.Sprintf("%s: %v", rel, err)}
+			}
+			c.content = &newContent
+		case "modified":
+			if c.content == nil {
+				return &ApplyPatchResponse{Error: fmt.Sprintf("%s: file does not exist", rel)}
+			}
+			newContent, err := applyHunks(splitLines(*c.content), patch.hunks)
+			if err != nil {
+				return &ApplyPatchResponse{Error: fmt.Sprintf("%s: %v", rel, err)}
+			}
+			c.content = &newContent
+		case "deleted":
+			if c.content == nil {
+				return &ApplyPatchResponse{Error: fmt.Sprintf("%s: file does not exist", rel)}
+			}
+			c.content = nil
+		}
+	}
+
+	resp := &ApplyPatchResponse{
+		DryRun: t.cfg.DryRun,
+	}
+	for _, c := range changes {
+		if action := c.action(); action != "" {
+			resp.Files = append(resp.Files, PatchedFile{Path: c.rel, Action: action})
+		}
+	}
+
+	if t.cfg.DryRun {
+		return resp
+	}
+	if err := commitChanges(changes); err != nil {
+		return &ApplyPatchResponse{Error: err.Error()}
+	}
+	return resp
+}
+
+// fileChange is the content of a file before and after a patch.
+type fileChange struct {
+	abs string
+	rel string
+	// Content before the patch, if the file exists
+	original *string
+	// Content after the patch, nil if the file is deleted
+	content *string
+
+	// Temporary file with the new content
+	temp string
+}
+
+// readChange reads the file to patch, it may not exist.
+func (t *Toolbox) readChange(abs, rel string) (*fileChange, error) {
+	c := &fileChange{abs: abs, rel: rel}
+
+	data, err := os.ReadFile(abs)
+	switch {
+	case errors.Is(err, fs.ErrNotExist):
+		return c, nil
+	case err != nil:
+		return nil, err
+	case int64(len(data)) > t.cfg.MaxFileSize:
+		return nil, fmt.Errorf("%s: file is too large", rel)
+	}
+
+	content := string(data)
+	c.original = &content
+	c.content = &content
+	return c, nil
+}
+
+func (c *fileChange) action() string {
+	switch {
+	case c.original == nil && c.content != nil:
+		return "created"
+	case c.original != nil && c.content == nil:
+		return "deleted"
+	case c.original != nil:
+		return "modified"
+	}
+	// Created and deleted by the same patch
+	return ""
+}
+
+// commitChanges writes the new contents to temporary files first and then
+// moves them in place, so that either all files are changed or none. Files
+// already changed when a move fails are restored.
+func commitChanges(changes []*fileChange) error {
+	defer func() {
+		for _, c := range changes {
+			if c.temp != "" {
+				_ = os.Remove(c.temp)
+			}
+		}
+	}()
+
+	for _, c := range changes {
+		if c.content == nil {
+			continue
+		}
+		if err := os.MkdirAll(filepath.Dir(c.abs), 0755); err != nil {
+			return err
+		}
+		temp, err := writeTemp(filepath.Dir(c.abs), *c.content)
+		if err != nil {
+			return err
+		}
+		c.temp = temp
+	}
+
+	for i, c := range changes {
+		var err error
+		switch {
+		case c.content != nil:
+			err = os.Rename(c.temp, c.abs)
+			if err == nil {
+				c.temp = ""
+			}
+		case c.original != nil:
+			err = os.Remove(c.abs)
+		}
+
+		if err != nil {
+			for _, done := range changes[:i] {
+				done.restore()
+			}
+			return err
+		}
+	}
+	return nil
+}
+
+func writeTemp(dir, content string) (string, error) {
+	f, err := os.CreateTemp(dir, ".apply_patch-*")
+	if err != nil {
+		return "", err
+	}
+
+	_, err = f.WriteString(content)
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Chmod(f.Name(), 0644)
+	}
+	if err != nil {
+		_ = os.Remove(f.Name())
+		return "", err
+	}
+	return f.Name(), nil
+}
+
+// restore puts back the content of the file before the patch.
+func (c *fileChange) restore() {
+	if c.original == nil {
+		_ = os.Remove(c.abs)
+		return
+	}
+	_ = os.WriteFile(c.abs, []byte(*c.original), 0644)
+}
+
+func splitLines(content string) []string {
+	if content == "" {
+		return nil
+	}
+	return strings.SplitAfter(content, "\n")
+}
+
+// parsePatch parses a unified diff. It's lenient about things models often
+// get wrong: missing "a/" prefixes, wrong line counts in hunk headers and
+// empty context lines without the leading space.
+func parsePatch(patch string) ([]*filePatch, error) {
+	var patches []*filePatch
+	var current *filePatch
+	var h *hunk
+
+	lines := strings.Split(strings.ReplaceAll(patch, "\r\n", "\n"), "\n")
+	for i := 0; i < len(lines); i++ {
+		line := lines[i]
+
+		switch {
+		case strings.HasPrefix(line, "--- ") && i+1 < len(lines) && strings.HasPrefix(lines[i+1], "+++ "):
+			current = &filePatch{
+				oldPath: patchPath(line[4:]),
+				newPath: patchPath(lines[i+1][4:]),
+			}
+			patches = append(patches, current)
+			h = nil
+			i++
+		case strings.HasPrefix(line, "@@"):
+			if current == nil {
+				return nil, fmt.Errorf("hunk without file header at line %d", i+1)
+			}
+			current.hunks = append(current.hunks, hunk{oldStart: parseHunkStart(line)})
+			h = &current.hunks[len(current.hunks)-1]
+		case h == nil:
+			// Preamble, "diff --git" and "index" lines
+		case strings.HasPrefix(line, "\\"):
+			// "\ No newline at end of file"
+		case strings.HasPrefix(line, "-"):
+			h.oldLines = append(h.oldLines, line[1:]+"\n")
+		case strings.HasPrefix(line, "+"):
+			h.newLines = append(h.newLines, line[1:]+"\n")
+		case strings.HasPrefix(line, " ") || line == "":
+			if line == "" && i == len(lines)-1 {
+				break
+			}
+			text := strings.TrimPrefix(line, " ") + "\n"
+			h.oldLines = append(h.oldLines, text)
+			h.newLines = append(h.newLines, text)
+		default:
+			h = nil
+		}
+	}
+
+	if len(patches) == 0 {
+		return nil, fmt.Errorf("no file headers found in patch")
+	}
+	for _, p := range patches {
+		if len(p.hunks) == 0 && p.newPath != devNull {
+			return nil, fmt.Errorf("%s: no hunks", p.newPath)
+		}
+	}
+	return patches, nil
+}
+
+func patchPath(s string) string {
+	// Timestamps are separated by a tab
+	s, _, _ = strings.Cut(s, "\t")
+	s = strings.TrimSpace(s)
+	if s == devNull {
+		return s
+	}
+	if strings.HasPrefix(s, "a/") || strings.HasPrefix(s, "b/") {
+		s = s[2:]
+	}
+	return s
+}
+
+func parseHunkStart(header string) int {
+	// @@ -start,count +start,count @@
+	fields := strings.Fields(header)
+	if len(fields) < 2 || !strings.HasPrefix(fields[1], "-") {
+		return 0
+	}
+
+	start, _, _ := strings.Cut(fields[1][1:], ",")
+	n, _ := strconv.Atoi(start)
+	return n
+}
+
+// applyHunks applies hunks in order. Each hunk is looked up near the line
+// given in its header, then anywhere after the previous hunk, first exactly
+// and then ignoring trailing whitespace.
+func applyHunks(lines []string, hunks []hunk) (string, error) {
+	pos := 0
+	// Lines added before the current hunk by the previous ones
+	offset := 0
+	for i, h := range hunks {
+		// A hunk without old lines inserts after its start line
+		start := h.oldStart - 1
+		if len(h.oldLines) == 0 {
+			start = h.oldStart
+		}
+
+		at := findHunk(lines, h.oldLines, pos, start+offset, false)
+		if at < 0 {
+			at = findHunk(lines, h.oldLines, pos, start+offset, true)
+		}
+		if at < 0 {
+			return "", fmt.Errorf("hunk %d does not apply", i+1)
+		}
+
+		// Missing newline at the end of the file is preserved
+		newLines := h.newLines
+		end := at + len(h.oldLines)
+		if end == len(lines) && end > 0 && len(newLines) > 0 && !strings.HasSuffix(lines[end-1], "\n") {
+			newLines = append([]string(nil), newLines...)
+			newLines[len(newLines)-1] = strings.TrimSuffix(newLines[len(newLines)-1], "\n")
+		}
+
+		res := make([]string, 0, len(lines)-len(h.oldLines)+len(newLines))
+		res = append(res, lines[:at]...)
+		res = append(res, newLines...)
+		res = append(res, lines[end:]...)
+		lines = res
+		pos = at + len(newLines)
+		offset = pos - start - len(h.oldLines)
+	}
+	return strings.Join(lines, ""), nil
+}
+
+func findHunk(lines, old []string, from, hint int, fuzzy bool) int {
+	matches := func(at int) bool {
+		if at < from || at+len(old) > len(lines) {
+			return false
+		}
+		for i, line := range old {
+			a, b := lines[at+i], line
+			if fuzzy {
+				a, b = strings.TrimRight(a, " \t\r\n"), strings.TrimRight(b, " \t\r\n")
+			} else {
+				a, b = strings.TrimSuffix(a, "\n"), strings.TrimSuffix(b, "\n")
+			}
+			if a != b {
+				return false
+			}
+		}
+		return true
+	}
+
+	hint = max(hint, from)
+	for d := 0; hint-d >= from || hint+d <= len(lines); d++ {
+		if matches(hint + d) {
+			return hint + d
+		}
+		if d > 0 && matches(hint-d) {
+			return hint - d
+		}
+	}
+	return -1
+}
@@ -0,0 +1,116 @@
+package tools
+
+import (
+	"io/fs"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func readTestFile(t *testing.T, tb *Toolbox, name string) string {
+	t.Helper()
+	content, err := os.ReadFile(filepath.Join(tb.root, filepath.FromSlash(name)))
+	if err != nil {
+		return "<" + err.Error() + ">"
+	}
+	return string(content)
+}
+
+func TestApplyPatchSameFileTwice(t *testing.T) {
+	tb := newTestToolbox(t, map[string]string{"a.txt": "one\ntwo\nthree\n"})
+
+	patch := "--- a/a.txt\n+++ b/a.txt\n@@ -1 +1 @@\n-one\n+ONE\n" +
+		"--- a/a.txt\n+++ b/a.txt\n@@ -3 +3 @@\n-three\n+THREE\n"
+	resp := tb.applyPatch(&ApplyPatchRequest{Patch: patch})
+	if resp.Error != "" {
+		t.Fatal(resp.Error)
+	}
+	if got := readTestFile(t, tb, "a.txt"); got != "ONE\ntwo\nTHREE\n" {
+		t.Errorf("got %q", got)
+	}
+	if len(resp.Files) != 1 || resp.Files[0] != (PatchedFile{Path: "a.txt", Action: "modified"}) {
+		t.Errorf("got files %+v", resp.Files)
+	}
+}
+
+func TestApplyPatchInsertion(t *testing.T) {
+	tests := []struct {
+		name  string
+		patch string
+		want  string
+	}{
+		{"after a line", "@@ -2,0 +3 @@\n+new\n", "one\ntwo\nnew\nthree\n"},
+		{"at the start", "@@ -0,0 +1 @@\n+new\n", "new\none\ntwo\nthree\n"},
+		{"at the end", "@@ -3,0 +4,2 @@\n+new\n+end\n", "one\ntwo\nthree\nnew\nend\n"},
+		// Line numbers of the second hunk don't count the lines added by the first
+		{"after another hunk", "@@ -1,0 +2,2 @@\n+a\n+b\n@@ -2,0 +5 @@\n+c\n", "one\na\nb\ntwo\nc\nthree\n"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tb := newTestToolbox(t, map[string]string{"a.txt": "one\ntwo\nthree\n"})
+
+			resp := tb.applyPatch(&ApplyPatchRequest{Patch: "--- a/a.txt\n+++ b/a.txt\n" + tt.patch})
+			if resp.Error != "" {
+				t.Fatal(resp.Error)
+			}
+			if got := readTestFile(t, tb, "a.txt"); got != tt.want {
+				t.Errorf("got %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestApplyPatchAllOrNothing(t *testing.T) {
+	tests := []struct {
+		name  string
+		patch string
+	}{
+		{"hunk mismatch", "--- a/a.txt\n+++ b/a.txt\n@@ -1 +1 @@\n-one\n+ONE\n" +
+			"--- a/b.txt\n+++ b/b.txt\n@@ -1 +1 @@\n-missing\n+x\n"},
+		// c is created as a file and as a directory, which fails on writing
+		{"write failure", "--- a/a.txt\n+++ b/a.txt\n@@ -1 +1 @@\n-one\n+ONE\n" +
+			"--- /dev/null\n+++ b/c\n@@ -0,0 +1 @@\n+c\n" +
+			"--- /dev/null\n+++ b/c/d.txt\n@@ -0,0 +1 @@\n+d\n"},
+		{"delete and modify", "--- a/b.txt\n+++ /dev/null\n@@ -1 +0,0 @@\n-two\n" +
+			"--- a/b.txt\n+++ b/b.txt\n@@ -1 +1 @@\n-two\n+TWO\n"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tb := newTestToolbox(t, map[string]string{"a.txt": "one\n", "b.txt": "two\n"})
+
+			resp := tb.applyPatch(&ApplyPatchRequest{Patch: tt.patch})
+			if resp.Error == "" {
+				t.Fatal("patch succeeded")
+			}
+			if a, b := readTestFile(t, tb, "a.txt"), readTestFile(t, tb, "b.txt"); a != "one\n" || b != "two\n" {
+				t.Errorf("files changed to %q and %q", a, b)
+			}
+
+			filepath.WalkDir(tb.root, func(p string, entry fs.DirEntry, err error) error {
+				if err == nil && !entry.IsDir() && p != filepath.Join(tb.root, "a.txt") && p != filepath.Join(tb.root, "b.txt") {
+					t.Errorf("file %s left", p)
+				}
+				return nil
+			})
+		})
+	}
+}
+
+func TestApplyPatchCreateAndDelete(t *testing.T) {
+	tb := newTestToolbox(t, map[string]string{"old.txt": "old\n"})
+
+	patch := "--- /dev/null\n+++ b/dir/new.txt\n@@ -0,0 +1,2 @@\n+new\n+file\n" +
+		"--- a/old.txt\n+++ /dev/null\n@@ -1 +0,0 @@\n-old\n"
+	resp := tb.applyPatch(&ApplyPatchRequest{Patch: patch})
+	if resp.Error != "" {
+		t.Fatal(resp.Error)
+	}
+	if got := readTestFile(t, tb, "dir/new.txt"); got != "new\nfile\n" {
+		t.Errorf("got %q", got)
+	}
+	if _, err := os.Stat(filepath.Join(tb.root, "old.txt")); !os.IsNotExist(err) {
+		t.Errorf("old.txt not deleted: %v", err)
+	}
+}
@@ -0,0 +1,260 @@
+// Package tools provides ready-made functions for llm.ChatClient to inspect
+// and edit files and run commands. All of them are confined to a root directory.
+package tools
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/xe0r/llm-stuff/llm"
+)
+
+const (
+	DefaultMaxFileSize = 1 << 20
+	DefaultMaxOutput   = 64 << 10
+	DefaultMaxResults  = 500
+	DefaultTimeout     = time.Minute
+)
+
+// DefaultDeny keeps VCS internals and common secret files away from the model.
+var DefaultDeny = []string{".git", ".git/**", "**/.env", "**/*.pem", "**/*.key", "**/.token*", "**/.openrouter_token"}
+
+type Config struct {
+	// Directory the tools are confined to
+	Root string
+
+	// Glob patterns of paths relative to Root, with "/" separators and "**"
+	// matching any number of directories. If Allow is set, only matching paths
+	// are accessible. Deny wins over Allow.
+	Allow []string
+	Deny  []string
+
+	// Size limit of files to read or write
+	MaxFileSize int64
+	// Size limit of command output and other long responses
+	MaxOutput int
+	// Limit of entries returned by list_dir, glob and grep
+	MaxResults int
+
+	// Write tools report what they would do without changing anything,
+	// run_command doesn't run anything
+	DryRun bool
+
+	// Timeout of run_command
+	Timeout time.Duration
+	// Executable names run_command may run. If empty, nothing can be run.
+	// DenyCommands wins over AllowCommands.
+	AllowCommands []string
+	DenyCommands  []string
+}
+
+// Toolbox creates the tools for a config.
+type Toolbox struct {
+	cfg  Config
+	root string
+}
+
+func New(cfg Config) (*Toolbox, error) {
+	if cfg.Root == "" {
+		return nil, fmt.Errorf("root directory not set")
+	}
+
+	root, err := filepath.Abs(cfg.Root)
+	if err != nil {
+		return nil, err
+	}
+
+	root, err = filepath.EvalSymlinks(root)
+	if err != nil {
+		return nil, err
+	}
+
+	if info, err := os.Stat(root); err != nil {
+		return nil, err
+	} else if !info.IsDir() {
+		return nil, fmt.Errorf("%s is not a directory", root)
+	}
+
+	if cfg.Deny == nil {
+		cfg.Deny = DefaultDeny
+	}
+	if cfg.MaxFileSize <= 0 {
+		cfg.MaxFileSize = DefaultMaxFileSize
+	}
+	if cfg.MaxOutput <= 0 {
+		cfg.MaxOutput = DefaultMaxOutput
+	}
+	if cfg.MaxResults <= 0 {
+		cfg.MaxResults = DefaultMaxResults
+	}
+	if cfg.Timeout <= 0 {
+		cfg.Timeout = DefaultTimeout
+	}
+
+	return &Toolbox{
+		cfg:  cfg,
+		root: root,
+	}, nil
+}
+
+// ReadOnly returns the tools that don't change anything:
+// read_file, list_dir, glob and grep.
+func (t *Toolbox) ReadOnly() []llm.CallableFunction {
+	return []llm.CallableFunction{
+		t.ReadFile(),
+		t.ListDir(),
+		t.Glob(),
+		t.Grep(),
+	}
+}
+
+// All returns all tools, including write_file, apply_patch and run_command.
+func (t *Toolbox) All() []llm.CallableFunction {
+	return append(t.ReadOnly(),
+		t.WriteFile(),
+		t.ApplyPatch(),
+		t.RunCommand(),
+	)
+}
+
+var errOutsideRoot = errors.New("path is outside of the root directory")
+
+// resolve converts a path given by the model to an absolute path within the
+// root, following symlinks, and checks it against allow and deny lists.
+// It returns the absolute path and the path relative to the root.
+func (t *Toolbox) resolve(p string) (string, string, error) {
+	if p == "" {
+		p = "."
+	}
+
+	abs := p
+	if !filepath.IsAbs(abs) {
+		abs = filepath.Join(t.root, filepath.FromSlash(p))
+	}
+	abs = filepath.Clean(abs)
+
+	if !t.within(abs) {
+		return "", "", errOutsideRoot
+	}
+
+	// Symlinks may point outside or to denied files, check the real
+	// location of the longest existing prefix of the path
+	real := abs
+	existing := abs
+	suffix := ""
+	for {
+		resolved, err := filepath.EvalSymlinks(existing)
+		if err == nil {
+			real = filepath.Join(resolved, suffix)
+			if !t.within(real) {
+				return "", "", errOutsideRoot
+			}
+			break
+		}
+		if !errors.Is(err, fs.ErrNotExist) {
+			return "", "", err
+		}
+
+		parent := filepath.Dir(existing)
+		if parent == existing {
+			break
+		}
+		suffix = filepath.Join(filepath.Base(existing), suffix)
+		existing = parent
+	}
+
+	rel, err := filepath.Rel(t.root, abs)
+	if err != nil {
+		return "", "", err
+	}
+	rel = filepath.ToSlash(rel)
+
+	if !t.allowed(rel) {
+		return "", "", fmt.Errorf("access to %s is denied", rel)
+	}
+
+	if real != abs {
+		realRel, err := filepath.Rel(t.root, real)
+		if err != nil {
+			return "", "", err
+		}
+		if !t.allowed(filepath.ToSlash(realRel)) {
+			return "", "", fmt.Errorf("access to %s is denied, it links to a denied path", rel)
+		}
+	}
+	return abs, rel, nil
+}
+
+func (t *Toolbox) within(abs string) bool {
+	rel, err := filepath.Rel(t.root, abs)
+	if err != nil {
+		return false
+	}
+	return rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator))
+}
+
+func (t *Toolbox) allowed(rel string) bool {
+	if rel == "." {
+		return true
+	}
+
+	for _, pattern := range t.cfg.Deny {
+		if matchGlob(pattern, rel) {
+			return false
+		}
+	}
+
+	if len(t.cfg.Allow) == 0 {
+		return true
+	}
+
+	for _, pattern := range t.cfg.Allow {
+		if matchGlob(pattern, rel) {
+			return true
+		}
+	}
+	return false
+}
+
+// matchGlob matches a slash-separated path against a pattern where "**"
+// matches any number of path elements and other elements follow path.Match.
+func matchGlob(pattern, name string) bool {
+	return matchParts(strings.Split(pattern, "/"), strings.Split(name, "/"))
+}
+
+func matchParts(pattern, name []string) bool {
+	for len(pattern) > 0 {
+		if pattern[0] == "**" {
+			for i := 0; i <= len(name); i++ {
+				if matchParts(pattern[1:], name[i:]) {
+					return true
+				}
+			}
+			return false
+		}
+
+		if len(name) == 0 {
+			return false
+		}
+
+		if ok, err := path.Match(pattern[0], name[0]); err != nil || !ok {
+			return false
+		}
+		pattern = pattern[1:]
+		name = name[1:]
+	}
+	return len(name) == 0
+}
+
+func truncate(s string, limit int) (string, bool) {
+	if len(s) <= limit {
+		return s, false
+	}
+	return s[:limit], true
+}
@@ -0,0 +1,85 @@
+package tools
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func newTestToolbox(t *testing.T, files map[string]string) *Toolbox {
+	t.Helper()
+	root := t.TempDir()
+	for name, content := range files {
+		p := filepath.Join(root, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(p), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(p, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	tb, err := New(Config{Root: root})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return tb
+}
+
+func symlink(t *testing.T, tb *Toolbox, target, name string) {
+	t.Helper()
+	if err := os.Symlink(target, filepath.Join(tb.root, filepath.FromSlash(name))); err != nil {
+		t.Skipf("symlinks are not supported: %v", err)
+	}
+}
+
+func TestSymlinkToDeniedPath(t *testing.T) {
+	tb := newTestToolbox(t, map[string]string{
+		".env":        "SECRET=1\n",
+		".git/config": "[core]\n",
+		"main.go":     "package main\n",
+	})
+	symlink(t, tb, ".env", "env")
+	symlink(t, tb, ".git", "git")
+	symlink(t, tb, "main.go", "main_link.go")
+
+	for _, p := range []string{"env", "git/config", "git/new"} {
+		if resp := tb.readFile(&ReadFileRequest{Path: p}); resp.Error == "" {
+			t.Errorf("read_file %s: read %q", p, resp.Content)
+		}
+		if resp := tb.writeFile(&WriteFileRequest{Path: p, Content: "x"}); resp.Error == "" {
+			t.Errorf("write_file %s succeeded", p)
+		}
+	}
+
+	patch := "--- a/env\n+++ b/env\n@@ -1 +1 @@\n-SECRET=1\n+SECRET=2\n"
+	if resp := tb.applyPatch(&ApplyPatchRequest{Patch: patch}); resp.Error == "" {
+		t.Error("apply_patch through a symlink succeeded")
+	}
+	if content, _ := os.ReadFile(filepath.Join(tb.root, ".env")); string(content) != "SECRET=1\n" {
+		t.Errorf(".env changed to %q", content)
+	}
+
+	if resp := tb.grep(&GrepRequest{Pattern: "SECRET"}); len(resp.Matches) > 0 {
+		t.Errorf("grep found %+v", resp.Matches)
+	}
+	if resp := tb.glob(&GlobRequest{Pattern: "*"}); strings.Contains(strings.Join(resp.Matches, " "), "env") {
+		t.Errorf("glob found %v", resp.Matches)
+	}
+
+	// Symlinks to allowed files keep working
+	if resp := tb.readFile(&ReadFileRequest{Path: "main_link.go"}); resp.Error != "" {
+		t.Errorf("read_file main_link.go: %s", resp.Error)
+	}
+}
+
+func TestSymlinkOutsideRoot(t *testing.T) {
+	tb := newTestToolbox(t, nil)
+	outside := t.TempDir()
+	symlink(t, tb, outside, "out")
+
+	if resp := tb.writeFile(&WriteFileRequest{Path: "out/file", Content: "x"}); resp.Error == "" {
+		t.Error("write_file outside of the root succeeded")
+	}
+}
@@ -2,8 +2,31 @@
 
 package llm
 
-import "os"
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"runtime"
+	"strings"
+)
+
+const keyringService = "llm-stuff"
 
 func secureGetToken() (string, error) {
 	return "", os.ErrNotExist
 }
+
+func keyringGetToken(account string) (string, error) {
+	var cmd *exec.Cmd
+	if runtime.GOOS == "darwin" {
+		cmd = exec.Command("security", "find-generic-password", "-s", keyringService, "-a", account, "-w")
+	} else {
+		cmd = exec.Command("secret-tool", "lookup", "service", keyringService, "account", account)
+	}
+
+	out, err := cmd.Output()
+	if err != nil {
+		return "", fmt.Errorf("keyring lookup %s: %w", account, err)
+	}
+	return strings.TrimSpace(string(out)), nil
+}
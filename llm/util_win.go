@@ -5,6 +5,7 @@ package llm
 import (
 	"encoding/base64"
 	"os"
+	"path/filepath"
 	"unsafe"
 
 	"golang.org/x/sys/windows"
@@ -82,4 +83,29 @@ func secureGetToken() (string, error) {
 	}
 
 	return "", os.ErrNotExist
-}
\ No newline at end of file
+}
+
+// On Windows the keyring is a DPAPI-encrypted file in the config directory,
+// in the same format as .token.enc.
+func keyringGetToken(account string) (string, error) {
+	dir, err := os.UserConfigDir()
+	if err != nil {
+		return "", err
+	}
+
+	content, err := os.ReadFile(filepath.Join(dir, "llm-stuff", account+".token.enc"))
+	if err != nil {
+		return "", err
+	}
+
+	content, err = base64.StdEncoding.DecodeString(string(content))
+	if err != nil {
+		return "", err
+	}
+
+	data, err := decryptData(content)
+	if err != nil {
+		return "", err
+	}
+	return string(data), nil
+}
@@ -0,0 +1,196 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"os/signal"
+	"time"
+
+	"github.com/spf13/cobra"
+	"github.com/xe0r/llm-stuff/llm"
+	"github.com/xe0r/llm-stuff/llm/mcp"
+	"github.com/xe0r/llm-stuff/llm/mcpserver"
+	"github.com/xe0r/llm-stuff/llm/tools"
+)
+
+type timeRequest struct {
+	Timezone string `json:"timezone,omitempty" desc:"IANA time zone, e.g. Europe/Berlin. Local time zone if empty."`
+}
+
+type timeResponse struct {
+	Time  string `json:"time,omitempty"`
+	Error string `json:"error,omitempty"`
+}
+
+type toolsConfig struct {
+	root          string
+	write         bool
+	dryRun        bool
+	allowCommands []string
+}
+
+func getFuncs(cfg *toolsConfig) ([]llm.CallableFunction, error) {
+	funcs := []llm.CallableFunction{
+		llm.NewCallableFunction("get_current_time", "Returns the current time", func(req *timeRequest) *timeResponse {
+			loc := time.Local
+			if req.Timezone != "" {
+				var err error
+				loc, err = time.LoadLocation(req.Timezone)
+				if err != nil {
+					return &timeResponse{Error: err.Error()}
+				}
+			}
+			return &timeResponse{Time: time.Now().In(loc).Format(time.RFC3339)}
+		}),
+	}
+
+	if cfg.root == "" {
+		return funcs, nil
+	}
+
+	toolbox, err := tools.New(tools.Config{
+		Root:          cfg.root,
+		DryRun:        cfg.dryRun,
+		AllowCommands: cfg.allowCommands,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	if !cfg.write {
+		return append(funcs, toolbox.ReadOnly()...), nil
+	}
+
+	funcs = append(funcs, toolbox.ReadOnly()...)
+	funcs = append(funcs, toolbox.WriteFile(), toolbox.ApplyPatch())
+	if len(cfg.allowCommands) > 0 {
+		funcs = append(funcs, toolbox.RunCommand())
+	}
+	return funcs, nil
+}
+
+func newServer(cfg *toolsConfig) (*mcpserver.Server, error) {
+	funcs, err := getFuncs(cfg)
+	if err != nil {
+		return nil, err
+	}
+	return mcpserver.New("llm-stuff", "0.1.0", funcs), nil
+}
+
+func serve(ctx context.Context, addr string, cfg *toolsConfig) error {
+	server, err := newServer(cfg)
+	if err != nil {
+		return err
+	}
+
+	if addr == "" {
+		return server.ServeStdio(ctx, os.Stdin, os.Stdout)
+	}
+
+	mux := http.NewServeMux()
+	mux.Handle("/mcp", server)
+
+	httpServer := &http.Server{
+		Addr:    addr,
+		Handler: mux,
+	}
+
+	go func() {
+		<-ctx.Done()
+		_ = httpServer.Close()
+	}()
+
+	fmt.Fprintf(os.Stderr, "Serving MCP on http://%s/mcp\n", addr)
+	if err := httpServer.ListenAndServe(); err != http.ErrServerClosed {
+		return err
+	}
+	return nil
+}
+
+func check(ctx context.Context, url string, command []string, cfg *toolsConfig) error {
+	var client *mcp.Client
+	switch {
+	case url != "":
+		client = mcp.NewHTTPClient(url)
+	case len(command) > 0:
+		client = mcp.NewStdioClient(command[0], command[1:]...)
+	default:
+		// Check the built-in server
+		server, err := newServer(cfg)
+		if err != nil {
+			return err
+		}
+
+		listener, err := net.Listen("tcp", "127.0.0.1:0")
+		if err != nil {
+			return err
+		}
+		go func() { _ = http.Serve(listener, server) }()
+		defer listener.Close()
+
+		client = mcp.NewHTTPClient("http://" + listener.Addr().String())
+	}
+	defer client.Close()
+
+	client.SetTimeout(10 * time.Second)
+	client.SetMaxRestarts(0)
+
+	failed := 0
+	for _, res := range mcpserver.Check(ctx, client) {
+		if res.Err != nil {
+			failed++
+			fmt.Printf("FAIL %s: %v\n", res.Name, res.Err)
+		} else {
+			fmt.Printf("ok   %s\n", res.Name)
+		}
+	}
+
+	if failed > 0 {
+		return fmt.Errorf("%d checks failed", failed)
+	}
+	return nil
+}
+
+func main() {
+	var (
+		addr string
+		url  string
+		cfg  toolsConfig
+	)
+
+	ctx, cancel := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer cancel()
+
+	cmd := &cobra.Command{
+		Use:   "mcpserver",
+		Short: "Serve tools over the Model Context Protocol",
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return serve(ctx, addr, &cfg)
+		},
+	}
+	cmd.Flags().StringVar(&addr, "http", "", "Serve streamable HTTP on this address instead of stdio")
+	cmd.PersistentFlags().StringVar(&cfg.root, "root", "", "Serve filesystem tools confined to this directory")
+	cmd.PersistentFlags().BoolVar(&cfg.write, "write", false, "Also serve write_file and apply_patch, and run_command if commands are allowed")
+	cmd.PersistentFlags().BoolVar(&cfg.dryRun, "dry-run", false, "Write tools and run_command don't change anything")
+	cmd.PersistentFlags().StringSliceVar(&cfg.allowCommands, "allow-command", nil, "Command run_command may run, can be repeated")
+
+	checkCmd := &cobra.Command{
+		Use:   "check [-- command args...]",
+		Short: "Run conformance checks against an MCP server (the built-in one by default)",
+		// Failed checks are not usage errors
+		SilenceUsage: true,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return check(ctx, url, args, &cfg)
+		},
+	}
+	checkCmd.Flags().StringVar(&url, "url", "", "URL of a streamable HTTP server")
+	cmd.AddCommand(checkCmd)
+
+	if err := cmd.Execute(); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+}